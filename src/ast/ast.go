@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 
 	"github.com/ZeroBl21/go-monkey/src/token"
@@ -107,6 +108,7 @@ func (s *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // The { Token
 	Statements []Statement
+	Rbrace     token.Token // The closing } Token
 }
 
 func (s *BlockStatement) statementNode()       {}
@@ -135,6 +137,7 @@ func (i *Identifier) String() string       { return i.Value }
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // Set instead of Value when it doesn't fit in an int64
 }
 
 func (l *IntegerLiteral) expressionNode()      {}
@@ -159,6 +162,14 @@ func (l *Boolean) expressionNode()      {}
 func (l *Boolean) TokenLiteral() string { return l.Token.Literal }
 func (l *Boolean) String() string       { return l.Token.Literal }
 
+type NullLiteral struct {
+	Token token.Token // The 'null' token
+}
+
+func (l *NullLiteral) expressionNode()      {}
+func (l *NullLiteral) TokenLiteral() string { return l.Token.Literal }
+func (l *NullLiteral) String() string       { return l.Token.Literal }
+
 type ArrayLiteral struct {
 	Token    token.Token // The '[' token
 	Elements []Expression
@@ -183,7 +194,12 @@ func (l *ArrayLiteral) String() string {
 
 type HashLiteral struct {
 	Token token.Token // The '{' token
-	Pairs map[Expression]Expression
+	Pairs []HashPair  // In source order
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (l *HashLiteral) expressionNode()      {}
@@ -192,8 +208,8 @@ func (l *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range l.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range l.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -205,6 +221,7 @@ func (l *HashLiteral) String() string {
 
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
+	Name       string      // The name it is bound to by a let statement, if any
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
@@ -231,9 +248,10 @@ func (l *FunctionLiteral) String() string {
 // Expressions
 
 type IndexExpression struct {
-	Token token.Token // The '[' Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The '[' or '?[' Token
+	Left     Expression
+	Index    Expression
+	Optional bool // Indexing with '?[' short-circuits the chain on null
 }
 
 func (e *IndexExpression) expressionNode()      {}
@@ -243,13 +261,34 @@ func (e *IndexExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(e.Left.String())
-	out.WriteString("[")
+	out.WriteString(e.Token.Literal)
 	out.WriteString(e.Index.String())
 	out.WriteString("])")
 
 	return out.String()
 }
 
+type MemberExpression struct {
+	Token    token.Token // The '.' or '?.' token
+	Object   Expression
+	Property *Identifier
+	Optional bool // Access with '?.' short-circuits the chain on null
+}
+
+func (e *MemberExpression) expressionNode()      {}
+func (e *MemberExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *MemberExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(e.Object.String())
+	out.WriteString(e.Token.Literal)
+	out.WriteString(e.Property.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  //  Identifier or FunctionLiteral
@@ -338,3 +377,74 @@ func (e *IfExpression) String() string {
 
 	return out.String()
 }
+
+type ThrowExpression struct {
+	Token token.Token // The 'throw' token
+	Value Expression
+}
+
+func (e *ThrowExpression) expressionNode()      {}
+func (e *ThrowExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *ThrowExpression) String() string {
+	return e.TokenLiteral() + " " + e.Value.String()
+}
+
+// TryExpression evaluates to the value of Block or, when Block throws, to the
+// value of Catch, with the thrown value bound to Parameter. Finally runs
+// last either way. Catch and Finally are nil when their clause is absent,
+// but at least one of them is present.
+type TryExpression struct {
+	Token     token.Token // The 'try' token
+	Block     *BlockStatement
+	Parameter *Identifier
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (e *TryExpression) expressionNode()      {}
+func (e *TryExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(e.Block.String())
+
+	if e.Catch != nil {
+		out.WriteString("catch(")
+		out.WriteString(e.Parameter.String())
+		out.WriteString(") ")
+		out.WriteString(e.Catch.String())
+	}
+
+	if e.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(e.Finally.String())
+	}
+
+	return out.String()
+}
+
+type MacroLiteral struct {
+	Token      token.Token // The 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (l *MacroLiteral) expressionNode()      {}
+func (l *MacroLiteral) TokenLiteral() string { return l.Token.Literal }
+func (l *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range l.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(l.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	out.WriteString(l.Body.String())
+
+	return out.String()
+}
@@ -0,0 +1,671 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// Marshal encodes node and everything below it as JSON. Every node becomes an
+// object whose "kind" member names its type, followed by its token and its
+// fields:
+//
+//	{"kind": "Identifier", "token": {"type": "IDENT", "literal": "x",
+//	 "line": 1, "column": 5}, "value": "x"}
+func Marshal(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(node))
+}
+
+// Unmarshal decodes a node encoded by Marshal.
+func Unmarshal(data []byte) (Node, error) {
+	d := &decoder{}
+	node := d.node(json.RawMessage(data), "$")
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return node, nil
+}
+
+// UnmarshalProgram decodes a program encoded by Marshal.
+func UnmarshalProgram(data []byte) (*Program, error) {
+	node, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	program, ok := node.(*Program)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected a Program, got %T", node)
+	}
+
+	return program, nil
+}
+
+func (p *Program) MarshalJSON() ([]byte, error) {
+	return Marshal(p)
+}
+
+func (p *Program) UnmarshalJSON(data []byte) error {
+	program, err := UnmarshalProgram(data)
+	if err != nil {
+		return err
+	}
+
+	*p = *program
+	return nil
+}
+
+// Encoding
+
+// object is a JSON object that keeps its members in order, so "kind" always
+// comes first.
+type object []member
+
+type member struct {
+	key   string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(m.key)
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type jsonToken struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Line    int             `json:"line"`
+	Column  int             `json:"column"`
+}
+
+func encodeToken(t token.Token) jsonToken {
+	return jsonToken{
+		Type:    t.Type,
+		Literal: t.Literal,
+		Line:    t.Pos.Line,
+		Column:  t.Pos.Column,
+	}
+}
+
+func encodeNode(node Node) any {
+	if isNil(node) {
+		return nil
+	}
+
+	o := object{{"kind", kindOf(node)}}
+	add := func(key string, value any) { o = append(o, member{key, value}) }
+
+	switch node := node.(type) {
+
+	// Statements
+
+	case *Program:
+		add("statements", encodeStatements(node.Statements))
+
+	case *LetStatement:
+		add("token", encodeToken(node.Token))
+		add("name", encodeNode(node.Name))
+		add("value", encodeNode(node.Value))
+
+	case *ReturnStatement:
+		add("token", encodeToken(node.Token))
+		add("returnValue", encodeNode(node.ReturnValue))
+
+	case *ExpressionStatement:
+		add("token", encodeToken(node.Token))
+		add("expression", encodeNode(node.Expression))
+
+	case *BlockStatement:
+		add("token", encodeToken(node.Token))
+		add("statements", encodeStatements(node.Statements))
+		add("rbrace", encodeToken(node.Rbrace))
+
+	// Literals
+
+	case *Identifier:
+		add("token", encodeToken(node.Token))
+		add("value", node.Value)
+
+	case *IntegerLiteral:
+		add("token", encodeToken(node.Token))
+		if node.Big != nil {
+			add("value", json.Number(node.Big.String()))
+		} else {
+			add("value", node.Value)
+		}
+
+	case *StringLiteral:
+		add("token", encodeToken(node.Token))
+		add("value", node.Value)
+
+	case *Boolean:
+		add("token", encodeToken(node.Token))
+		add("value", node.Value)
+
+	case *NullLiteral:
+		add("token", encodeToken(node.Token))
+
+	case *ArrayLiteral:
+		add("token", encodeToken(node.Token))
+		add("elements", encodeExpressions(node.Elements))
+
+	case *HashLiteral:
+		add("token", encodeToken(node.Token))
+
+		var pairs []any
+		if node.Pairs != nil {
+			pairs = make([]any, len(node.Pairs))
+		}
+		for i, pair := range node.Pairs {
+			pairs[i] = object{
+				{"key", encodeNode(pair.Key)},
+				{"value", encodeNode(pair.Value)},
+			}
+		}
+		add("pairs", pairs)
+
+	case *FunctionLiteral:
+		add("token", encodeToken(node.Token))
+		if node.Name != "" {
+			add("name", node.Name)
+		}
+		add("parameters", encodeIdentifiers(node.Parameters))
+		add("body", encodeNode(node.Body))
+
+	case *MacroLiteral:
+		add("token", encodeToken(node.Token))
+		add("parameters", encodeIdentifiers(node.Parameters))
+		add("body", encodeNode(node.Body))
+
+	// Expressions
+
+	case *IndexExpression:
+		add("token", encodeToken(node.Token))
+		add("left", encodeNode(node.Left))
+		add("index", encodeNode(node.Index))
+		add("optional", node.Optional)
+
+	case *MemberExpression:
+		add("token", encodeToken(node.Token))
+		add("object", encodeNode(node.Object))
+		add("property", encodeNode(node.Property))
+		add("optional", node.Optional)
+
+	case *CallExpression:
+		add("token", encodeToken(node.Token))
+		add("function", encodeNode(node.Function))
+		add("arguments", encodeExpressions(node.Arguments))
+
+	case *PrefixExpression:
+		add("token", encodeToken(node.Token))
+		add("operator", node.Operator)
+		add("right", encodeNode(node.Right))
+
+	case *InfixExpression:
+		add("token", encodeToken(node.Token))
+		add("left", encodeNode(node.Left))
+		add("operator", node.Operator)
+		add("right", encodeNode(node.Right))
+
+	case *IfExpression:
+		add("token", encodeToken(node.Token))
+		add("condition", encodeNode(node.Condition))
+		add("consequence", encodeNode(node.Consequence))
+		add("alternative", encodeNode(node.Alternative))
+
+	case *ThrowExpression:
+		add("token", encodeToken(node.Token))
+		add("value", encodeNode(node.Value))
+
+	case *TryExpression:
+		add("token", encodeToken(node.Token))
+		add("block", encodeNode(node.Block))
+		add("parameter", encodeNode(node.Parameter))
+		add("catch", encodeNode(node.Catch))
+		add("finally", encodeNode(node.Finally))
+
+	default:
+		panic(fmt.Sprintf("ast.Marshal: unexpected node type %T", node))
+	}
+
+	return o
+}
+
+// Nil slices are encoded as null and empty ones as [], so that decoding gives
+// back exactly the same tree.
+
+func encodeStatements(statements []Statement) any {
+	if statements == nil {
+		return nil
+	}
+
+	encoded := make([]any, len(statements))
+	for i, s := range statements {
+		encoded[i] = encodeNode(s)
+	}
+
+	return encoded
+}
+
+func encodeExpressions(expressions []Expression) any {
+	if expressions == nil {
+		return nil
+	}
+
+	encoded := make([]any, len(expressions))
+	for i, exp := range expressions {
+		encoded[i] = encodeNode(exp)
+	}
+
+	return encoded
+}
+
+func encodeIdentifiers(identifiers []*Identifier) any {
+	if identifiers == nil {
+		return nil
+	}
+
+	encoded := make([]any, len(identifiers))
+	for i, ident := range identifiers {
+		encoded[i] = encodeNode(ident)
+	}
+
+	return encoded
+}
+
+func kindOf(node Node) string {
+	return fmt.Sprintf("%T", node)[len("*ast."):]
+}
+
+// isNil reports whether node is nil or a typed nil pointer.
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// Decoding
+
+// decoder keeps the first error, so the decoding functions can simply return
+// zero values once something went wrong.
+type decoder struct {
+	err error
+}
+
+func (d *decoder) fail(path, format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("ast: %s: %s", path, fmt.Sprintf(format, a...))
+	}
+}
+
+// unmarshal decodes data into v. Missing members leave v untouched.
+func (d *decoder) unmarshal(data json.RawMessage, v any, path string) {
+	if d.err != nil || len(data) == 0 {
+		return
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		d.fail(path, "%s", err)
+	}
+}
+
+// integer decodes the value of lit, which is a BigInt when it doesn't fit in
+// an int64.
+func (d *decoder) integer(data json.RawMessage, lit *IntegerLiteral, path string) {
+	if d.err != nil || len(data) == 0 {
+		return
+	}
+
+	err := json.Unmarshal(data, &lit.Value)
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		if value, ok := new(big.Int).SetString(string(data), 10); ok {
+			lit.Big = value
+			return
+		}
+	}
+
+	if err != nil {
+		d.fail(path, "%s", err)
+	}
+}
+
+func (d *decoder) node(data json.RawMessage, path string) Node {
+	if d.err != nil || len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	d.unmarshal(data, &fields, path)
+
+	var kind string
+	d.unmarshal(fields["kind"], &kind, path+".kind")
+	if d.err != nil {
+		return nil
+	}
+
+	field := func(name string) (json.RawMessage, string) {
+		return fields[name], path + "." + name
+	}
+	tok := d.token(field("token"))
+
+	switch kind {
+
+	// Statements
+
+	case "Program":
+		return &Program{Statements: d.statements(field("statements"))}
+
+	case "LetStatement":
+		return &LetStatement{
+			Token: tok,
+			Name:  d.identifier(field("name")),
+			Value: d.expression(field("value")),
+		}
+
+	case "ReturnStatement":
+		return &ReturnStatement{
+			Token:       tok,
+			ReturnValue: d.expression(field("returnValue")),
+		}
+
+	case "ExpressionStatement":
+		return &ExpressionStatement{
+			Token:      tok,
+			Expression: d.expression(field("expression")),
+		}
+
+	case "BlockStatement":
+		return &BlockStatement{
+			Token:      tok,
+			Statements: d.statements(field("statements")),
+			Rbrace:     d.token(field("rbrace")),
+		}
+
+	// Literals
+
+	case "Identifier":
+		ident := &Identifier{Token: tok}
+		d.unmarshal(fields["value"], &ident.Value, path+".value")
+		return ident
+
+	case "IntegerLiteral":
+		lit := &IntegerLiteral{Token: tok}
+		d.integer(fields["value"], lit, path+".value")
+		return lit
+
+	case "StringLiteral":
+		lit := &StringLiteral{Token: tok}
+		d.unmarshal(fields["value"], &lit.Value, path+".value")
+		return lit
+
+	case "Boolean":
+		lit := &Boolean{Token: tok}
+		d.unmarshal(fields["value"], &lit.Value, path+".value")
+		return lit
+
+	case "NullLiteral":
+		return &NullLiteral{Token: tok}
+
+	case "ArrayLiteral":
+		return &ArrayLiteral{
+			Token:    tok,
+			Elements: d.expressions(field("elements")),
+		}
+
+	case "HashLiteral":
+		return &HashLiteral{Token: tok, Pairs: d.pairs(field("pairs"))}
+
+	case "FunctionLiteral":
+		lit := &FunctionLiteral{
+			Token:      tok,
+			Parameters: d.identifiers(field("parameters")),
+			Body:       d.block(field("body")),
+		}
+		d.unmarshal(fields["name"], &lit.Name, path+".name")
+		return lit
+
+	case "MacroLiteral":
+		return &MacroLiteral{
+			Token:      tok,
+			Parameters: d.identifiers(field("parameters")),
+			Body:       d.block(field("body")),
+		}
+
+	// Expressions
+
+	case "IndexExpression":
+		exp := &IndexExpression{
+			Token: tok,
+			Left:  d.expression(field("left")),
+			Index: d.expression(field("index")),
+		}
+		d.unmarshal(fields["optional"], &exp.Optional, path+".optional")
+		return exp
+
+	case "MemberExpression":
+		exp := &MemberExpression{
+			Token:    tok,
+			Object:   d.expression(field("object")),
+			Property: d.identifier(field("property")),
+		}
+		d.unmarshal(fields["optional"], &exp.Optional, path+".optional")
+		return exp
+
+	case "CallExpression":
+		return &CallExpression{
+			Token:     tok,
+			Function:  d.expression(field("function")),
+			Arguments: d.expressions(field("arguments")),
+		}
+
+	case "PrefixExpression":
+		exp := &PrefixExpression{Token: tok, Right: d.expression(field("right"))}
+		d.unmarshal(fields["operator"], &exp.Operator, path+".operator")
+		return exp
+
+	case "InfixExpression":
+		exp := &InfixExpression{
+			Token: tok,
+			Left:  d.expression(field("left")),
+			Right: d.expression(field("right")),
+		}
+		d.unmarshal(fields["operator"], &exp.Operator, path+".operator")
+		return exp
+
+	case "IfExpression":
+		return &IfExpression{
+			Token:       tok,
+			Condition:   d.expression(field("condition")),
+			Consequence: d.block(field("consequence")),
+			Alternative: d.block(field("alternative")),
+		}
+
+	case "ThrowExpression":
+		return &ThrowExpression{Token: tok, Value: d.expression(field("value"))}
+
+	case "TryExpression":
+		return &TryExpression{
+			Token:     tok,
+			Block:     d.block(field("block")),
+			Parameter: d.identifier(field("parameter")),
+			Catch:     d.block(field("catch")),
+			Finally:   d.block(field("finally")),
+		}
+
+	default:
+		d.fail(path, "unknown node kind %q", kind)
+		return nil
+	}
+}
+
+func (d *decoder) token(data json.RawMessage, path string) token.Token {
+	if len(data) == 0 {
+		return token.Token{}
+	}
+
+	var t jsonToken
+	d.unmarshal(data, &t, path)
+
+	return token.Token{
+		Type:    t.Type,
+		Literal: t.Literal,
+		Pos:     token.Position{Line: t.Line, Column: t.Column},
+	}
+}
+
+func (d *decoder) expression(data json.RawMessage, path string) Expression {
+	node := d.node(data, path)
+	if node == nil {
+		return nil
+	}
+
+	exp, ok := node.(Expression)
+	if !ok {
+		d.fail(path, "expected an expression, got %s", kindOf(node))
+	}
+
+	return exp
+}
+
+func (d *decoder) statement(data json.RawMessage, path string) Statement {
+	node := d.node(data, path)
+	if node == nil {
+		return nil
+	}
+
+	s, ok := node.(Statement)
+	if !ok {
+		d.fail(path, "expected a statement, got %s", kindOf(node))
+	}
+
+	return s
+}
+
+func (d *decoder) identifier(data json.RawMessage, path string) *Identifier {
+	node := d.node(data, path)
+	if node == nil {
+		return nil
+	}
+
+	ident, ok := node.(*Identifier)
+	if !ok {
+		d.fail(path, "expected an Identifier, got %s", kindOf(node))
+	}
+
+	return ident
+}
+
+func (d *decoder) block(data json.RawMessage, path string) *BlockStatement {
+	node := d.node(data, path)
+	if node == nil {
+		return nil
+	}
+
+	block, ok := node.(*BlockStatement)
+	if !ok {
+		d.fail(path, "expected a BlockStatement, got %s", kindOf(node))
+	}
+
+	return block
+}
+
+// list decodes a JSON array, calling item for every element. It returns
+// false for null, which stands for a nil slice.
+func (d *decoder) list(
+	data json.RawMessage,
+	path string,
+	item func(data json.RawMessage, path string),
+) bool {
+	if len(data) == 0 || string(data) == "null" {
+		return false
+	}
+
+	var elements []json.RawMessage
+	d.unmarshal(data, &elements, path)
+
+	for i, el := range elements {
+		item(el, fmt.Sprintf("%s[%d]", path, i))
+	}
+
+	return true
+}
+
+func (d *decoder) statements(data json.RawMessage, path string) []Statement {
+	statements := []Statement{}
+	if !d.list(data, path, func(data json.RawMessage, path string) {
+		statements = append(statements, d.statement(data, path))
+	}) {
+		return nil
+	}
+
+	return statements
+}
+
+func (d *decoder) expressions(data json.RawMessage, path string) []Expression {
+	expressions := []Expression{}
+	if !d.list(data, path, func(data json.RawMessage, path string) {
+		expressions = append(expressions, d.expression(data, path))
+	}) {
+		return nil
+	}
+
+	return expressions
+}
+
+func (d *decoder) identifiers(data json.RawMessage, path string) []*Identifier {
+	identifiers := []*Identifier{}
+	if !d.list(data, path, func(data json.RawMessage, path string) {
+		identifiers = append(identifiers, d.identifier(data, path))
+	}) {
+		return nil
+	}
+
+	return identifiers
+}
+
+func (d *decoder) pairs(data json.RawMessage, path string) []HashPair {
+	pairs := []HashPair{}
+	if !d.list(data, path, func(data json.RawMessage, path string) {
+		var pair struct {
+			Key   json.RawMessage `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		d.unmarshal(data, &pair, path)
+
+		pairs = append(pairs, HashPair{
+			Key:   d.expression(pair.Key, path+".key"),
+			Value: d.expression(pair.Value, path+".value"),
+		})
+	}) {
+		return nil
+	}
+
+	return pairs
+}
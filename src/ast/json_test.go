@@ -0,0 +1,143 @@
+package ast
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+func TestMarshal(t *testing.T) {
+	program := &Program{Statements: []Statement{
+		&ExpressionStatement{
+			Token: token.Token{
+				Type:    token.IDENT,
+				Literal: "x",
+				Pos:     token.Position{Line: 1, Column: 1},
+			},
+			Expression: &Identifier{
+				Token: token.Token{
+					Type:    token.IDENT,
+					Literal: "x",
+					Pos:     token.Position{Line: 1, Column: 1},
+				},
+				Value: "x",
+			},
+		},
+	}}
+
+	expected := `{"kind":"Program","statements":[` +
+		`{"kind":"ExpressionStatement",` +
+		`"token":{"type":"IDENT","literal":"x","line":1,"column":1},` +
+		`"expression":{"kind":"Identifier",` +
+		`"token":{"type":"IDENT","literal":"x","line":1,"column":1},` +
+		`"value":"x"}}]}`
+
+	data, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+
+	if string(data) != expected {
+		t.Errorf("wrong JSON.\nwant=%s\ngot= %s", expected, data)
+	}
+
+	var decoded Program
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(&decoded, program) {
+		t.Errorf("decoded program differs.\nwant=%#v\ngot= %#v", program, &decoded)
+	}
+}
+
+func TestMarshalRoundTripsEveryNodeType(t *testing.T) {
+	for name, sample := range walkSamples() {
+		data, err := Marshal(sample)
+		if err != nil {
+			t.Errorf("%s: Marshal failed: %s", name, err)
+			continue
+		}
+
+		if !strings.HasPrefix(string(data), `{"kind":"`+name+`"`) {
+			t.Errorf("%s: wrong kind. got=%s", name, data)
+		}
+
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%s: Unmarshal failed: %s", name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(decoded, sample) {
+			t.Errorf("%s: round trip changed the node.\nwant=%#v\ngot= %#v",
+				name, sample, decoded)
+		}
+	}
+}
+
+func TestMarshalBigInteger(t *testing.T) {
+	value, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	lit := &IntegerLiteral{Big: value}
+
+	data, err := Marshal(lit)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+
+	if !strings.HasSuffix(string(data), `"value":-123456789012345678901234567890}`) {
+		t.Errorf("value is not a JSON number. got=%s", data)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, lit) {
+		t.Errorf("round trip changed the literal.\nwant=%#v\ngot= %#v", lit, decoded)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[]`, "ast: $: json: cannot unmarshal array"},
+		{`{"kind":"Nope"}`, `ast: $: unknown node kind "Nope"`},
+		{
+			`{"kind":"Program","statements":[{"kind":"Identifier"}]}`,
+			"ast: $.statements[0]: expected a statement, got Identifier",
+		},
+		{
+			`{"kind":"LetStatement","name":{"kind":"NullLiteral"}}`,
+			"ast: $.name: expected an Identifier, got NullLiteral",
+		},
+		{
+			`{"kind":"IntegerLiteral","value":"1"}`,
+			"ast: $.value: json: cannot unmarshal string",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal([]byte(tt.input))
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+
+	_, err := UnmarshalProgram([]byte(`{"kind":"NullLiteral"}`))
+	if err == nil || err.Error() != "ast: expected a Program, got *ast.NullLiteral" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
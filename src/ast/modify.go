@@ -0,0 +1,250 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify rebuilds the tree rooted at node bottom-up: every child is replaced
+// by the result of modifying it, and finally modifier is applied to the node
+// itself. Nodes are copied instead of mutated, and only when one of their
+// children changed, so the original tree is left intact and unchanged
+// subtrees are shared between both trees.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch node := node.(type) {
+
+	// Statements
+
+	case *Program:
+		if statements, ok := modifyStatements(node.Statements, modifier); ok {
+			copied := *node
+			copied.Statements = statements
+			return modifier(&copied)
+		}
+
+	case *LetStatement:
+		name, nameOk := modifyIdentifier(node.Name, modifier)
+		value, valueOk := modifyExpression(node.Value, modifier)
+		if nameOk || valueOk {
+			copied := *node
+			copied.Name, copied.Value = name, value
+			return modifier(&copied)
+		}
+
+	case *ReturnStatement:
+		if value, ok := modifyExpression(node.ReturnValue, modifier); ok {
+			copied := *node
+			copied.ReturnValue = value
+			return modifier(&copied)
+		}
+
+	case *ExpressionStatement:
+		if exp, ok := modifyExpression(node.Expression, modifier); ok {
+			copied := *node
+			copied.Expression = exp
+			return modifier(&copied)
+		}
+
+	case *BlockStatement:
+		if statements, ok := modifyStatements(node.Statements, modifier); ok {
+			copied := *node
+			copied.Statements = statements
+			return modifier(&copied)
+		}
+
+	// Literals
+
+	case *ArrayLiteral:
+		if elements, ok := modifyExpressions(node.Elements, modifier); ok {
+			copied := *node
+			copied.Elements = elements
+			return modifier(&copied)
+		}
+
+	case *HashLiteral:
+		pairs := make([]HashPair, len(node.Pairs))
+		changed := false
+		for i, pair := range node.Pairs {
+			key, keyOk := modifyExpression(pair.Key, modifier)
+			value, valueOk := modifyExpression(pair.Value, modifier)
+			pairs[i] = HashPair{Key: key, Value: value}
+			changed = changed || keyOk || valueOk
+		}
+		if changed {
+			copied := *node
+			copied.Pairs = pairs
+			return modifier(&copied)
+		}
+
+	case *FunctionLiteral:
+		params, paramsOk := modifyIdentifiers(node.Parameters, modifier)
+		body, bodyOk := modifyBlock(node.Body, modifier)
+		if paramsOk || bodyOk {
+			copied := *node
+			copied.Parameters, copied.Body = params, body
+			return modifier(&copied)
+		}
+
+	case *MacroLiteral:
+		params, paramsOk := modifyIdentifiers(node.Parameters, modifier)
+		body, bodyOk := modifyBlock(node.Body, modifier)
+		if paramsOk || bodyOk {
+			copied := *node
+			copied.Parameters, copied.Body = params, body
+			return modifier(&copied)
+		}
+
+	// Expressions
+
+	case *IndexExpression:
+		left, leftOk := modifyExpression(node.Left, modifier)
+		index, indexOk := modifyExpression(node.Index, modifier)
+		if leftOk || indexOk {
+			copied := *node
+			copied.Left, copied.Index = left, index
+			return modifier(&copied)
+		}
+
+	case *MemberExpression:
+		object, objectOk := modifyExpression(node.Object, modifier)
+		property, propertyOk := modifyIdentifier(node.Property, modifier)
+		if objectOk || propertyOk {
+			copied := *node
+			copied.Object, copied.Property = object, property
+			return modifier(&copied)
+		}
+
+	case *CallExpression:
+		function, functionOk := modifyExpression(node.Function, modifier)
+		args, argsOk := modifyExpressions(node.Arguments, modifier)
+		if functionOk || argsOk {
+			copied := *node
+			copied.Function, copied.Arguments = function, args
+			return modifier(&copied)
+		}
+
+	case *PrefixExpression:
+		if right, ok := modifyExpression(node.Right, modifier); ok {
+			copied := *node
+			copied.Right = right
+			return modifier(&copied)
+		}
+
+	case *InfixExpression:
+		left, leftOk := modifyExpression(node.Left, modifier)
+		right, rightOk := modifyExpression(node.Right, modifier)
+		if leftOk || rightOk {
+			copied := *node
+			copied.Left, copied.Right = left, right
+			return modifier(&copied)
+		}
+
+	case *IfExpression:
+		condition, conditionOk := modifyExpression(node.Condition, modifier)
+		consequence, consequenceOk := modifyBlock(node.Consequence, modifier)
+		alternative, alternativeOk := modifyBlock(node.Alternative, modifier)
+		if conditionOk || consequenceOk || alternativeOk {
+			copied := *node
+			copied.Condition = condition
+			copied.Consequence, copied.Alternative = consequence, alternative
+			return modifier(&copied)
+		}
+
+	case *ThrowExpression:
+		if value, ok := modifyExpression(node.Value, modifier); ok {
+			copied := *node
+			copied.Value = value
+			return modifier(&copied)
+		}
+
+	case *TryExpression:
+		block, blockOk := modifyBlock(node.Block, modifier)
+		param, paramOk := modifyIdentifier(node.Parameter, modifier)
+		catch, catchOk := modifyBlock(node.Catch, modifier)
+		finally, finallyOk := modifyBlock(node.Finally, modifier)
+		if blockOk || paramOk || catchOk || finallyOk {
+			copied := *node
+			copied.Block, copied.Parameter = block, param
+			copied.Catch, copied.Finally = catch, finally
+			return modifier(&copied)
+		}
+
+	}
+
+	return modifier(node)
+}
+
+// The helpers below modify one child or a list of children and report
+// whether anything changed. Absent children are never passed to the modifier.
+
+func modifyExpression(exp Expression, modifier ModifierFunc) (Expression, bool) {
+	if exp == nil {
+		return nil, false
+	}
+
+	modified, _ := Modify(exp, modifier).(Expression)
+	return modified, modified != exp
+}
+
+func modifyIdentifier(ident *Identifier, modifier ModifierFunc) (*Identifier, bool) {
+	if ident == nil {
+		return nil, false
+	}
+
+	modified, _ := Modify(ident, modifier).(*Identifier)
+	return modified, modified != ident
+}
+
+func modifyBlock(
+	block *BlockStatement,
+	modifier ModifierFunc,
+) (*BlockStatement, bool) {
+	if block == nil {
+		return nil, false
+	}
+
+	modified, _ := Modify(block, modifier).(*BlockStatement)
+	return modified, modified != block
+}
+
+func modifyStatements(
+	statements []Statement,
+	modifier ModifierFunc,
+) ([]Statement, bool) {
+	modified := make([]Statement, len(statements))
+	changed := false
+
+	for i, s := range statements {
+		modified[i], _ = Modify(s, modifier).(Statement)
+		changed = changed || modified[i] != s
+	}
+
+	return modified, changed
+}
+
+func modifyExpressions(
+	expressions []Expression,
+	modifier ModifierFunc,
+) ([]Expression, bool) {
+	modified := make([]Expression, len(expressions))
+	changed := false
+
+	for i, exp := range expressions {
+		modified[i], _ = modifyExpression(exp, modifier)
+		changed = changed || modified[i] != exp
+	}
+
+	return modified, changed
+}
+
+func modifyIdentifiers(
+	identifiers []*Identifier,
+	modifier ModifierFunc,
+) ([]*Identifier, bool) {
+	modified := make([]*Identifier, len(identifiers))
+	changed := false
+
+	for i, ident := range identifiers {
+		modified[i], _ = modifyIdentifier(ident, modifier)
+		changed = changed || modified[i] != ident
+	}
+
+	return modified, changed
+}
@@ -0,0 +1,209 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+
+		if integer.Value != 1 {
+			return node
+		}
+
+		return &IntegerLiteral{Token: integer.Token, Value: 2}
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{
+			one(),
+			two(),
+		},
+		{
+			&Program{
+				Statements: []Statement{
+					&ExpressionStatement{Expression: one()},
+				},
+			},
+			&Program{
+				Statements: []Statement{
+					&ExpressionStatement{Expression: two()},
+				},
+			},
+		},
+		{
+			&InfixExpression{Left: one(), Operator: "+", Right: two()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&InfixExpression{Left: two(), Operator: "+", Right: one()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&PrefixExpression{Operator: "-", Right: one()},
+			&PrefixExpression{Operator: "-", Right: two()},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&MemberExpression{Object: one(), Property: &Identifier{Value: "a"}},
+			&MemberExpression{Object: two(), Property: &Identifier{Value: "a"}},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two()}},
+		},
+		{
+			&IfExpression{
+				Condition: one(),
+				Consequence: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+				Alternative: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&IfExpression{
+				Condition: two(),
+				Consequence: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+				Alternative: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ReturnStatement{ReturnValue: one()},
+			&ReturnStatement{ReturnValue: two()},
+		},
+		{
+			&LetStatement{Value: one()},
+			&LetStatement{Value: two()},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ArrayLiteral{Elements: []Expression{one(), one()}},
+			&ArrayLiteral{Elements: []Expression{two(), two()}},
+		},
+		{
+			&HashLiteral{Pairs: []HashPair{
+				{Key: one(), Value: one()},
+				{Key: two(), Value: one()},
+			}},
+			&HashLiteral{Pairs: []HashPair{
+				{Key: two(), Value: two()},
+				{Key: two(), Value: two()},
+			}},
+		},
+		{
+			&ThrowExpression{Value: one()},
+			&ThrowExpression{Value: two()},
+		},
+		{
+			&TryExpression{
+				Block: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+				Parameter: &Identifier{Value: "e"},
+				Catch: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&TryExpression{
+				Block: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+				Parameter: &Identifier{Value: "e"},
+				Catch: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
+		}
+	}
+}
+
+func TestModifyLeavesOriginalIntact(t *testing.T) {
+	original := &InfixExpression{
+		Left:     &IntegerLiteral{Value: 1},
+		Operator: "+",
+		Right:    &Identifier{Value: "x"},
+	}
+	left, right := original.Left, original.Right
+
+	modified := Modify(original, func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok {
+			return &IntegerLiteral{Value: integer.Value + 1}
+		}
+		return node
+	})
+
+	if modified == Node(original) {
+		t.Fatalf("expected a rebuilt node, got the original")
+	}
+	if original.Left != left || original.Left.(*IntegerLiteral).Value != 1 {
+		t.Errorf("original left operand changed. got=%s", original.Left)
+	}
+	if modified.(*InfixExpression).Right != right {
+		t.Errorf("unchanged right operand was not shared")
+	}
+
+	unchanged := Modify(original, func(node Node) Node { return node })
+	if unchanged != Node(original) {
+		t.Errorf("identity modifier rebuilt the tree")
+	}
+}
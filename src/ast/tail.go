@@ -0,0 +1,44 @@
+package ast
+
+// TailCalls returns the calls in tail position of a function body: the calls
+// whose value the function returns as it is, so that the call can replace the
+// call of the function instead of nesting in it. Those are the calls that
+// are the value of a return statement or of the last statement of the body,
+// looking into the branches of ifs but not into try expressions, whose
+// handlers must stay active while the call runs.
+func TailCalls(body *BlockStatement) map[*CallExpression]bool {
+	calls := map[*CallExpression]bool{}
+	tailBlock(body, true, calls)
+
+	return calls
+}
+
+// tailBlock adds the tail calls of block to calls. value reports whether the
+// value of the block is returned by the function, and not only the values of
+// its return statements.
+func tailBlock(block *BlockStatement, value bool, calls map[*CallExpression]bool) {
+	if block == nil {
+		return
+	}
+
+	for i, s := range block.Statements {
+		switch s := s.(type) {
+		case *ReturnStatement:
+			tailExpression(s.ReturnValue, true, calls)
+		case *ExpressionStatement:
+			tailExpression(s.Expression, value && i == len(block.Statements)-1, calls)
+		}
+	}
+}
+
+func tailExpression(exp Expression, value bool, calls map[*CallExpression]bool) {
+	switch exp := exp.(type) {
+	case *CallExpression:
+		if value {
+			calls[exp] = true
+		}
+	case *IfExpression:
+		tailBlock(exp.Consequence, value, calls)
+		tailBlock(exp.Alternative, value, calls)
+	}
+}
@@ -0,0 +1,78 @@
+package ast
+
+import "testing"
+
+func TestTailCalls(t *testing.T) {
+	call := func(name string, args ...Expression) *CallExpression {
+		return &CallExpression{Function: &Identifier{Value: name}, Arguments: args}
+	}
+	block := func(statements ...Statement) *BlockStatement {
+		return &BlockStatement{Statements: statements}
+	}
+	expression := func(exp Expression) Statement {
+		return &ExpressionStatement{Expression: exp}
+	}
+
+	last := call("last")
+	returned := call("returned")
+	alternative := call("alternative")
+	early := call("early")
+
+	consequence := call("consequence")
+	notLast := call("notLast")
+	argument := call("argument")
+	operand := call("operand")
+	bound := call("bound")
+	inIfValue := call("inIfValue")
+	inTry := call("inTry")
+	inFunction := call("inFunction")
+
+	body := block(
+		expression(notLast),
+		&LetStatement{Name: &Identifier{Value: "a"}, Value: bound},
+		expression(&IfExpression{
+			Condition:   call("condition"),
+			Consequence: block(&ReturnStatement{ReturnValue: early}),
+			Alternative: block(expression(inIfValue)),
+		}),
+		expression(&TryExpression{
+			Block: block(&ReturnStatement{ReturnValue: inTry}),
+		}),
+		expression(&FunctionLiteral{
+			Body: block(expression(inFunction)),
+		}),
+		&ReturnStatement{ReturnValue: &InfixExpression{
+			Left:     operand,
+			Operator: "+",
+			Right:    call("right"),
+		}},
+		&ReturnStatement{ReturnValue: returned},
+		&ReturnStatement{},
+		expression(call("outer", argument)),
+		expression(&IfExpression{
+			Condition:   &Boolean{Value: true},
+			Consequence: block(expression(consequence), expression(last)),
+			Alternative: block(expression(alternative)),
+		}),
+	)
+
+	calls := TailCalls(body)
+
+	for _, tail := range []*CallExpression{last, returned, alternative, early} {
+		if !calls[tail] {
+			t.Errorf("%s not a tail call", tail)
+		}
+	}
+
+	for _, notTail := range []*CallExpression{
+		consequence, notLast, argument, operand, bound, inIfValue, inTry, inFunction,
+	} {
+		if calls[notTail] {
+			t.Errorf("%s is a tail call", notTail)
+		}
+	}
+
+	if len(calls) != 4 {
+		t.Errorf("wrong number of tail calls. want=4, got=%d", len(calls))
+	}
+}
@@ -0,0 +1,144 @@
+package ast
+
+import "fmt"
+
+// A Visitor is driven by Walk. Enter is called when a node is reached; when
+// it returns false the children of that node are skipped. Leave is called
+// once the node is done, so every Enter is paired with exactly one Leave.
+type Visitor interface {
+	Enter(node Node) bool
+	Leave(node Node)
+}
+
+// Walk traverses the tree rooted at node depth-first, in source order.
+func Walk(node Node, v Visitor) {
+	if v.Enter(node) {
+		for _, child := range Children(node) {
+			Walk(child, v)
+		}
+	}
+
+	v.Leave(node)
+}
+
+type inspector struct {
+	enter func(Node) bool
+	leave func(Node)
+}
+
+func (i inspector) Enter(node Node) bool { return i.enter(node) }
+func (i inspector) Leave(node Node) {
+	if i.leave != nil {
+		i.leave(node)
+	}
+}
+
+// Inspect is Walk with plain functions for callbacks. leave may be nil.
+func Inspect(node Node, enter func(Node) bool, leave func(Node)) {
+	Walk(node, inspector{enter: enter, leave: leave})
+}
+
+// Children returns the direct children of node in source order. Missing
+// optional children, such as an absent else block, are left out.
+func Children(node Node) []Node {
+	children := []Node{}
+	add := func(child Node, present bool) {
+		if present {
+			children = append(children, child)
+		}
+	}
+
+	switch node := node.(type) {
+
+	// Statements
+
+	case *Program:
+		for _, s := range node.Statements {
+			add(s, s != nil)
+		}
+
+	case *LetStatement:
+		add(node.Name, node.Name != nil)
+		add(node.Value, node.Value != nil)
+
+	case *ReturnStatement:
+		add(node.ReturnValue, node.ReturnValue != nil)
+
+	case *ExpressionStatement:
+		add(node.Expression, node.Expression != nil)
+
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			add(s, s != nil)
+		}
+
+	// Literals
+
+	case *Identifier, *IntegerLiteral, *StringLiteral, *Boolean, *NullLiteral:
+
+	case *ArrayLiteral:
+		for _, el := range node.Elements {
+			add(el, el != nil)
+		}
+
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			add(pair.Key, pair.Key != nil)
+			add(pair.Value, pair.Value != nil)
+		}
+
+	case *FunctionLiteral:
+		for _, p := range node.Parameters {
+			add(p, p != nil)
+		}
+		add(node.Body, node.Body != nil)
+
+	case *MacroLiteral:
+		for _, p := range node.Parameters {
+			add(p, p != nil)
+		}
+		add(node.Body, node.Body != nil)
+
+	// Expressions
+
+	case *IndexExpression:
+		add(node.Left, node.Left != nil)
+		add(node.Index, node.Index != nil)
+
+	case *MemberExpression:
+		add(node.Object, node.Object != nil)
+		add(node.Property, node.Property != nil)
+
+	case *CallExpression:
+		add(node.Function, node.Function != nil)
+		for _, arg := range node.Arguments {
+			add(arg, arg != nil)
+		}
+
+	case *PrefixExpression:
+		add(node.Right, node.Right != nil)
+
+	case *InfixExpression:
+		add(node.Left, node.Left != nil)
+		add(node.Right, node.Right != nil)
+
+	case *IfExpression:
+		add(node.Condition, node.Condition != nil)
+		add(node.Consequence, node.Consequence != nil)
+		add(node.Alternative, node.Alternative != nil)
+
+	case *ThrowExpression:
+		add(node.Value, node.Value != nil)
+
+	case *TryExpression:
+		add(node.Block, node.Block != nil)
+		add(node.Parameter, node.Parameter != nil)
+		add(node.Catch, node.Catch != nil)
+		add(node.Finally, node.Finally != nil)
+
+	default:
+		panic(fmt.Sprintf("ast.Children: unexpected node type %T", node))
+	}
+
+	return children
+}
@@ -0,0 +1,279 @@
+package ast
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// walkSamples holds one fully populated instance of every node type. Every
+// child slot is filled so the tests below can check that none is skipped.
+func walkSamples() map[string]Node {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	str := func(value string) *StringLiteral {
+		return &StringLiteral{Token: token.Token{Literal: value}, Value: value}
+	}
+	integer := func(v int64) *IntegerLiteral { return &IntegerLiteral{Value: v} }
+	block := func(exps ...Expression) *BlockStatement {
+		b := &BlockStatement{}
+		for _, exp := range exps {
+			b.Statements = append(b.Statements, &ExpressionStatement{Expression: exp})
+		}
+		return b
+	}
+
+	return map[string]Node{
+		"Program": &Program{Statements: []Statement{
+			&LetStatement{Name: ident("a"), Value: integer(1)},
+			&ExpressionStatement{Expression: ident("a")},
+		}},
+		"LetStatement":        &LetStatement{Name: ident("a"), Value: integer(1)},
+		"ReturnStatement":     &ReturnStatement{ReturnValue: integer(1)},
+		"ExpressionStatement": &ExpressionStatement{Expression: integer(1)},
+		"BlockStatement":      block(integer(1), integer(2)),
+		"Identifier":          ident("a"),
+		"IntegerLiteral":      integer(1),
+		"StringLiteral":       str("a"),
+		"Boolean":             &Boolean{Value: true},
+		"NullLiteral":         &NullLiteral{},
+		"ArrayLiteral":        &ArrayLiteral{Elements: []Expression{integer(1), ident("a")}},
+		"HashLiteral": &HashLiteral{Pairs: []HashPair{
+			{Key: str("a"), Value: integer(1)},
+			{Key: str("b"), Value: ident("b")},
+		}},
+		"FunctionLiteral": &FunctionLiteral{
+			Name:       "f",
+			Parameters: []*Identifier{ident("x"), ident("y")},
+			Body:       block(ident("x")),
+		},
+		"MacroLiteral": &MacroLiteral{
+			Parameters: []*Identifier{ident("x")},
+			Body:       block(ident("x")),
+		},
+		"IndexExpression":  &IndexExpression{Left: ident("a"), Index: integer(0)},
+		"MemberExpression": &MemberExpression{Object: ident("a"), Property: ident("b")},
+		"CallExpression": &CallExpression{
+			Function:  ident("f"),
+			Arguments: []Expression{integer(1), ident("a")},
+		},
+		"PrefixExpression": &PrefixExpression{Operator: "-", Right: integer(1)},
+		"InfixExpression": &InfixExpression{
+			Left: integer(1), Operator: "+", Right: integer(2),
+		},
+		"IfExpression": &IfExpression{
+			Condition:   ident("c"),
+			Consequence: block(integer(1)),
+			Alternative: block(integer(2)),
+		},
+		"ThrowExpression": &ThrowExpression{Value: ident("e")},
+		"TryExpression": &TryExpression{
+			Block:     block(ident("a")),
+			Parameter: ident("e"),
+			Catch:     block(ident("e")),
+			Finally:   block(integer(1)),
+		},
+	}
+}
+
+// nodeTypeNames parses the package sources and returns every type that
+// implements Statement or Expression, plus Program.
+func nodeTypeNames(t *testing.T) []string {
+	t.Helper()
+
+	fset := gotoken.NewFileSet()
+	pkgs, err := goparser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("could not parse package: %s", err)
+	}
+
+	names := map[string]bool{"Program": true}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*goast.FuncDecl)
+				if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+					continue
+				}
+				if fn.Name.Name != "statementNode" && fn.Name.Name != "expressionNode" {
+					continue
+				}
+
+				recv := fn.Recv.List[0].Type
+				if star, ok := recv.(*goast.StarExpr); ok {
+					recv = star.X
+				}
+				if ident, ok := recv.(*goast.Ident); ok {
+					names[ident.Name] = true
+				}
+			}
+		}
+	}
+
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// reachable collects every node below root by following all fields through
+// reflection, independent of Children and Modify.
+func reachable(root Node) map[Node]bool {
+	nodeType := reflect.TypeOf((*Node)(nil)).Elem()
+	found := map[Node]bool{}
+
+	var visit func(v reflect.Value)
+	visit = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Pointer:
+			if v.IsNil() {
+				return
+			}
+			if v.Type().Implements(nodeType) {
+				node := v.Interface().(Node)
+				if found[node] {
+					return
+				}
+				found[node] = true
+			}
+			visit(v.Elem())
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				visit(iter.Key())
+				visit(iter.Value())
+			}
+		}
+	}
+	visit(reflect.ValueOf(root))
+
+	return found
+}
+
+func TestWalkerCoversEveryNodeType(t *testing.T) {
+	samples := walkSamples()
+
+	for _, name := range nodeTypeNames(t) {
+		sample, ok := samples[name]
+		if !ok {
+			t.Errorf("no walker sample for node type %s. "+
+				"Add it to walkSamples, Children and Modify", name)
+			continue
+		}
+
+		want := reachable(sample)
+
+		walked := map[Node]bool{}
+		Inspect(sample, func(node Node) bool {
+			walked[node] = true
+			return true
+		}, nil)
+
+		modified := map[Node]bool{}
+		Modify(sample, func(node Node) Node {
+			modified[node] = true
+			return node
+		})
+
+		for node := range want {
+			if !walked[node] {
+				t.Errorf("%s: Walk never visits %T %q", name, node, node.String())
+			}
+			if !modified[node] {
+				t.Errorf("%s: Modify never visits %T %q", name, node, node.String())
+			}
+		}
+	}
+}
+
+func TestModifyReplacesEveryChild(t *testing.T) {
+	for name, sample := range walkSamples() {
+		original := reachable(sample)
+
+		// Replacing every node with a copy must leave no original node behind.
+		result := Modify(sample, func(node Node) Node {
+			copied := reflect.New(reflect.TypeOf(node).Elem())
+			copied.Elem().Set(reflect.ValueOf(node).Elem())
+			return copied.Interface().(Node)
+		})
+
+		replaced := reachable(result)
+		for node := range replaced {
+			if original[node] {
+				t.Errorf("%s: %T %q was not replaced", name, node, node.String())
+			}
+		}
+		if len(replaced) != len(original) {
+			t.Errorf("%s: wrong number of nodes. got=%d, want=%d",
+				name, len(replaced), len(original))
+		}
+	}
+}
+
+func TestInspectOrder(t *testing.T) {
+	integer := func(literal string, v int64) *IntegerLiteral {
+		return &IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: literal},
+			Value: v,
+		}
+	}
+
+	program := &Program{Statements: []Statement{
+		&LetStatement{
+			Name: &Identifier{Value: "a"},
+			Value: &InfixExpression{
+				Left:     integer("1", 1),
+				Operator: "+",
+				Right:    integer("2", 2),
+			},
+		},
+	}}
+
+	events := []string{}
+	Inspect(program, func(node Node) bool {
+		events = append(events, "enter "+reflect.TypeOf(node).Elem().Name())
+		_, isInfix := node.(*InfixExpression)
+		return !isInfix
+	}, func(node Node) {
+		events = append(events, "leave "+reflect.TypeOf(node).Elem().Name())
+	})
+
+	expected := []string{
+		"enter Program",
+		"enter LetStatement",
+		"enter Identifier",
+		"leave Identifier",
+		"enter InfixExpression",
+		"leave InfixExpression",
+		"leave LetStatement",
+		"leave Program",
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("wrong events.\ngot=%v\nwant=%v", events, expected)
+	}
+
+	children := Children(program.Statements[0].(*LetStatement).Value)
+	if len(children) != 2 || children[0].String() != "1" || children[1].String() != "2" {
+		t.Errorf("children not in source order. got=%v", children)
+	}
+}
@@ -13,9 +13,18 @@ import (
 	"github.com/ZeroBl21/go-monkey/src/vm"
 )
 
-var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
+var (
+	engine       = flag.String("engine", "vm", "use 'vm', 'register' or 'eval'")
+	optimization = flag.Int("O", int(compiler.OptimizeSpecialize), "optimization level of the vm, as in the CLI")
+	name         = flag.String(
+		"program",
+		"fibonacci",
+		"use 'fibonacci', 'helpers', 'mapreduce' or 'strings'",
+	)
+)
 
-const input = `
+var programs = map[string]string{
+	"fibonacci": `
 let fibonacci = fn(x) {
 	if (x == 0) {
 		0
@@ -28,7 +37,78 @@ let fibonacci = fn(x) {
 	}
 };
 
-fibonacci(35);`
+fibonacci(35);`,
+
+	// The same computation, with the arithmetic done by small helper
+	// functions, whose calls can be inlined.
+	"helpers": `
+let add = fn(a, b) { a + b };
+let dec = fn(x, n) { x - n };
+let below = fn(x, n) { x < n };
+
+let fibonacci = fn(x) {
+	if (below(x, 2)) {
+		x
+	} else {
+		add(fibonacci(dec(x, 1)), fibonacci(dec(x, 2)));
+	}
+};
+
+fibonacci(35);`,
+
+	// Maps and reduces an array over and over, with closures passed to
+	// recursive functions and builtin calls.
+	"mapreduce": `
+let map = fn(arr, f, accumulated) {
+	if (len(arr) == 0) {
+		accumulated
+	} else {
+		map(rest(arr), f, push(accumulated, f(first(arr))));
+	}
+};
+
+let reduce = fn(arr, f, result) {
+	if (len(arr) == 0) {
+		result
+	} else {
+		reduce(rest(arr), f, f(result, first(arr)));
+	}
+};
+
+let range = fn(n, numbers) {
+	if (n == 0) { numbers } else { range(n - 1, push(numbers, n)) }
+};
+let numbers = range(100, []);
+
+let run = fn(times, total) {
+	if (times == 0) {
+		total
+	} else {
+		let squares = map(numbers, fn(x) { x * x }, []);
+		run(times - 1, total + reduce(squares, fn(sum, x) { sum + x }, 0));
+	}
+};
+
+run(10000, 0);`,
+
+	// Builds strings by concatenation and calls methods on them.
+	"strings": `
+let repeat = fn(s, n, result) {
+	if (n == 0) { result } else { repeat(s, n - 1, result + s) }
+};
+
+let run = fn(times, total) {
+	if (times == 0) {
+		total
+	} else {
+		let word = repeat("monkey", 50, "");
+		let shout = word.upper() + "!";
+		run(times - 1, total + shout.len() - word.lower().len());
+	}
+};
+
+run(50000, 0);`,
+}
 
 func main() {
 	flag.Parse()
@@ -36,18 +116,28 @@ func main() {
 	var duration time.Duration
 	var result object.Object
 
+	input, ok := programs[*name]
+	if !ok {
+		fmt.Printf("unknown program: %s\n", *name)
+		return
+	}
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
-	if *engine == "vm" {
-		comp := compiler.New()
+	if *engine == "vm" || *engine == "register" {
+		level := compiler.OptimizationLevel(*optimization)
+		comp := compiler.New(compiler.WithOptimization(level))
 		if err := comp.Compile(program); err != nil {
 			fmt.Printf("compiler error: %s", err)
 			return
 		}
 
-		machine := vm.New(comp.Bytecode())
+		var machine vm.Machine = vm.New(comp.Bytecode())
+		if *engine == "register" {
+			machine = vm.NewRegister(comp.Bytecode())
+		}
 
 		start := time.Now()
 
@@ -70,8 +160,9 @@ func main() {
 	}
 
 	fmt.Printf(
-		"engine=%s, result=%s, duration=%s\n",
+		"engine=%s, program=%s, result=%s, duration=%s\n",
 		*engine,
+		*name,
 		result.Inspect(),
 		duration,
 	)
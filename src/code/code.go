@@ -29,6 +29,8 @@ const (
 
 	OpJumpNotTruthy
 	OpJump
+	OpJumpNull
+	OpJumpNotNull
 
 	OpGetGlobal
 	OpSetGlobal
@@ -49,8 +51,54 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpGetField
+	OpGetOptionalField
 
 	OpNull
+
+	OpMod
+
+	OpThrow
+
+	OpDup
+
+	OpTailCall
+
+	// OpWide doubles the widths of the operands of the instruction after it,
+	// for operands that don't fit in the widths of the definition.
+	OpWide
+
+	OpLessThan
+
+	// OpCurrentClosure pushes the closure being executed, for a function to
+	// refer to itself by the name it is bound to.
+	OpCurrentClosure
+
+	// Specialized instructions, which the compiler chooses in place of the
+	// sequences of generic instructions they do the work of.
+
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+
+	// OpAddConstant and OpSubConstant add and subtract the constant of their
+	// operand, as OpConstant followed by OpAdd or OpSub.
+	OpAddConstant
+	OpSubConstant
+
+	// The compare-and-jump instructions compare the two values on top of the
+	// stack and jump when the comparison is false, as OpLessThan,
+	// OpGreaterThan, OpEqual or OpNotEqual followed by OpJumpNotTruthy.
+	OpJumpNotLess
+	OpJumpNotGreater
+	OpJumpNotEqual
+	OpJumpEqual
+
+	OpCall0
+	OpCall1
+	OpCall2
+	OpCall3
 )
 
 type Definition struct {
@@ -79,6 +127,8 @@ var definitions = map[Opcode]*Definition{
 
 	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
 	OpJump:          {"OpJump", []int{2}},
+	OpJumpNull:      {"OpJumpNull", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
 
 	OpGetGlobal: {"OpGetGlobal", []int{2}},
 	OpSetGlobal: {"OpSetGlobal", []int{2}},
@@ -100,7 +150,42 @@ var definitions = map[Opcode]*Definition{
 	OpHash:  {"OpHash", []int{2}},
 	OpIndex: {"OpIndex", []int{}},
 
+	OpGetField:         {"OpGetField", []int{2}},
+	OpGetOptionalField: {"OpGetOptionalField", []int{2}},
+
 	OpNull: {"OpNull", []int{}},
+
+	OpMod: {"OpMod", []int{}},
+
+	OpThrow: {"OpThrow", []int{}},
+
+	OpDup: {"OpDup", []int{}},
+
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	OpWide: {"OpWide", []int{}},
+
+	OpLessThan: {"OpLessThan", []int{}},
+
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpGetLocal0: {"OpGetLocal0", []int{}},
+	OpGetLocal1: {"OpGetLocal1", []int{}},
+	OpGetLocal2: {"OpGetLocal2", []int{}},
+	OpGetLocal3: {"OpGetLocal3", []int{}},
+
+	OpAddConstant: {"OpAddConstant", []int{2}},
+	OpSubConstant: {"OpSubConstant", []int{2}},
+
+	OpJumpNotLess:    {"OpJumpNotLess", []int{2}},
+	OpJumpNotGreater: {"OpJumpNotGreater", []int{2}},
+	OpJumpNotEqual:   {"OpJumpNotEqual", []int{2}},
+	OpJumpEqual:      {"OpJumpEqual", []int{2}},
+
+	OpCall0: {"OpCall0", []int{}},
+	OpCall1: {"OpCall1", []int{}},
+	OpCall2: {"OpCall2", []int{}},
+	OpCall3: {"OpCall3", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -112,10 +197,52 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
-func Make(op Opcode, operants ...int) []byte {
+// Make returns the instruction op with operands, or an error when an
+// operand doesn't fit in its width. MakeWide makes the instructions whose
+// operands don't fit.
+func Make(op Opcode, operands ...int) ([]byte, error) {
 	def, ok := definitions[op]
 	if !ok {
-		return []byte{}
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return makeInstruction(op, def, operands)
+}
+
+// MakeWide returns the instruction op with operands prefixed by OpWide, which
+// makes room for operands of twice the widths of the definition of op.
+func MakeWide(op Opcode, operands ...int) ([]byte, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	if len(def.OperandWidths) == 0 {
+		return nil, fmt.Errorf("%s has no operands to widen", def.Name)
+	}
+
+	instruction, err := makeInstruction(op, def.Wide(), operands)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{byte(OpWide)}, instruction...), nil
+}
+
+// MustMake is like Make but panics when an operand doesn't fit, for
+// instructions known to be valid.
+func MustMake(op Opcode, operands ...int) []byte {
+	instruction, err := Make(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+
+	return instruction
+}
+
+func makeInstruction(op Opcode, def *Definition, operands []int) ([]byte, error) {
+	if len(operands) != len(def.OperandWidths) {
+		return nil, fmt.Errorf("%s takes %d operands, got %d",
+			def.Name, len(def.OperandWidths), len(operands))
 	}
 
 	instructionLen := 1
@@ -127,9 +254,16 @@ func Make(op Opcode, operants ...int) []byte {
 	instruction[0] = byte(op)
 
 	offset := 1
-	for i, o := range operants {
+	for i, o := range operands {
 		width := def.OperandWidths[i]
+		if o < 0 || o >= 1<<(8*width) {
+			return nil, fmt.Errorf("operand %d of %s doesn't fit in %d bytes",
+				o, def.Name, width)
+		}
+
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -138,7 +272,18 @@ func Make(op Opcode, operants ...int) []byte {
 		offset += width
 	}
 
-	return instruction
+	return instruction, nil
+}
+
+// Wide returns the definition of the instruction after OpWide, with the
+// operand widths doubled.
+func (def *Definition) Wide() *Definition {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = 2 * w
+	}
+
+	return &Definition{Name: def.Name, OperandWidths: widths}
 }
 
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
@@ -146,19 +291,29 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	offset := 0
 
 	for i, width := range def.OperandWidths {
-		switch width {
-		case 2:
-			operants[i] = int(ReadUint16(ins[offset:]))
-		case 1:
-			operants[i] = int(ReadUint8(ins[offset:]))
-		}
-
+		operants[i] = ReadOperand(ins[offset:], width)
 		offset += width
 	}
 
 	return operants, offset
 }
 
+// ReadOperand reads an operand width bytes wide.
+func ReadOperand(ins Instructions, width int) int {
+	switch width {
+	case 4:
+		return int(ReadUint32(ins))
+	case 2:
+		return int(ReadUint16(ins))
+	default:
+		return int(ReadUint8(ins))
+	}
+}
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
@@ -180,9 +335,20 @@ func (ins Instructions) String() string {
 			continue
 		}
 
+		offset, prefix := i, ""
+		if Opcode(ins[i]) == OpWide && i+1 < len(ins) {
+			if def, err = Lookup(ins[i+1]); err != nil {
+				fmt.Fprintf(&out, "ERROR: %s\n", err)
+				break
+			}
+			def = def.Wide()
+			prefix = "OpWide "
+			i++
+		}
+
 		operants, read := ReadOperands(def, ins[i+1:])
 
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operants))
+		fmt.Fprintf(&out, "%04d %s%s\n", offset, prefix, ins.fmtInstruction(def, operants))
 
 		i += 1 + read
 	}
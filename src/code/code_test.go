@@ -1,6 +1,10 @@
 package code
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -15,29 +19,95 @@ func TestMake(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		instruction := Make(tt.op, tt.operants...)
+		instruction, err := Make(tt.op, tt.operants...)
+		if err != nil {
+			t.Fatalf("Make(%d, %v): %s", tt.op, tt.operants, err)
+		}
+
+		testBytes(t, instruction, tt.expected)
+	}
+}
+
+func TestMakeWide(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operants []int
+		expected []byte
+	}{
+		{OpConstant, []int{65_536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 300}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 44}},
+	}
 
-		if len(instruction) != len(tt.expected) {
-			t.Errorf("instruction has wrong length. want=%d, got=%d",
-				len(tt.expected), len(instruction))
+	for _, tt := range tests {
+		if _, err := Make(tt.op, tt.operants...); err == nil {
+			t.Errorf("Make(%d, %v) didn't fail", tt.op, tt.operants)
 		}
 
-		for i, b := range tt.expected {
-			if instruction[i] != tt.expected[i] {
-				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
-					i, b, instruction[i])
-			}
+		instruction, err := MakeWide(tt.op, tt.operants...)
+		if err != nil {
+			t.Fatalf("MakeWide(%d, %v): %s", tt.op, tt.operants, err)
+		}
+
+		testBytes(t, instruction, tt.expected)
+	}
+}
+
+func TestMakeErrors(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operants []int
+		wide     bool
+		expected string
+	}{
+		{OpGetLocal, []int{256}, false, "operand 256 of OpGetLocal doesn't fit in 1 bytes"},
+		{OpConstant, []int{-1}, false, "operand -1 of OpConstant doesn't fit in 2 bytes"},
+		{OpConstant, []int{1 << 32}, true, "operand 4294967296 of OpConstant doesn't fit in 4 bytes"},
+		{OpConstant, []int{}, false, "OpConstant takes 1 operands, got 0"},
+		{OpAdd, []int{}, true, "OpAdd has no operands to widen"},
+		{Opcode(255), []int{}, false, "opcode 255 undefined"},
+	}
+
+	for _, tt := range tests {
+		build := Make
+		if tt.wide {
+			build = MakeWide
+		}
+
+		_, err := build(tt.op, tt.operants...)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func testBytes(t *testing.T, instruction, expected []byte) {
+	t.Helper()
+
+	if len(instruction) != len(expected) {
+		t.Errorf("instruction has wrong length. want=%d, got=%d",
+			len(expected), len(instruction))
+		return
+	}
+
+	for i, b := range expected {
+		if instruction[i] != b {
+			t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+				i, b, instruction[i])
 		}
 	}
 }
 
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
-		Make(OpAdd),
-		Make(OpGetLocal, 1),
-		Make(OpConstant, 2),
-		Make(OpConstant, 65_535),
-		Make(OpClosure, 65_535, 255),
+		MustMake(OpAdd),
+		MustMake(OpGetLocal, 1),
+		MustMake(OpConstant, 2),
+		MustMake(OpConstant, 65_535),
+		MustMake(OpClosure, 65_535, 255),
+		mustMakeWide(OpConstant, 65_536),
+		mustMakeWide(OpClosure, 2, 256),
+		MustMake(OpPop),
 	}
 
 	expected := `0000 OpAdd
@@ -45,6 +115,9 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpWide OpConstant 65536
+0019 OpWide OpClosure 2 256
+0027 OpPop
 `
 
 	concatted := Instructions{}
@@ -58,6 +131,37 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestRegisterInstructionsString(t *testing.T) {
+	instructions := RegisterInstructions{
+		{Op: RAdd, A: 2, B: 0, C: -2},
+		{Op: RJumpNotLess, A: 5, B: 1, C: -1},
+		{Op: RCall, A: 3, B: 2},
+		{Op: RReturn},
+		{Op: 255},
+	}
+
+	expected := `0000 RAdd r2 r0 k1
+0001 RJumpNotLess 5 r1 k0
+0002 RCall r3 2
+0003 RReturn
+0004 ERROR: register opcode 255 undefined
+`
+
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot =%q",
+			expected, instructions.String())
+	}
+}
+
+func mustMakeWide(op Opcode, operands ...int) []byte {
+	instruction, err := MakeWide(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+
+	return instruction
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -70,7 +174,7 @@ func TestReadOperands(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		instruction := Make(tt.op, tt.operants...)
+		instruction := MustMake(tt.op, tt.operants...)
 
 		def, err := Lookup(byte(tt.op))
 		if err != nil {
@@ -93,3 +197,72 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceMap(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+
+	var m SourceMap
+	m = m.Add(2, pos(1, 3))
+	m = m.Add(5, pos(1, 3))
+	m = m.Add(6, pos(2, 1))
+	m = m.Add(9, pos(3, 7))
+
+	if len(m) != 3 {
+		t.Fatalf("repeated positions weren't merged. got=%v", m)
+	}
+
+	m = m.Truncate(9)
+	m = m.Add(8, pos(4, 1))
+
+	tests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{}},
+		{2, pos(1, 3)},
+		{5, pos(1, 3)},
+		{6, pos(2, 1)},
+		{7, pos(2, 1)},
+		{8, pos(4, 1)},
+		{100, pos(4, 1)},
+	}
+
+	for _, tt := range tests {
+		if got := m.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("wrong position at %d. want=%s, got=%s",
+				tt.offset, tt.expected, got)
+		}
+	}
+}
+
+func TestSourceMapInlinedCalls(t *testing.T) {
+	pos := token.Position{Line: 1, Column: 3}
+	call := &InlinedCall{Function: "f", NumArgs: 1, Pos: token.Position{Line: 2, Column: 1}}
+
+	var m SourceMap
+	m = m.Add(0, pos)
+	m = m.AddEntry(SourceMapEntry{Offset: 2, Pos: pos, Inlined: call})
+	m = m.AddEntry(SourceMapEntry{Offset: 4, Pos: pos, Inlined: call, TailCall: true})
+	m = m.AddEntry(SourceMapEntry{Offset: 6, Pos: pos, Inlined: call, TailCall: true})
+
+	if len(m) != 3 {
+		t.Fatalf("entries differing in inlined calls were merged. got=%v", m)
+	}
+
+	tests := []struct {
+		offset   int
+		expected SourceMapEntry
+	}{
+		{1, SourceMapEntry{Offset: 0, Pos: pos}},
+		{3, SourceMapEntry{Offset: 2, Pos: pos, Inlined: call}},
+		{7, SourceMapEntry{Offset: 4, Pos: pos, Inlined: call, TailCall: true}},
+	}
+
+	for _, tt := range tests {
+		if got := m.Entry(tt.offset); got != tt.expected {
+			t.Errorf("wrong entry at %d. want=%+v, got=%+v", tt.offset, tt.expected, got)
+		}
+	}
+}
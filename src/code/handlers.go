@@ -0,0 +1,29 @@
+package code
+
+// Handler catches the errors raised by the instructions in [Start, End). The
+// VM unwinds the stack to Depth values above the locals of the function,
+// pushes the error and jumps to Target. A catch handler pushes the value bound
+// by the catch clause, a finally handler the error itself, to be rethrown by
+// the OpThrow that ends the finally block.
+type Handler struct {
+	Start   int
+	End     int
+	Target  int
+	Depth   int
+	Finally bool
+}
+
+// HandlerTable lists the handlers of a function. Nested handlers come before
+// the handlers that enclose them.
+type HandlerTable []Handler
+
+// Lookup returns the innermost handler covering offset.
+func (t HandlerTable) Lookup(offset int) (Handler, bool) {
+	for _, h := range t {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+
+	return Handler{}, false
+}
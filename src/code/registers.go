@@ -0,0 +1,181 @@
+package code
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RegisterOp is an opcode of the register VM. Its instructions name the
+// registers of the frame they read and write, instead of passing values on a
+// stack. The first registers of a frame hold the locals of the function, the
+// ones after them the temporaries of the expressions being evaluated.
+type RegisterOp byte
+
+const (
+	RMove RegisterOp = iota
+	RTrue
+	RFalse
+	RNull
+
+	RGetGlobal
+	RSetGlobal
+	RGetBuiltin
+	RGetFree
+	RCurrentClosure
+
+	RAdd
+	RSub
+	RMul
+	RDiv
+	RMod
+
+	REqual
+	RNotEqual
+	RGreaterThan
+	RLessThan
+
+	RMinus
+	RBang
+
+	RJump
+	RJumpNotTruthy
+	RJumpNull
+	RJumpNotNull
+	RJumpNotLess
+	RJumpNotGreater
+	RJumpNotEqual
+	RJumpEqual
+
+	RArray
+	RHash
+	RIndex
+	RGetField
+	RGetOptionalField
+
+	RCall
+	RTailCall
+	RClosure
+	RReturnValue
+	RReturn
+	RThrow
+
+	RPop
+)
+
+// RegisterInstruction is an instruction of the register VM, with up to three
+// operands, whose meaning depends on Op:
+//
+//	r  a register
+//	x  a register when not negative, or else the constant -x-1
+//	n  a number: an index, a count or the index of a jump target
+type RegisterInstruction struct {
+	Op      RegisterOp
+	A, B, C int32
+}
+
+// RegisterDefinition names a RegisterOp and the kinds of its operands, one
+// of r, x or n each.
+type RegisterDefinition struct {
+	Name     string
+	Operands string
+}
+
+var registerDefinitions = map[RegisterOp]*RegisterDefinition{
+	RMove:  {"RMove", "rx"},
+	RTrue:  {"RTrue", "r"},
+	RFalse: {"RFalse", "r"},
+	RNull:  {"RNull", "r"},
+
+	RGetGlobal:  {"RGetGlobal", "rn"},
+	RSetGlobal:  {"RSetGlobal", "nx"},
+	RGetBuiltin: {"RGetBuiltin", "rn"},
+	RGetFree:    {"RGetFree", "rn"},
+
+	RCurrentClosure: {"RCurrentClosure", "r"},
+
+	RAdd: {"RAdd", "rxx"},
+	RSub: {"RSub", "rxx"},
+	RMul: {"RMul", "rxx"},
+	RDiv: {"RDiv", "rxx"},
+	RMod: {"RMod", "rxx"},
+
+	REqual:       {"REqual", "rxx"},
+	RNotEqual:    {"RNotEqual", "rxx"},
+	RGreaterThan: {"RGreaterThan", "rxx"},
+	RLessThan:    {"RLessThan", "rxx"},
+
+	RMinus: {"RMinus", "rx"},
+	RBang:  {"RBang", "rx"},
+
+	RJump:           {"RJump", "n"},
+	RJumpNotTruthy:  {"RJumpNotTruthy", "nx"},
+	RJumpNull:       {"RJumpNull", "nx"},
+	RJumpNotNull:    {"RJumpNotNull", "nx"},
+	RJumpNotLess:    {"RJumpNotLess", "nxx"},
+	RJumpNotGreater: {"RJumpNotGreater", "nxx"},
+	RJumpNotEqual:   {"RJumpNotEqual", "nxx"},
+	RJumpEqual:      {"RJumpEqual", "nxx"},
+
+	// The elements of arrays and hashes, the arguments of calls and the
+	// free variables of closures are in the registers from A on.
+	RArray:            {"RArray", "rn"},
+	RHash:             {"RHash", "rn"},
+	RIndex:            {"RIndex", "rxx"},
+	RGetField:         {"RGetField", "rxn"},
+	RGetOptionalField: {"RGetOptionalField", "rxn"},
+
+	RCall:        {"RCall", "rn"},
+	RTailCall:    {"RTailCall", "rn"},
+	RClosure:     {"RClosure", "rnn"},
+	RReturnValue: {"RReturnValue", "x"},
+	RReturn:      {"RReturn", ""},
+	RThrow:       {"RThrow", "x"},
+
+	RPop: {"RPop", "x"},
+}
+
+func LookupRegister(op RegisterOp) (*RegisterDefinition, error) {
+	def, ok := registerDefinitions[op]
+	if !ok {
+		return nil, fmt.Errorf("register opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// IsJump reports whether the instruction jumps to the instruction A.
+func (ins RegisterInstruction) IsJump() bool {
+	return ins.Op >= RJump && ins.Op <= RJumpEqual
+}
+
+type RegisterInstructions []RegisterInstruction
+
+func (ins RegisterInstructions) String() string {
+	var out bytes.Buffer
+
+	for i, instruction := range ins {
+		def, err := LookupRegister(instruction.Op)
+		if err != nil {
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			continue
+		}
+
+		fmt.Fprintf(&out, "%04d %s", i, def.Name)
+
+		operands := []int32{instruction.A, instruction.B, instruction.C}
+		for j, kind := range def.Operands {
+			switch {
+			case kind == 'n':
+				fmt.Fprintf(&out, " %d", operands[j])
+			case kind == 'x' && operands[j] < 0:
+				fmt.Fprintf(&out, " k%d", -operands[j]-1)
+			default:
+				fmt.Fprintf(&out, " r%d", operands[j])
+			}
+		}
+
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
@@ -0,0 +1,82 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// SourceMap maps instructions back to the source code they were compiled
+// from. Its entries are sorted by offset and each one covers the
+// instructions up to the next entry.
+type SourceMap []SourceMapEntry
+
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Position
+
+	// The calls whose bodies the compiler inlined around the instructions,
+	// innermost first, for stack traces to show a frame for each.
+	Inlined *InlinedCall
+	// TailCall reports whether the instruction is a call in tail position
+	// of the innermost inlined body. Once the callee runs, that body has
+	// returned, as if the call hadn't been inlined and the callee had
+	// replaced its frame.
+	TailCall bool
+}
+
+// InlinedCall is a call the compiler replaced with the body of the function
+// called.
+type InlinedCall struct {
+	Function string
+	NumArgs  int
+	// Position of the call.
+	Pos token.Position
+	// The inlined call the call is in, if any.
+	Outer *InlinedCall
+}
+
+// Add maps the instructions starting at offset to pos. Entries after offset,
+// left over from removed instructions, are dropped.
+func (m SourceMap) Add(offset int, pos token.Position) SourceMap {
+	return m.AddEntry(SourceMapEntry{Offset: offset, Pos: pos})
+}
+
+// AddEntry maps the instructions starting at entry.Offset to the rest of
+// entry, like Add.
+func (m SourceMap) AddEntry(entry SourceMapEntry) SourceMap {
+	m = m.Truncate(entry.Offset)
+
+	if n := len(m); n > 0 {
+		last := m[n-1]
+		last.Offset = entry.Offset
+		if last == entry {
+			return m
+		}
+	}
+
+	return append(m, entry)
+}
+
+// Truncate drops the entries of the instructions from offset on.
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset >= offset })
+	return m[:i]
+}
+
+// Lookup returns the position of the instruction at offset, or the zero
+// Position if it is unknown.
+func (m SourceMap) Lookup(offset int) token.Position {
+	return m.Entry(offset).Pos
+}
+
+// Entry returns the entry covering the instruction at offset, or one with
+// the zero Position if it is unknown.
+func (m SourceMap) Entry(offset int) SourceMapEntry {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return SourceMapEntry{Offset: offset}
+	}
+
+	return m[i-1]
+}
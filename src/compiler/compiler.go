@@ -2,70 +2,126 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
 	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/token"
 )
 
+// EmittedInstruction is an instruction emitted into Block, at Index.
 type EmittedInstruction struct {
-	Opcode   code.Opcode
-	Position int
+	Opcode code.Opcode
+	Block  *ir.Block
+	Index  int
 }
 
 type CompilationScope struct {
-	instructions        code.Instructions
+	fn *ir.Function
+	// The block instructions are emitted into, the last of fn.
+	block               *ir.Block
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// The try and catch blocks being compiled, innermost last.
+	protected []*protectedRegion
+	// Number of values the instructions emitted so far leave on the stack,
+	// above the locals.
+	stackDepth int
+
+	// The calls in tail position of the function being compiled.
+	tailCalls map[*ast.CallExpression]bool
 }
 
 type Compiler struct {
 	constants []object.Object
+	// Indexes of the constants that are shared by equal literals.
+	interned map[constantKey]int
 
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// The block after the chain being compiled, the `OpJumpNull`
+	// instructions of its optional links jump to. Nil until the first one.
+	chainEnd *ir.Block
+
+	// Source position of the node being compiled, recorded in the source
+	// map of the instructions emitted for it.
+	pos token.Position
+	// The calls whose bodies are being compiled in place of them, and the
+	// calls in tail position of the innermost body, see compileInlineCall.
+	inlined      *code.InlinedCall
+	inlinedTails map[*ast.CallExpression]bool
+
+	optimization    OptimizationLevel
+	inlineThreshold int
+	// The function literals compiled without free variables.
+	closedFunctions map[*ast.FunctionLiteral]bool
+	// The functions compiled so far, for IR.
+	functions []*ir.Function
+
+	warnings []string
 }
 
-func New() *Compiler {
-	mainScope := CompilationScope{
-		instructions:        code.Instructions{},
-		lastInstruction:     EmittedInstruction{},
-		previousInstruction: EmittedInstruction{},
-	}
+func New(options ...Option) *Compiler {
+	mainScope := newScope(object.MainFunction)
 
 	symbolTable := NewSymbolTable()
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 
-	return &Compiler{
+	c := &Compiler{
 		constants:   []object.Object{},
+		interned:    map[constantKey]int{},
 		symbolTable: symbolTable,
 
 		scopes:     []CompilationScope{mainScope},
 		scopeIndex: 0,
+
+		inlineThreshold: DefaultInlineThreshold,
+		closedFunctions: map[*ast.FunctionLiteral]bool{},
 	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+func NewWithState(
+	s *SymbolTable,
+	constants []object.Object,
+	options ...Option,
+) *Compiler {
+	compiler := New(options...)
 	compiler.symbolTable = s
 	compiler.constants = constants
 
+	for i, constant := range constants {
+		if key, ok := internKey(constant); ok {
+			compiler.interned[key] = i
+		}
+	}
+
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if exp, ok := node.(ast.Expression); ok && c.optimization >= OptimizeConstants {
+		if value, ok := c.fold(exp); ok {
+			c.emitValue(value)
+			return nil
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			if err := c.Compile(s); err != nil {
-				return err
-			}
-		}
+		return c.compileStatements(node.Statements, true)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
@@ -83,11 +139,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 
 	case *ast.ExpressionStatement:
 		if err := c.Compile(node.Expression); err != nil {
@@ -96,30 +148,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			if err := c.Compile(s); err != nil {
-				return err
-			}
-		}
+		return c.compileStatements(node.Statements, false)
 
 	case *ast.ReturnStatement:
 		if err := c.Compile(node.ReturnValue); err != nil {
 			return err
 		}
 
-		c.emit(code.OpReturnValue)
+		return c.emitReturn()
 
 	// Expression
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			if err := c.Compile(node.Right); err != nil {
+		if node.Operator == "??" {
+			if err := c.Compile(node.Left); err != nil {
 				return err
 			}
-			if err := c.Compile(node.Left); err != nil {
+
+			after := &ir.Block{}
+			c.emitJump(code.OpJumpNotNull, after)
+			c.emit(code.OpPop)
+
+			if err := c.Compile(node.Right); err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+
+			c.startBlock(after)
 			return nil
 		}
 
@@ -129,6 +183,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Right); err != nil {
 			return err
 		}
+
+		defer c.setPos(node.Token.Pos)()
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
@@ -138,6 +194,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "<":
+			c.emit(code.OpLessThan)
 		case ">":
 			c.emit(code.OpGreaterThan)
 		case "==":
@@ -153,6 +213,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		defer c.setPos(node.Token.Pos)()
+
 		switch node.Operator {
 		case "!":
 			c.emit(code.OpBang)
@@ -163,12 +225,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IfExpression:
+		if c.optimization >= OptimizeConstants {
+			if known, err := c.compileKnownIf(node); known || err != nil {
+				return err
+			}
+		}
+
 		if err := c.Compile(node.Condition); err != nil {
 			return err
 		}
 
-		// Emit an `OpJumpNotTruthy` with a bogus value to patch later.
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		alternative := &ir.Block{}
+		c.emitJump(code.OpJumpNotTruthy, alternative)
+
+		// Only one branch runs, so both start from the depth before the
+		// consequence, and the if leaves one value above it, even when a
+		// branch ends in a return and leaves none.
+		depth := c.scopes[c.scopeIndex].stackDepth
 
 		if err := c.Compile(node.Consequence); err != nil {
 			return err
@@ -178,11 +251,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.removeLastPop()
 		}
 
-		// Emit an `OpJump` with a bogus value to patch later.
-		jumpPos := c.emit(code.OpJump, 9999)
+		after := &ir.Block{}
+		c.emitJump(code.OpJump, after)
 
-		afterConsequncePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequncePos)
+		c.startBlock(alternative)
+		c.scopes[c.scopeIndex].stackDepth = depth
 
 		if node.Alternative.Statements == nil {
 			c.emit(code.OpNull)
@@ -196,24 +269,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
-		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		c.startBlock(after)
+		c.scopes[c.scopeIndex].stackDepth = depth + 1
 
-	case *ast.IndexExpression:
-		if err := c.Compile(node.Left); err != nil {
-			return err
-		}
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression:
+		return c.compileChain(node.(ast.Expression))
 
-		if err := c.Compile(node.Index); err != nil {
+	case *ast.ThrowExpression:
+		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
 
-		c.emit(code.OpIndex)
+		defer c.setPos(node.Token.Pos)()
+		c.emit(code.OpThrow)
+
+		// Execution never gets past the OpThrow, but the code around counts
+		// on the expression leaving a value.
+		c.scopes[c.scopeIndex].stackDepth++
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
 
 	// Literals
 
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewInteger(node.Big)
+		}
 		c.emit(code.OpConstant, c.addConstant(integer))
 
 	case *ast.StringLiteral:
@@ -227,6 +310,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			if err := c.Compile(el); err != nil {
@@ -236,34 +322,39 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, key := range keys {
-			if err := c.Compile(key); err != nil {
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
 				return err
 			}
 
-			if err := c.Compile(node.Pairs[key]); err != nil {
+			if err := c.Compile(pair.Value); err != nil {
 				return err
 			}
 		}
+
+		defer c.setPos(node.Token.Pos)()
 		c.emit(code.OpHash, len(node.Pairs)*2)
 
+	case *ast.MacroLiteral:
+		return fmt.Errorf("macro literals are only allowed in top-level let statements")
+
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		c.scopes[c.scopeIndex].tailCalls = ast.TailCalls(node.Body)
+
+		// A function bound to a local refers to itself as the closure being
+		// run, since the local it would capture is only set once the closure
+		// is made. Globals are read when used, so they need no such name.
+		outer, ok := c.symbolTable.Outer.store[node.Name]
+		if node.Name != "" && ok && outer.Scope == LocalScope {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 
 		for _, parameter := range node.Parameters {
 			c.symbolTable.Define(parameter.Value)
 		}
 
-		if err := c.Compile(node.Body); err != nil {
+		if err := c.compileStatements(node.Body.Statements, true); err != nil {
 			return err
 		}
 
@@ -277,23 +368,84 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
+		if len(freeSymbols) == 0 {
+			c.closedFunctions[node] = true
+		}
+
+		fn := c.leaveScope()
+		if node.Name != "" {
+			fn.Name = node.Name
+		}
+		fn.NumParameters = len(node.Parameters)
+		fn.NumLocals = numLocals
+		instructions, sourceMap, handlers := c.finish(fn)
 
 		for _, sym := range freeSymbols {
 			c.loadSymbol(sym)
 		}
 
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
 			Instructions:  instructions,
+			SourceMap:     sourceMap,
+			Handlers:      handlers,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			MaxStackDepth: maxStackDepth(fn),
 		}
 
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
+		fn.Constant = fnIndex
+		c.functions = append(c.functions, fn)
+
+	}
+
+	return nil
+}
+
+// compileChain compiles a chain of call, index and member expressions. Every
+// optional link jumps past the end of the whole chain when its operand is
+// null, leaving that null as the value of the chain.
+func (c *Compiler) compileChain(node ast.Expression) error {
+	outerEnd := c.chainEnd
+	c.chainEnd = nil
+
+	if err := c.compileChainLink(node); err != nil {
+		return err
+	}
+
+	if c.chainEnd != nil {
+		c.startBlock(c.chainEnd)
+	}
+
+	c.chainEnd = outerEnd
+
+	return nil
+}
+
+// emitChainJump emits the `OpJumpNull` of an optional link of the chain.
+func (c *Compiler) emitChainJump() {
+	if c.chainEnd == nil {
+		c.chainEnd = &ir.Block{}
+	}
+
+	c.emitJump(code.OpJumpNull, c.chainEnd)
+}
+
+func (c *Compiler) compileChainLink(node ast.Expression) error {
+	switch node := node.(type) {
 	case *ast.CallExpression:
-		if err := c.Compile(node.Function); err != nil {
+		if ident, ok := node.Function.(*ast.Identifier); ok && ident.Value == "quote" {
+			return c.compileQuote(node)
+		}
+
+		if inlined, err := c.compileInlineCall(node); inlined || err != nil {
+			return err
+		}
+
+		if err := c.compileChainLink(node.Function); err != nil {
 			return err
 		}
 
@@ -303,90 +455,235 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpCall, len(node.Arguments))
+		op := code.OpCall
+		if c.scopes[c.scopeIndex].tailCalls[node] {
+			op = code.OpTailCall
+		}
+
+		defer c.setPos(node.Token.Pos)()
+		c.emit(op, len(node.Arguments))
+
+		if c.inlinedTails[node] {
+			last := c.scopes[c.scopeIndex].lastInstruction
+			last.Block.Instructions[last.Index].TailCall = true
+		}
+
+	case *ast.IndexExpression:
+		if err := c.compileChainLink(node.Left); err != nil {
+			return err
+		}
+
+		if node.Optional {
+			c.emitChainJump()
+		}
+
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		defer c.setPos(node.Token.Pos)()
+		c.emit(code.OpIndex)
+
+	case *ast.MemberExpression:
+		if err := c.compileChainLink(node.Object); err != nil {
+			return err
+		}
+
+		name := &object.String{Value: node.Property.Value}
 
+		defer c.setPos(node.Token.Pos)()
+		if node.Optional {
+			c.emitChainJump()
+			c.emit(code.OpGetOptionalField, c.addConstant(name))
+		} else {
+			c.emit(code.OpGetField, c.addConstant(name))
+		}
+
+	default:
+		return c.Compile(node)
 	}
 
 	return nil
 }
 
-func (c *Compiler) addConstant(obj object.Object) int {
-	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+// compileQuote turns `quote(expr)` into an `object.Quote` constant. Unquoting
+// needs the evaluator and is only supported inside macros.
+func (c *Compiler) compileQuote(node *ast.CallExpression) error {
+	if len(node.Arguments) != 1 {
+		return fmt.Errorf("wrong number of arguments to quote. got=%d, want=1",
+			len(node.Arguments))
+	}
+
+	hasUnquote := false
+	ast.Inspect(node.Arguments[0], func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpression)
+		if !ok {
+			return !hasUnquote
+		}
+		if ident, ok := call.Function.(*ast.Identifier); ok && ident.Value == "unquote" {
+			hasUnquote = true
+		}
+		return !hasUnquote
+	}, nil)
+	if hasUnquote {
+		return fmt.Errorf("unquote is only supported inside macros")
+	}
+
+	quote := &object.Quote{Node: node.Arguments[0]}
+	c.emit(code.OpConstant, c.addConstant(quote))
+
+	return nil
 }
 
-func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
-	pos := c.addInstruction(ins)
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := internKey(obj)
+	if ok {
+		if index, ok := c.interned[key]; ok {
+			return index
+		}
+	}
 
-	c.setLastInstruction(op, pos)
+	c.constants = append(c.constants, obj)
+	index := len(c.constants) - 1
 
-	return pos
+	if ok {
+		c.interned[key] = index
+	}
+	return index
 }
 
-func (c *Compiler) currentInstructions() code.Instructions {
-	return c.scopes[c.scopeIndex].instructions
+func (c *Compiler) emit(op code.Opcode, operands ...int) {
+	c.addInstruction(ir.Instruction{
+		Op:       op,
+		Operands: operands,
+		Pos:      c.pos,
+		Inlined:  c.inlined,
+	})
+	c.scopes[c.scopeIndex].stackDepth += stackEffect(op, operands...)
 }
 
-func (c *Compiler) addInstruction(ins []byte) int {
-	posNewInstruction := len(c.currentInstructions())
-	updatedInstructions := append(c.currentInstructions(), ins...)
+// emitJump emits the jump op to target, a block started later on.
+func (c *Compiler) emitJump(op code.Opcode, target *ir.Block) {
+	c.addInstruction(ir.Instruction{
+		Op:      op,
+		Target:  target,
+		Pos:     c.pos,
+		Inlined: c.inlined,
+	})
+	c.scopes[c.scopeIndex].stackDepth += stackEffect(op)
+}
 
-	c.scopes[c.scopeIndex].instructions = updatedInstructions
+// addInstruction adds ins to the current block, after starting a new one
+// if the current block has ended.
+func (c *Compiler) addInstruction(ins ir.Instruction) {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.block.Ended() {
+		c.startBlock(&ir.Block{})
+	}
 
-	return posNewInstruction
+	scope.block.Instructions = append(scope.block.Instructions, ins)
+	c.setLastInstruction(ins.Op, scope.block, len(scope.block.Instructions)-1)
 }
 
-func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	previous := c.scopes[c.scopeIndex].lastInstruction
-	last := EmittedInstruction{Opcode: op, Position: pos}
+// startBlock lays out b after the current block and makes it the one
+// instructions are emitted into.
+func (c *Compiler) startBlock(b *ir.Block) {
+	scope := &c.scopes[c.scopeIndex]
 
-	c.scopes[c.scopeIndex].previousInstruction = previous
-	c.scopes[c.scopeIndex].lastInstruction = last
+	scope.fn.Blocks = append(scope.fn.Blocks, b)
+	scope.block = b
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+// mark returns a block starting at the current point of the code, for
+// handlers to refer to.
+func (c *Compiler) mark() *ir.Block {
+	scope := &c.scopes[c.scopeIndex]
+	if len(scope.block.Instructions) > 0 {
+		c.startBlock(&ir.Block{})
+	}
 
-	c.replaceInstruction(opPos, newInstruction)
+	return scope.block
 }
 
-func (c *Compiler) replaceInstruction(
-	pos int,
-	newInstruction code.Instructions,
-) {
-	ins := c.currentInstructions()
+func (c *Compiler) setLastInstruction(op code.Opcode, block *ir.Block, index int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Block: block, Index: index}
 
-	for i := 0; i < len(newInstruction); i++ {
-		ins[pos+i] = newInstruction[i]
-	}
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
 func (c *Compiler) replaceLastPopWithReturn() {
-	lastPop := c.scopes[c.scopeIndex].lastInstruction.Position
-	c.replaceInstruction(lastPop, code.Make(code.OpReturnValue))
-
-	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+	last := &c.scopes[c.scopeIndex].lastInstruction
+	last.Block.Instructions[last.Index].Op = code.OpReturnValue
+	last.Opcode = code.OpReturnValue
 }
 
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
-	if len(c.currentInstructions()) == 00 {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	if last.Block == nil {
 		return false
 	}
 
-	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+	return last.Opcode == op
 }
 
 func (c *Compiler) removeLastPop() {
 	last := c.scopes[c.scopeIndex].lastInstruction
 	previous := c.scopes[c.scopeIndex].previousInstruction
 
-	old := c.currentInstructions()
-	new := old[:last.Position]
+	last.Block.Instructions = last.Block.Instructions[:last.Index]
 
-	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].stackDepth++
+}
+
+// setPos makes pos the position of the instructions emitted from now on and
+// returns a function that restores the previous one.
+func (c *Compiler) setPos(pos token.Position) func() {
+	previous := c.pos
+	c.pos = pos
+
+	return func() { c.pos = previous }
+}
+
+// compileStatements compiles the statements of a program, function body or
+// block. The lets of a program or function body run whenever the statements
+// after them do, so the values they bind are used by the optimizations.
+func (c *Compiler) compileStatements(statements []ast.Statement, body bool) error {
+	statements = c.reachable(statements)
+
+	for i, s := range statements {
+		if c.unused(s, i == len(statements)-1) {
+			continue
+		}
+
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !body {
+			continue
+		}
+
+		if c.optimization >= OptimizeConstants {
+			c.propagateConstant(let)
+		}
+		if c.optimization >= OptimizeInline {
+			c.recordInlineFunction(let)
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
 }
 
 func (c *Compiler) loadSymbol(s Symbol) {
@@ -399,43 +696,66 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	default:
 		panic(fmt.Sprintf("unexpected compiler.SymbolScope: %#v", s.Scope))
 	}
 }
 
-func (c *Compiler) enterScope() {
-	scope := CompilationScope{
-		instructions:        code.Instructions{},
-		lastInstruction:     EmittedInstruction{},
-		previousInstruction: EmittedInstruction{},
+// newScope returns the scope of a function named name, with the block
+// its code starts with.
+func newScope(name string) CompilationScope {
+	entry := &ir.Block{}
+
+	return CompilationScope{
+		fn:    &ir.Function{Name: name, Constant: -1, Blocks: []*ir.Block{entry}},
+		block: entry,
 	}
+}
 
-	c.scopes = append(c.scopes, scope)
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, newScope(object.AnonymousFunction))
 	c.scopeIndex++
 
 	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
 }
 
-func (c *Compiler) leaveScope() code.Instructions {
-	instructions := c.currentInstructions()
+func (c *Compiler) leaveScope() *ir.Function {
+	fn := c.scopes[c.scopeIndex].fn
 
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
 
 	c.symbolTable = c.symbolTable.Outer
 
-	return instructions
+	return fn
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions, sourceMap, handlers := c.finish(c.scopes[c.scopeIndex].fn.Copy())
+
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
+		SourceMap:    sourceMap,
+		Handlers:     handlers,
 		Constants:    c.constants,
 	}
 }
 
+// IR returns the intermediate representation of the functions compiled so
+// far, in the order they were compiled, then of the main program, as they
+// are lowered to bytecode.
+func (c *Compiler) IR() []*ir.Function {
+	main := c.scopes[c.scopeIndex].fn.Copy()
+	c.finish(main)
+
+	return append(append([]*ir.Function(nil), c.functions...), main)
+}
+
 type Bytecode struct {
 	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Handlers     code.HandlerTable
 	Constants    []object.Object
 }
@@ -2,13 +2,16 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
 	"github.com/ZeroBl21/go-monkey/src/lexer"
 	"github.com/ZeroBl21/go-monkey/src/object"
 	"github.com/ZeroBl21/go-monkey/src/parser"
+	"github.com/ZeroBl21/go-monkey/src/token"
 )
 
 type compilerTestCase struct {
@@ -25,59 +28,69 @@ func TestIntegerArithmetic(t *testing.T) {
 			input:             "1 + 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1; 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 - 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSub),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSub),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 * 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpMul),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpMul),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "2 / 1",
 			expectedConstants: []any{2, 1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpDiv),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpDiv),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "5 % 2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpMod),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "-1",
 			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpMinus),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpMinus),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -91,18 +104,18 @@ func TestStringExpressions(t *testing.T) {
 			input:             `"monkey"`,
 			expectedConstants: []any{"monkey"},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             `"mon" + "key"`,
 			expectedConstants: []any{"mon", "key"},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -116,85 +129,85 @@ func TestBooleanExpression(t *testing.T) {
 			input:             "true",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "false",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpFalse),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 > 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []any{2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpLessThan),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 == 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "1 != 2",
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpNotEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpNotEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "true == false",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "true != false",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpNotEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpNotEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "!true",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpBang),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -208,19 +221,19 @@ func TestArrayLiterals(t *testing.T) {
 			input:             "[]",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpArray, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpArray, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "[1, 2, 3]",
 			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpArray, 3),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpArray, 3),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -228,20 +241,20 @@ func TestArrayLiterals(t *testing.T) {
 			expectedConstants: []any{1, 2, 3, 4, 5, 6},
 			expectedInstructions: []code.Instructions{
 				// 1 + 2
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
 				// 3 - 4
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpSub),
 				// 5 * 6
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpMul),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpConstant, 5),
+				code.MustMake(code.OpMul),
 				// Array size 3
-				code.Make(code.OpArray, 3),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpArray, 3),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -255,22 +268,22 @@ func TestHashLiterals(t *testing.T) {
 			input:             "{}",
 			expectedConstants: []any{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpHash, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpHash, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "{1: 2, 3: 4, 5: 6}",
 			expectedConstants: []any{1, 2, 3, 4, 5, 6},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpHash, 6),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpConstant, 5),
+				code.MustMake(code.OpHash, 6),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -278,20 +291,32 @@ func TestHashLiterals(t *testing.T) {
 			expectedConstants: []any{1, 2, 3, 4, 5, 6},
 			expectedInstructions: []code.Instructions{
 				// 1
-				code.Make(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 0),
 				// 2 + 3
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpAdd),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpAdd),
 				// 4
-				code.Make(code.OpConstant, 3),
+				code.MustMake(code.OpConstant, 3),
 				// 5 * 6
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpMul),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpConstant, 5),
+				code.MustMake(code.OpMul),
 				// Hash size 6
-				code.Make(code.OpHash, 4),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpHash, 4),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `{"b": 1, "a": 2}`,
+			expectedConstants: []any{"b", 1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpHash, 4),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -303,31 +328,116 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []any{1, 2, 3, 1, 1},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpAdd),
-				code.Make(code.OpIndex),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpArray, 3),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []any{1, 2, 2, 1},
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpHash, 2),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSub),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMemberExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `{"title": 1}.title`,
+			expectedConstants: []any{"title", 1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpHash, 2),
+				code.MustMake(code.OpGetField, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `[1].push(2)`,
+			expectedConstants: []any{1, "push", 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpArray, 1),
+				code.MustMake(code.OpGetField, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestNullSafeExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `null ?? 1`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpNull),
+				// 0001
+				code.MustMake(code.OpJumpNotNull, 8),
+				// 0004
+				code.MustMake(code.OpPop),
+				// 0005
+				code.MustMake(code.OpConstant, 0),
+				// 0008
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `null?.a.b`,
+			expectedConstants: []any{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpNull),
+				// 0001
+				code.MustMake(code.OpJumpNull, 10),
+				// 0004
+				code.MustMake(code.OpGetOptionalField, 0),
+				// 0007
+				code.MustMake(code.OpGetField, 1),
+				// 0010
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `null?[1]`,
+			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
-				code.Make(code.OpIndex),
-				code.Make(code.OpPop),
+				// 0000
+				code.MustMake(code.OpNull),
+				// 0001
+				code.MustMake(code.OpJumpNull, 8),
+				// 0004
+				code.MustMake(code.OpConstant, 0),
+				// 0007
+				code.MustMake(code.OpIndex),
+				// 0008
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -343,15 +453,15 @@ func TestFunctions(t *testing.T) {
 				5,
 				10,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -360,15 +470,15 @@ func TestFunctions(t *testing.T) {
 				5,
 				10,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -377,15 +487,15 @@ func TestFunctions(t *testing.T) {
 				1,
 				2,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0),
-					code.Make(code.OpPop),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -399,12 +509,12 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 			input: "fn() { }",
 			expectedConstants: []any{
 				[]code.Instructions{
-					code.Make(code.OpReturn),
+					code.MustMake(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -419,14 +529,14 @@ func TestFunctionCalls(t *testing.T) {
 			expectedConstants: []any{
 				24,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0), // The literal 24
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0), // The literal 24
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0), // The compiled function
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 1, 0), // The compiled function
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -436,16 +546,16 @@ func TestFunctionCalls(t *testing.T) {
 			expectedConstants: []any{
 				24,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0), // The literal 24
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0), // The literal 24
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0), // The compiled function
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 1, 0), // The compiled function
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -454,18 +564,18 @@ func TestFunctionCalls(t *testing.T) {
 			oneArg(24);`,
 			expectedConstants: []any{
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpReturnValue),
 				},
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0), // The compiled function
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpCall, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 0, 0), // The compiled function
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -474,26 +584,119 @@ func TestFunctionCalls(t *testing.T) {
 			manyArg(24, 25, 26);`,
 			expectedConstants: []any{
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpPop),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpPop),
-					code.Make(code.OpGetLocal, 2),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpGetLocal, 1),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpGetLocal, 2),
+					code.MustMake(code.OpReturnValue),
 				},
 				24,
 				25,
 				26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0), // The compiled function
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpCall, 3),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 0, 0), // The compiled function
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpCall, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(f) { f(1); f(2)(3) }`,
+			expectedConstants: []any{
+				1,
+				2,
+				3,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpCall, 1),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpCall, 1),
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 3, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `fn(f) { if (f) { return f(); }; f() + 1 }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					// 0000
+					code.MustMake(code.OpGetLocal, 0),
+					// 0002
+					code.MustMake(code.OpJumpNotTruthy, 13),
+					// 0005
+					code.MustMake(code.OpGetLocal, 0),
+					// 0007
+					code.MustMake(code.OpTailCall, 0),
+					// 0009
+					code.MustMake(code.OpReturnValue),
+					// 0010
+					code.MustMake(code.OpJump, 14),
+					// 0013
+					code.MustMake(code.OpNull),
+					// 0014
+					code.MustMake(code.OpPop),
+					// 0015
+					code.MustMake(code.OpGetLocal, 0),
+					// 0017
+					code.MustMake(code.OpCall, 0),
+					// 0019
+					code.MustMake(code.OpConstant, 0),
+					// 0022
+					code.MustMake(code.OpAdd),
+					// 0023
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// A handler of the function must stay active during the call.
+			input: `fn(f) { try { f() } catch (e) { e } }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					// 0000
+					code.MustMake(code.OpGetLocal, 0),
+					// 0002
+					code.MustMake(code.OpCall, 0),
+					// 0004
+					code.MustMake(code.OpJump, 11),
+					// 0007
+					code.MustMake(code.OpSetLocal, 1),
+					// 0009
+					code.MustMake(code.OpGetLocal, 1),
+					// 0011
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -509,31 +712,31 @@ func TestBuiltins(t *testing.T) {
 			push([], 1);`,
 			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpGetBuiltin, 0),
-				code.Make(code.OpArray, 0),
-				code.Make(code.OpCall, 1),
-				code.Make(code.OpPop),
-
-				code.Make(code.OpGetBuiltin, 5),
-				code.Make(code.OpArray, 0),
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpCall, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpGetBuiltin, 0),
+				code.MustMake(code.OpArray, 0),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
+
+				code.MustMake(code.OpGetBuiltin, 5),
+				code.MustMake(code.OpArray, 0),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpCall, 2),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: `fn() { len([]) }`,
 			expectedConstants: []any{
 				[]code.Instructions{
-					code.Make(code.OpGetBuiltin, 0),
-					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetBuiltin, 0),
+					code.MustMake(code.OpArray, 0),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -554,25 +757,25 @@ func TestClosures(t *testing.T) {
 				// fn(b)
 				[]code.Instructions{
 					// a
-					code.Make(code.OpGetFree, 0),
+					code.MustMake(code.OpGetFree, 0),
 					// b
-					code.Make(code.OpGetLocal, 0),
+					code.MustMake(code.OpGetLocal, 0),
 					// a + b
-					code.Make(code.OpAdd),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
 				},
 				// fn(a)
 				[]code.Instructions{
 					// a
-					code.Make(code.OpGetLocal, 0),
+					code.MustMake(code.OpGetLocal, 0),
 					// fn(b)
-					code.Make(code.OpClosure, 0, 1),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpClosure, 0, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -586,28 +789,28 @@ func TestClosures(t *testing.T) {
 			};`,
 			expectedConstants: []any{
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetFree, 1),
-					code.Make(code.OpAdd),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpAdd),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetFree, 0),
+					code.MustMake(code.OpGetFree, 1),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 0, 2),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetFree, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpClosure, 0, 2),
+					code.MustMake(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 1, 1),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpClosure, 1, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -633,38 +836,141 @@ func TestClosures(t *testing.T) {
 				77,
 				88,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 3),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetGlobal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpAdd),
-					code.Make(code.OpGetFree, 1),
-					code.Make(code.OpAdd),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpAdd),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 3),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetFree, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpGetFree, 1),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpGetFree, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpClosure, 4, 2),
+					code.MustMake(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpClosure, 5, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpClosure, 6, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{`fn() { }`, 0},
+		{`fn(a) { a }`, 1},
+		{`fn(a, b) { a + b * 2 }`, 3},
+		{`fn(f) { f(1, 2, 3) }`, 4},
+		// The branches of an if start from the same depth.
+		{`fn(x) { [1, if (x) { return 2 } else { [3, 4] }] }`, 3},
+		// A handler starts from its depth with the error on top.
+		{`fn(x) { [1, 2, try { x } catch (e) { 5 }] }`, 3},
+	}
+
+	for _, tt := range tests {
+		for _, level := range []OptimizationLevel{OptimizeNone, OptimizeSpecialize} {
+			compiler := New(WithOptimization(level))
+			if err := compiler.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			constants := compiler.Bytecode().Constants
+			fn, ok := constants[len(constants)-1].(*object.CompiledFunction)
+			if !ok {
+				t.Fatalf("last constant is not a function. got=%T",
+					constants[len(constants)-1])
+			}
+
+			if fn.MaxStackDepth != tt.expected {
+				t.Errorf("level %d: %s: wrong MaxStackDepth. want=%d, got=%d",
+					level, tt.input, tt.expected, fn.MaxStackDepth)
+			}
+		}
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// A global is read when called, so it refers to the function.
+			input: `let countDown = fn(x) { countDown(x - 1) }; countDown(1);`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpSub),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
 				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// A local is only set once the closure is made, so the closure
+			// refers to itself instead of capturing it.
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1) };
+				countDown(1)
+			};
+			wrapper();`,
+			expectedConstants: []any{
+				1,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 4, 2),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpCurrentClosure),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpSub),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 5, 1),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpClosure, 1, 0),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpClosure, 6, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -689,9 +995,9 @@ func TestCompilerScopes(t *testing.T) {
 
 	compiler.emit(code.OpSub)
 
-	if len(compiler.scopes[compiler.scopeIndex].instructions) != 1 {
+	if len(compiler.scopes[compiler.scopeIndex].block.Instructions) != 1 {
 		t.Errorf("instruction length wrong. got=%d",
-			len(compiler.scopes[compiler.scopeIndex].instructions))
+			len(compiler.scopes[compiler.scopeIndex].block.Instructions))
 	}
 
 	last := compiler.scopes[compiler.scopeIndex].lastInstruction
@@ -721,9 +1027,9 @@ func TestCompilerScopes(t *testing.T) {
 
 	compiler.emit(code.OpAdd)
 
-	if len(compiler.scopes[compiler.scopeIndex].instructions) != 2 {
+	if len(compiler.scopes[compiler.scopeIndex].block.Instructions) != 2 {
 		t.Errorf("instruction length wrong. got=%d",
-			len(compiler.scopes[compiler.scopeIndex].instructions))
+			len(compiler.scopes[compiler.scopeIndex].block.Instructions))
 	}
 
 	last = compiler.scopes[compiler.scopeIndex].lastInstruction
@@ -751,21 +1057,21 @@ func TestConditionals(t *testing.T) {
 			expectedConstants: []any{10, 3333},
 			expectedInstructions: []code.Instructions{
 				// 0000
-				code.Make(code.OpTrue),
+				code.MustMake(code.OpTrue),
 				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
+				code.MustMake(code.OpJumpNotTruthy, 10),
 				// 0004
-				code.Make(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 0),
 				// 0007
-				code.Make(code.OpJump, 11),
+				code.MustMake(code.OpJump, 11),
 				// 00010
-				code.Make(code.OpNull),
+				code.MustMake(code.OpNull),
 				// 00011
-				code.Make(code.OpPop),
+				code.MustMake(code.OpPop),
 				// 0012
-				code.Make(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 1),
 				// 0015
-				code.Make(code.OpPop),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -773,21 +1079,115 @@ func TestConditionals(t *testing.T) {
 			expectedConstants: []any{10, 20, 3333},
 			expectedInstructions: []code.Instructions{
 				// 0000
-				code.Make(code.OpTrue),
+				code.MustMake(code.OpTrue),
 				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
+				code.MustMake(code.OpJumpNotTruthy, 10),
 				// 0004
-				code.Make(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 0),
 				// 0007
-				code.Make(code.OpJump, 13),
+				code.MustMake(code.OpJump, 13),
 				// 00010
-				code.Make(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 1),
 				// 00013
-				code.Make(code.OpPop),
+				code.MustMake(code.OpPop),
 				// 0014
-				code.Make(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 2),
+				// 0017
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { 1 } catch (e) { 2 }`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpConstant, 0),
+				// 0003
+				code.MustMake(code.OpJump, 12),
+				// 0006
+				code.MustMake(code.OpSetGlobal, 0),
+				// 0009
+				code.MustMake(code.OpConstant, 1),
+				// 0012
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `try { 1 } finally { 2 }`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpConstant, 0),
+				// 0003
+				code.MustMake(code.OpJump, 6),
+				// 0006
+				code.MustMake(code.OpConstant, 1),
+				// 0009
+				code.MustMake(code.OpPop),
+				// 0010
+				code.MustMake(code.OpJump, 18),
+				// 0013
+				code.MustMake(code.OpConstant, 1),
+				// 0016
+				code.MustMake(code.OpPop),
 				// 0017
-				code.Make(code.OpPop),
+				code.MustMake(code.OpThrow),
+				// 0018
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { try { return 1 } finally { 2 } }`,
+			expectedConstants: []any{
+				1, 2,
+				[]code.Instructions{
+					// 0000
+					code.MustMake(code.OpConstant, 0),
+					// 0003
+					code.MustMake(code.OpConstant, 1),
+					// 0006
+					code.MustMake(code.OpPop),
+					// 0007
+					code.MustMake(code.OpReturnValue),
+					// 0008
+					code.MustMake(code.OpNull),
+					// 0009
+					code.MustMake(code.OpJump, 12),
+					// 0012
+					code.MustMake(code.OpConstant, 1),
+					// 0015
+					code.MustMake(code.OpPop),
+					// 0016
+					code.MustMake(code.OpJump, 24),
+					// 0019
+					code.MustMake(code.OpConstant, 1),
+					// 0022
+					code.MustMake(code.OpPop),
+					// 0023
+					code.MustMake(code.OpThrow),
+					// 0024
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `throw 1`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpThrow),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -795,6 +1195,64 @@ func TestConditionals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestHandlerTables(t *testing.T) {
+	handler := func(start, end, target, depth int, finally bool) code.Handler {
+		return code.Handler{
+			Start:   start,
+			End:     end,
+			Target:  target,
+			Depth:   depth,
+			Finally: finally,
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected code.HandlerTable
+	}{
+		{`try { 1 } catch (e) { 2 }`, code.HandlerTable{handler(0, 3, 6, 0, false)}},
+		{`try { 1 } finally { 2 }`, code.HandlerTable{handler(0, 3, 13, 0, true)}},
+		{`1 + try { 2 } catch (e) { 3 }`, code.HandlerTable{handler(3, 6, 9, 1, false)}},
+		{
+			`try { 1 } catch (e) { 2 } finally { 3 }`,
+			code.HandlerTable{handler(0, 3, 6, 0, false), handler(6, 12, 19, 0, true)},
+		},
+		{
+			`try { try { 1 } catch (e) { 2 } } catch (e) { 3 }`,
+			code.HandlerTable{handler(0, 3, 6, 0, false), handler(0, 12, 15, 0, false)},
+		},
+		{
+			`if (true) { 1 } else { 2 } + try { 3 } catch (e) { 4 }`,
+			code.HandlerTable{handler(13, 16, 19, 1, false)},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		handlers := compiler.Bytecode().Handlers
+		if fmt.Sprint(handlers) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s: wrong handlers. want=%v, got=%v",
+				tt.input, tt.expected, handlers)
+		}
+	}
+
+	compiler := New()
+	err := compiler.Compile(parse(`fn() { try { return 1 } finally { 2 } }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn := compiler.Bytecode().Constants[2].(*object.CompiledFunction)
+	expected := code.HandlerTable{handler(0, 3, 19, 0, true), handler(8, 9, 19, 0, true)}
+	if fmt.Sprint(fn.Handlers) != fmt.Sprint(expected) {
+		t.Errorf("wrong function handlers. want=%v, got=%v", expected, fn.Handlers)
+	}
+}
+
 func TestGlobalLetStatement(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -803,10 +1261,10 @@ func TestGlobalLetStatement(t *testing.T) {
 			let two = 2;`,
 			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSetGlobal, 1),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 1),
 			},
 		},
 		{
@@ -815,10 +1273,10 @@ func TestGlobalLetStatement(t *testing.T) {
 			one;`,
 			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -828,12 +1286,12 @@ func TestGlobalLetStatement(t *testing.T) {
 			two;`,
 			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpSetGlobal, 1),
-				code.Make(code.OpGetGlobal, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -851,15 +1309,15 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedConstants: []any{
 				55,
 				[]code.Instructions{
-					code.Make(code.OpGetGlobal, 0),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpClosure, 1, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -872,15 +1330,15 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedConstants: []any{
 				55,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 0),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -896,22 +1354,22 @@ func TestLetStatementScopes(t *testing.T) {
 				77,
 				[]code.Instructions{
 					// let a = 55;
-					code.Make(code.OpConstant, 0),
-					code.Make(code.OpSetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpSetLocal, 0),
 					// let b = 55;
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpSetLocal, 1),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpSetLocal, 1),
 					// a + b;
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpAdd),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpGetLocal, 1),
+					code.MustMake(code.OpAdd),
 
-					code.Make(code.OpReturnValue),
+					code.MustMake(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -919,15 +1377,1226 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-// Helpers
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + 2 * 3`,
+			expectedConstants: []any{7},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `-(2 - 5) % 2`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []any{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `!(1 < 2) == (null != false)`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `1 / 0`,
+			expectedConstants: []any{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpDiv),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `9223372036854775807 + 1`,
+			expectedConstants: []any{9223372036854775807, 1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `1 + "a"`,
+			expectedConstants: []any{1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `let one = 1; let two = one + 1; two * 3`,
+			expectedConstants: []any{1, 2, 6},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `let one = 1; fn(one) { one + 1 }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `if (1 > 2) { 10 } else { 20 }; 3333;`,
+			expectedConstants: []any{20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `if ("") { 10 }`,
+			expectedConstants: []any{10},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `if (null) { 10 }`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
 
-func runCompilerTests(t *testing.T, tests []compilerTestCase) {
-	t.Helper()
+	runCompilerTests(t, tests, WithOptimization(OptimizeConstants))
+}
 
-	for _, tt := range tests {
-		program := parse(tt.input)
+func TestConstantPropagationAcrossCompilations(t *testing.T) {
+	symbolTable := NewSymbolTable()
 
-		compiler := New()
+	first := NewWithState(symbolTable, nil, WithOptimization(OptimizeConstants))
+	if err := first.Compile(parse(`let one = 1; if (true) { let two = 2 }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	second := NewWithState(
+		symbolTable,
+		first.Bytecode().Constants,
+		WithOptimization(OptimizeConstants),
+	)
+	if err := second.Compile(parse(`one + two`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// Only the top-level let is propagated: the one in the block might
+	// not have run.
+	expected := []code.Instructions{
+		code.MustMake(code.OpConstant, 0),
+		code.MustMake(code.OpGetGlobal, 1),
+		code.MustMake(code.OpAdd),
+		code.MustMake(code.OpPop),
+	}
+	if err := testInstructions(expected, second.Bytecode().Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"id"; 1; "id"; 1; "1"`,
+			expectedConstants: []any{"id", 1, "1"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `[fn() { 2 }, fn() { 2 }, fn() { 3 }]`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+				3,
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpClosure, 3, 0),
+				code.MustMake(code.OpArray, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Functions with different names tell apart in stack traces.
+			input: `let a = fn() { 2 }; let b = fn() { 2 };`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			// The finally block is compiled twice, sharing its function.
+			input: `try { 1 } finally { fn() { 2 } }`,
+			expectedConstants: []any{
+				1,
+				2,
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpConstant, 0),
+				// 0003
+				code.MustMake(code.OpJump, 6),
+				// 0006
+				code.MustMake(code.OpClosure, 2, 0),
+				// 0010
+				code.MustMake(code.OpPop),
+				// 0011
+				code.MustMake(code.OpJump, 20),
+				// 0014
+				code.MustMake(code.OpClosure, 2, 0),
+				// 0018
+				code.MustMake(code.OpPop),
+				// 0019
+				code.MustMake(code.OpThrow),
+				// 0020
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantPoolUnderHeavyReuse(t *testing.T) {
+	// More uses than an OpConstant operand can index, of a few values.
+	const uses = 70_000
+	values := []string{`"id"`, `"name"`, `1`, `2`, `"1"`}
+
+	var input strings.Builder
+	for i := 0; i < uses; i++ {
+		fmt.Fprintf(&input, "%s;\n", values[i%len(values)])
+	}
+
+	symbolTable := NewSymbolTable()
+	compiler := NewWithState(symbolTable, nil)
+	if err := compiler.Compile(parse(input.String())); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	if len(bytecode.Constants) != len(values) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(values), len(bytecode.Constants))
+	}
+
+	ins := bytecode.Instructions
+	for i, offset := 0, 0; offset < len(ins); i++ {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			t.Fatalf("at %d: %s", offset, err)
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+
+		if code.Opcode(ins[offset]) == code.OpConstant {
+			got := bytecode.Constants[operands[0]].Inspect()
+			want := strings.Trim(values[i/2%len(values)], `"`)
+			if got != want {
+				t.Fatalf("at %d: wrong constant. want=%s, got=%s", offset, want, got)
+			}
+		}
+
+		offset += 1 + read
+	}
+
+	// Compiling more uses of the values, like the REPL does with every line,
+	// doesn't grow the pool.
+	next := NewWithState(symbolTable, bytecode.Constants)
+	if err := next.Compile(parse(input.String())); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if got := len(next.Bytecode().Constants); got != len(values) {
+		t.Errorf("pool grew across compilations. want=%d, got=%d", len(values), got)
+	}
+}
+
+func TestPeepholeOptimization(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = [1]; x`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpArray, 1),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `let x = [1]; if (!!x) { 1 }`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpConstant, 0),
+				// 0003
+				code.MustMake(code.OpArray, 1),
+				// 0006
+				code.MustMake(code.OpDup),
+				// 0007
+				code.MustMake(code.OpSetGlobal, 0),
+				// 0010
+				code.MustMake(code.OpJumpNotTruthy, 19),
+				// 0013
+				code.MustMake(code.OpConstant, 0),
+				// 0016
+				code.MustMake(code.OpJump, 20),
+				// 0019
+				code.MustMake(code.OpNull),
+				// 0020
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { let b = a; b }; fn(a) { !!(a == 1) }; fn(a) { !!a }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpDup),
+					code.MustMake(code.OpSetLocal, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpEqual),
+					code.MustMake(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpBang),
+					code.MustMake(code.OpBang),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpClosure, 3, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `try { 1 } finally { 2 }`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpConstant, 0),
+				// 0003
+				code.MustMake(code.OpConstant, 1),
+				// 0006
+				code.MustMake(code.OpPop),
+				// 0007
+				code.MustMake(code.OpJump, 15),
+				// 0010
+				code.MustMake(code.OpConstant, 1),
+				// 0013
+				code.MustMake(code.OpPop),
+				// 0014
+				code.MustMake(code.OpThrow),
+				// 0015
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization(OptimizePeephole))
+
+	compiler := New(WithOptimization(OptimizePeephole))
+	if err := compiler.Compile(parse(`try { 1 } finally { 2 }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := code.HandlerTable{{Start: 0, End: 3, Target: 10, Finally: true}}
+	if handlers := compiler.Bytecode().Handlers; fmt.Sprint(handlers) != fmt.Sprint(expected) {
+		t.Errorf("wrong handlers. want=%v, got=%v", expected, handlers)
+	}
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn() { return 1; puts(2); let x = 3; }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `let f = fn(x) { x }; throw 1; f(2)`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpThrow),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `if (true) { 1 } else { 2 }`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `let a = [1]; 2; a; !a == [a, null]; "last"`,
+			expectedConstants: []any{1, "last"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpArray, 1),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Expressions that can fail or have effects stay.
+			input:             `let a = [1]; -a; a[0]; a.len(); "last"`,
+			expectedConstants: []any{1, 0, "len", "last"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpArray, 1),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpMinus),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpGetField, 2),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization(OptimizeDeadCode))
+}
+
+func TestInlining(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let add = fn(a, b) { a + b }; add(1, 2)`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpGetLocal, 1),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpSetGlobal, 2),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 2),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// The slots of the arguments are reused by the next inlined call.
+			input: `let f = fn(x) { if (x) { 1 } }; f(true); f(false)`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpJumpNotTruthy, 11),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpJump, 12),
+					code.MustMake(code.OpNull),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpJumpNotTruthy, 21),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpJump, 22),
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpJumpNotTruthy, 37),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpJump, 38),
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let g = fn(x) { x * 2 }; g(3) + 1 }`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpMul),
+					code.MustMake(code.OpReturnValue),
+				},
+				3,
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpClosure, 1, 0),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpDup),
+					code.MustMake(code.OpSetLocal, 1),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpMul),
+					code.MustMake(code.OpConstant, 3),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 4, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Calls in tail position replace the frame of their caller, as
+			// in the evaluator, so they are left as calls.
+			input: `fn() { let g = fn(x) { x * 2 }; g(3) }`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpMul),
+					code.MustMake(code.OpReturnValue),
+				},
+				3,
+				[]code.Instructions{
+					code.MustMake(code.OpClosure, 1, 0),
+					code.MustMake(code.OpDup),
+					code.MustMake(code.OpSetLocal, 0),
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 3, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Recursive functions are called.
+			input: `let f = fn(n) { f(n - 1) }; f(2)`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpSub),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// So are functions with free variables.
+			input: `fn(a) { let g = fn(b) { a + b }; g(1) }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetFree, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpClosure, 0, 1),
+					code.MustMake(code.OpDup),
+					code.MustMake(code.OpSetLocal, 1),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpTailCall, 1),
+					code.MustMake(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 2, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// And functions whose globals were redefined after them.
+			input: `let k = 1; let f = fn(x) { x + k }; let k = 2; f(1)`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpClosure, 1, 0),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpSetGlobal, 2),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpCall, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Calls with the wrong number of arguments fail when run.
+			input: `let f = fn(x) { return x; }; f(1, 2); f(3)`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+				2,
+				3,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpCall, 2),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization(OptimizeInline))
+}
+
+func TestInlineThreshold(t *testing.T) {
+	input := `let add = fn(a, b) { a + b }; add(1, 2)`
+	expectedConstants := []any{
+		[]code.Instructions{
+			code.MustMake(code.OpGetLocal, 0),
+			code.MustMake(code.OpGetLocal, 1),
+			code.MustMake(code.OpAdd),
+			code.MustMake(code.OpReturnValue),
+		},
+		1,
+		2,
+	}
+	called := []code.Instructions{
+		code.MustMake(code.OpClosure, 0, 0),
+		code.MustMake(code.OpDup),
+		code.MustMake(code.OpSetGlobal, 0),
+		code.MustMake(code.OpConstant, 1),
+		code.MustMake(code.OpConstant, 2),
+		code.MustMake(code.OpCall, 2),
+		code.MustMake(code.OpPop),
+	}
+
+	cost, ok := inlineCost(parse(input).Statements[0].(*ast.LetStatement).
+		Value.(*ast.FunctionLiteral), "add")
+	if !ok {
+		t.Fatalf("add not inlinable")
+	}
+
+	runCompilerTests(t, []compilerTestCase{
+		{input, expectedConstants, called},
+	}, WithOptimization(OptimizeInline), WithInlineThreshold(cost-1))
+
+	runCompilerTests(t, []compilerTestCase{
+		{input, expectedConstants, called},
+	}, WithOptimization(OptimizeDeadCode), WithInlineThreshold(cost))
+}
+
+func TestSpecialization(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let f = fn(n, m) {
+				if (n < 2) { return m; };
+				f(n - 1, m + 3) + f(n, 100000)
+			};
+			f(3, 0)`,
+			expectedConstants: []any{
+				2,
+				1,
+				3,
+				100000,
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal0),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpJumpNotLess, 12),
+					code.MustMake(code.OpGetLocal1),
+					code.MustMake(code.OpReturnValue),
+					code.MustMake(code.OpJump, 13),
+					code.MustMake(code.OpNull),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal0),
+					code.MustMake(code.OpSubConstant, 1),
+					code.MustMake(code.OpGetLocal1),
+					code.MustMake(code.OpAddConstant, 2),
+					code.MustMake(code.OpCall2),
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal0),
+					code.MustMake(code.OpConstant, 3),
+					code.MustMake(code.OpCall2),
+					code.MustMake(code.OpAdd),
+					code.MustMake(code.OpReturnValue),
+				},
+				0,
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 4, 0),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 5),
+				code.MustMake(code.OpCall2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let g = fn(a, b, c, d, e) {
+				if (a == b) { c } else { if (d != e) { e } }
+			};
+			g(1, 2, 3, 4, 5) > "a" + "b"`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.MustMake(code.OpGetLocal0),
+					code.MustMake(code.OpGetLocal1),
+					code.MustMake(code.OpJumpNotEqual, 9),
+					code.MustMake(code.OpGetLocal2),
+					code.MustMake(code.OpJump, 21),
+					code.MustMake(code.OpGetLocal3),
+					code.MustMake(code.OpGetLocal, 4),
+					code.MustMake(code.OpJumpEqual, 20),
+					code.MustMake(code.OpGetLocal, 4),
+					code.MustMake(code.OpJump, 21),
+					code.MustMake(code.OpNull),
+					code.MustMake(code.OpReturnValue),
+				},
+				1,
+				2,
+				3,
+				4,
+				5,
+				"ab",
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpClosure, 0, 0),
+				code.MustMake(code.OpDup),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpConstant, 5),
+				code.MustMake(code.OpCall, 5),
+				code.MustMake(code.OpConstant, 6),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization(OptimizeSpecialize))
+}
+
+func TestWideOperands(t *testing.T) {
+	name := func(i int) string { return fmt.Sprintf("x_%c%c", 'a'+i/26, 'a'+i%26) }
+	integers := func(n int) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprint(i)
+		}
+		return strings.Join(items, ", ")
+	}
+
+	var lets, names []string
+	for i := 0; i < 257; i++ {
+		lets = append(lets, fmt.Sprintf("let %s = %d;", name(i), i))
+		names = append(names, name(i))
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			fmt.Sprintf("fn() { %s %s + %s }", strings.Join(lets, " "), name(0), name(256)),
+			[]string{"OpWide OpSetLocal 256", "OpWide OpGetLocal 256"},
+		},
+		{
+			fmt.Sprintf("fn(f) { f(%s) + 1 }", integers(257)),
+			[]string{"OpWide OpCall 257"},
+		},
+		{
+			fmt.Sprintf("fn() { %s fn() { %s } }",
+				strings.Join(lets, " "), strings.Join(names, " + ")),
+			[]string{"OpWide OpGetFree 256", "OpWide OpClosure 257 257"},
+		},
+		{
+			fmt.Sprintf("[%s]; 65536", integers(65536)),
+			[]string{"OpWide OpArray 65536", "OpWide OpConstant 65536"},
+		},
+		{
+			fmt.Sprintf("fn(x) { if (x) { [%s] } else { 1 } }", integers(30000)),
+			[]string{"0000 OpGetLocal 0\n0002 OpWide OpJumpNotTruthy 90017\n",
+				"90011 OpWide OpJump 90020\n90017 OpConstant 1\n90020 OpReturnValue"},
+		},
+	}
+
+	for _, level := range []OptimizationLevel{OptimizeNone, OptimizePeephole} {
+		for _, tt := range tests {
+			compiler := New(WithOptimization(level))
+			if err := compiler.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			bytecode := compiler.Bytecode()
+			disassembled := bytecode.Instructions.String()
+			for _, constant := range bytecode.Constants {
+				if fn, ok := constant.(*object.CompiledFunction); ok {
+					disassembled += fn.Instructions.String()
+				}
+			}
+
+			for _, want := range tt.expected {
+				if !strings.Contains(disassembled, want) {
+					t.Errorf("level %d: %q not emitted", level, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCompilerWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`1; 2`, nil},
+		{"fn() {\n  return 1;\n  2;\n}", []string{"3:3: unreachable code"}},
+		{
+			"fn() { throw 1; 2 }; if (true) { return; let x = 1 }",
+			[]string{"1:17: unreachable code", "1:42: unreachable code"},
+		},
+		{"fn() { return 1 }; 1", nil},
+	}
+
+	for _, level := range []OptimizationLevel{OptimizeNone, OptimizeDeadCode} {
+		for _, tt := range tests {
+			compiler := New(WithOptimization(level))
+			if err := compiler.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			if fmt.Sprint(compiler.Warnings()) != fmt.Sprint(tt.expected) {
+				t.Errorf("%q: wrong warnings. want=%q, got=%q",
+					tt.input, tt.expected, compiler.Warnings())
+			}
+		}
+	}
+
+	// The names defined by dropped statements stay defined.
+	compiler := New(WithOptimization(OptimizeDeadCode))
+	err := compiler.Compile(parse(`fn() { if (true) { return 1; let x = 2 }; x }`))
+	if err != nil {
+		t.Errorf("compiler error: %s", err)
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		input    []code.Instructions
+		expected []code.Instructions
+	}{
+		{
+			input: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpTrue),
+				// 0001
+				code.MustMake(code.OpJumpNotTruthy, 7),
+				// 0004
+				code.MustMake(code.OpConstant, 0),
+				// 0007
+				code.MustMake(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpFalse),
+				// 0001
+				code.MustMake(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.MustMake(code.OpConstant, 0),
+				// 0007
+				code.MustMake(code.OpPop),
+				// 0008
+				code.MustMake(code.OpNull),
+			},
+			expected: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpJump, 7),
+				// 0003
+				code.MustMake(code.OpConstant, 0),
+				// 0006
+				code.MustMake(code.OpPop),
+				// 0007
+				code.MustMake(code.OpNull),
+			},
+		},
+		{
+			// Jumping into the middle of a sequence keeps it.
+			input: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpNull),
+				// 0001
+				code.MustMake(code.OpJumpNotNull, 5),
+				// 0004
+				code.MustMake(code.OpTrue),
+				// 0005
+				code.MustMake(code.OpJumpNotTruthy, 9),
+				// 0008
+				code.MustMake(code.OpNull),
+				// 0009
+				code.MustMake(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpJumpNotNull, 5),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpJumpNotTruthy, 9),
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// Removing the OpTrue and OpJumpNotTruthy leaves a jump to the
+			// next instruction, removed in turn.
+			input: []code.Instructions{
+				// 0000
+				code.MustMake(code.OpTrue),
+				// 0001
+				code.MustMake(code.OpJumpNotTruthy, 7),
+				// 0004
+				code.MustMake(code.OpJump, 7),
+				// 0007
+				code.MustMake(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		fn, err := ir.Lift(concatInstructions(tt.input), nil, nil)
+		if err != nil {
+			t.Fatalf("ir.Lift failed: %s", err)
+		}
+		peephole(fn)
+		ins, _, _ := ir.Lower(fn)
+
+		if err := testInstructions(tt.expected, ins); err != nil {
+			t.Errorf("testInstructions failed: %s", err)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	input := "let f = fn(a) {\n  -a / 2\n};\n1 +\n  f(3)"
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+
+	// The main program: OpClosure, OpSetGlobal, OpConstant, OpGetGlobal,
+	// OpConstant, OpCall, OpAdd, OpPop.
+	mainTests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{}},
+		{13, token.Position{}},
+		{16, pos(5, 4)},
+		{18, pos(4, 3)},
+		{19, token.Position{}},
+	}
+
+	for _, tt := range mainTests {
+		if got := bytecode.SourceMap.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("main: wrong position at %d. want=%s, got=%s",
+				tt.offset, tt.expected, got)
+		}
+	}
+
+	fn, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 is not a function. got=%T", bytecode.Constants[1])
+	}
+
+	// The function: OpGetLocal, OpMinus, OpConstant, OpDiv, OpReturnValue.
+	fnTests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{}},
+		{2, pos(2, 3)},
+		{3, token.Position{}},
+		{6, pos(2, 6)},
+		{7, token.Position{}},
+	}
+
+	for _, tt := range fnTests {
+		if got := fn.SourceMap.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("fn: wrong position at %d. want=%s, got=%s",
+				tt.offset, tt.expected, got)
+		}
+	}
+}
+
+func TestIR(t *testing.T) {
+	tests := []struct {
+		input    string
+		level    OptimizationLevel
+		expected string
+	}{
+		{
+			input: "fn(a) { if (a) { 10 } else { 20 } }",
+			level: OptimizeNone,
+			expected: `fn <anonymous> (constant 2, 1 params, 1 locals)
+b0:
+  OpGetLocal 0
+  OpJumpNotTruthy b2
+  -> b2 b1
+b1: <- b0
+  OpConstant 0
+  OpJump b3
+  -> b3
+b2: <- b0
+  OpConstant 1
+  -> b3
+b3: <- b1 b2
+  OpReturnValue
+fn <main> (0 params, 0 locals)
+b0:
+  OpClosure 2 0
+  OpPop
+`,
+		},
+		{
+			input: "let x = try { -true } catch (e) { let y = e; y };",
+			level: OptimizePeephole,
+			expected: `fn <main> (0 params, 0 locals)
+b0:
+  OpTrue
+  OpMinus                  ; 1:15
+  -> b1
+b1: <- b0
+  OpJump b3                ; -
+  -> b3
+b2:
+  OpDup
+  OpSetGlobal 1
+  OpDup
+  OpSetGlobal 2
+  -> b3
+b3: <- b1 b2
+  OpSetGlobal 0
+handler b0..b1 -> b2, depth 0
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New(WithOptimization(tt.level))
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var out strings.Builder
+		for _, fn := range compiler.IR() {
+			out.WriteString(fn.String())
+		}
+
+		if out.String() != tt.expected {
+			t.Errorf("IR wrong.\nwant=\n%s\ngot=\n%s", tt.expected, out.String())
+		}
+	}
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { x }`, "macro literals are only allowed in top-level let statements"},
+		{`quote(unquote(1))`, "unquote is only supported inside macros"},
+		{`quote(1, 2)`, "wrong number of arguments to quote. got=2, want=1"},
+		{`foobar`, "undefined variable foobar"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// Helpers
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase, options ...Option) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New(options...)
 		if err := compiler.Compile(program); err != nil {
 			t.Fatalf("compiler error: %s", err)
 		}
@@ -0,0 +1,46 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// constantKey identifies the value of a constant that is shared by every
+// literal with that value.
+type constantKey struct {
+	kind  object.ObjectType
+	value string
+}
+
+// internKey returns the key of constant, reporting whether constants like it
+// are shared. Integers and strings are shared by value. Compiled functions
+// are shared when they are identical, down to their source maps, so that
+// sharing them doesn't change the positions of errors.
+func internKey(constant object.Object) (constantKey, bool) {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		return constantKey{constant.Type(), strconv.FormatInt(constant.Value, 10)}, true
+
+	case *object.BigInt:
+		return constantKey{constant.Type(), constant.Value.String()}, true
+
+	case *object.String:
+		return constantKey{constant.Type(), constant.Value}, true
+
+	case *object.CompiledFunction:
+		value := fmt.Sprintf("%q %d %d %x %v %v",
+			constant.Name,
+			constant.NumLocals,
+			constant.NumParameters,
+			[]byte(constant.Instructions),
+			constant.SourceMap,
+			constant.Handlers,
+		)
+		return constantKey{constant.Type(), value}, true
+
+	default:
+		return constantKey{}, false
+	}
+}
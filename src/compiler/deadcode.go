@@ -0,0 +1,136 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// Warnings returns problems that don't stop the program from compiling, such
+// as unreachable code.
+func (c *Compiler) Warnings() []string {
+	return c.warnings
+}
+
+func (c *Compiler) warn(pos token.Position, format string, a ...any) {
+	msg := fmt.Sprintf("%s: %s", pos, fmt.Sprintf(format, a...))
+	c.warnings = append(c.warnings, msg)
+}
+
+// reachable returns the statements of a block or program up to the first
+// return or throw, warning about the ones after it. They are only dropped when
+// eliminating dead code, and the names they define stay defined, so that
+// dropping them doesn't turn uses elsewhere into compile errors.
+func (c *Compiler) reachable(statements []ast.Statement) []ast.Statement {
+	for i, s := range statements[:max(len(statements)-1, 0)] {
+		if !terminates(s) {
+			continue
+		}
+
+		c.warn(statementPos(statements[i+1]), "unreachable code")
+		if c.optimization < OptimizeDeadCode {
+			return statements
+		}
+
+		for _, dropped := range statements[i+1:] {
+			c.defineNames(dropped)
+		}
+		return statements[:i+1]
+	}
+
+	return statements
+}
+
+// unused reports whether s is an expression statement whose value is thrown
+// away and whose evaluation has no effect, so it can be skipped. The last
+// statement of a block or program is its value and is never skipped.
+func (c *Compiler) unused(s ast.Statement, last bool) bool {
+	exp, ok := s.(*ast.ExpressionStatement)
+	if !ok || last || c.optimization < OptimizeDeadCode {
+		return false
+	}
+
+	return c.pure(exp.Expression)
+}
+
+// pure reports whether evaluating node can't have an effect or fail. Calls,
+// operators that can fail on the wrong types, and function literals, whose
+// bodies might not compile, aren't pure.
+func (c *Compiler) pure(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.NullLiteral:
+		return true
+
+	case *ast.Identifier:
+		return c.symbolTable.defined(node.Value)
+
+	case *ast.PrefixExpression:
+		return node.Operator == "!" && c.pure(node.Right)
+
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "==", "!=", "??":
+			return c.pure(node.Left) && c.pure(node.Right)
+		default:
+			return false
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if !c.pure(el) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return false
+	}
+}
+
+// defineNames defines the names bound by the let statements and catch
+// clauses of s, outside of function literals.
+func (c *Compiler) defineNames(s ast.Statement) {
+	ast.Inspect(s, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.Define(node.Name.Value)
+		case *ast.TryExpression:
+			if node.Parameter != nil {
+				c.symbolTable.Define(node.Parameter.Value)
+			}
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	}, nil)
+}
+
+// terminates reports whether s never completes normally.
+func terminates(s ast.Statement) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.ExpressionStatement:
+		_, ok := s.Expression.(*ast.ThrowExpression)
+		return ok
+	default:
+		return false
+	}
+}
+
+func statementPos(s ast.Statement) token.Position {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token.Pos
+	case *ast.ReturnStatement:
+		return s.Token.Pos
+	case *ast.ExpressionStatement:
+		return s.Token.Pos
+	case *ast.BlockStatement:
+		return s.Token.Pos
+	default:
+		return token.Position{}
+	}
+}
@@ -0,0 +1,252 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
+)
+
+// protectedRegion is a try or catch block whose errors go to a handler. A
+// return inside the block runs the finally block first, and the inlined
+// finally code is left out of the region, so the region is a list of ranges
+// of blocks, each up to its end excluded.
+type protectedRegion struct {
+	start   *ir.Block // Start of the open range
+	ranges  [][2]*ir.Block
+	finally *ast.BlockStatement
+}
+
+func (r *protectedRegion) close(end *ir.Block) {
+	if end != r.start {
+		r.ranges = append(r.ranges, [2]*ir.Block{r.start, end})
+	}
+}
+
+// compileTry compiles
+//
+//	try { block } catch (e) { catch } finally { finally }
+//
+// into the block, then the catch block, which a handler of the block jumps
+// to, then the finally block. The finally block is compiled twice: once for
+// the normal path and once, ending with an OpThrow that rethrows the error,
+// for a handler of the try and catch blocks.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	depth := c.scopes[c.scopeIndex].stackDepth
+
+	c.protect(node.Finally)
+	if err := c.compileValueBlock(node.Block); err != nil {
+		return err
+	}
+	protected := c.unprotect()
+
+	end := &ir.Block{}
+	c.emitJump(code.OpJump, end)
+
+	if node.Catch != nil {
+		c.addHandlers(protected, depth, false)
+		c.scopes[c.scopeIndex].stackDepth = depth + 1
+
+		if node.Finally != nil {
+			c.protect(node.Finally)
+		}
+
+		c.storeSymbol(c.symbolTable.Define(node.Parameter.Value))
+
+		if err := c.compileValueBlock(node.Catch); err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			protected = c.unprotect()
+		}
+	}
+
+	c.startBlock(end)
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+	after := &ir.Block{}
+	c.emitJump(code.OpJump, after)
+
+	c.addHandlers(protected, depth, true)
+	c.scopes[c.scopeIndex].stackDepth = depth + 1
+
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpThrow)
+
+	c.startBlock(after)
+	c.scopes[c.scopeIndex].stackDepth = depth + 1
+
+	return nil
+}
+
+// compileValueBlock compiles b leaving the value of its last expression, or
+// null, on the stack.
+func (c *Compiler) compileValueBlock(b *ast.BlockStatement) error {
+	if err := c.Compile(b); err != nil {
+		return err
+	}
+
+	if len(b.Statements) > 0 && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// emitReturn emits an OpReturnValue, running the finally blocks of the
+// enclosing try expressions before it.
+func (c *Compiler) emitReturn() error {
+	scope := &c.scopes[c.scopeIndex]
+	protected := scope.protected
+
+	for i := len(protected) - 1; i >= 0; i-- {
+		protected[i].close(c.mark())
+		if protected[i].finally == nil {
+			continue
+		}
+
+		// The finally block is only protected by the enclosing regions.
+		scope.protected = append([]*protectedRegion(nil), protected[:i]...)
+		err := c.Compile(protected[i].finally)
+		scope = &c.scopes[c.scopeIndex]
+		scope.protected = protected
+		if err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpReturnValue)
+
+	start := c.mark()
+	for _, region := range protected {
+		region.start = start
+	}
+
+	return nil
+}
+
+func (c *Compiler) protect(finally *ast.BlockStatement) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.protected = append(scope.protected, &protectedRegion{
+		start:   c.mark(),
+		finally: finally,
+	})
+}
+
+func (c *Compiler) unprotect() *protectedRegion {
+	scope := &c.scopes[c.scopeIndex]
+
+	region := scope.protected[len(scope.protected)-1]
+	scope.protected = scope.protected[:len(scope.protected)-1]
+	region.close(c.mark())
+
+	return region
+}
+
+// addHandlers makes the errors raised in region jump to the next instruction,
+// with the stack unwound to depth.
+func (c *Compiler) addHandlers(region *protectedRegion, depth int, finally bool) {
+	target := c.mark()
+	fn := c.scopes[c.scopeIndex].fn
+
+	for _, r := range region.ranges {
+		fn.Handlers = append(fn.Handlers, ir.Handler{
+			Start:   r[0],
+			End:     r[1],
+			Target:  target,
+			Depth:   depth,
+			Finally: finally,
+		})
+	}
+}
+
+// maxStackDepth returns the most values the code of fn, once linked, has on
+// the stack above its locals. Every way of reaching a block leaves the stack
+// at the same depth, the one the first of them recorded.
+func maxStackDepth(fn *ir.Function) int {
+	depths := map[*ir.Block]int{}
+	if len(fn.Blocks) > 0 {
+		depths[fn.Blocks[0]] = 0
+	}
+	for _, h := range fn.Handlers {
+		depths[h.Target] = h.Depth + 1
+	}
+
+	max := 0
+	for _, block := range fn.Blocks {
+		depth, reachable := depths[block]
+		if !reachable {
+			continue
+		}
+
+		for _, ins := range block.Instructions {
+			depth += stackEffect(ins.Op, ins.Operands...)
+			if depth > max {
+				max = depth
+			}
+		}
+
+		for _, succ := range block.Succs {
+			if _, ok := depths[succ]; !ok {
+				depths[succ] = depth
+			}
+		}
+	}
+
+	return max
+}
+
+// stackEffect returns the change in stack size caused by executing op with
+// operands.
+func stackEffect(op code.Opcode, operands ...int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpDup,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCurrentClosure,
+		code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+		return 1
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+		code.OpIndex, code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal,
+		code.OpSetLocal, code.OpSetBuiltin, code.OpReturnValue, code.OpThrow,
+		code.OpCall1:
+		return -1
+
+	case code.OpMinus, code.OpBang, code.OpJump, code.OpJumpNull,
+		code.OpJumpNotNull, code.OpGetField, code.OpGetOptionalField,
+		code.OpReturn, code.OpAddConstant, code.OpSubConstant, code.OpCall0:
+		return 0
+
+	case code.OpJumpNotLess, code.OpJumpNotGreater, code.OpJumpNotEqual,
+		code.OpJumpEqual, code.OpCall2:
+		return -2
+
+	case code.OpCall3:
+		return -3
+
+	case code.OpCall, code.OpTailCall:
+		return -operands[0]
+
+	case code.OpClosure:
+		return 1 - operands[1]
+
+	case code.OpArray, code.OpHash:
+		return 1 - operands[0]
+
+	default:
+		panic(fmt.Sprintf("stackEffect: unexpected opcode %d", op))
+	}
+}
@@ -0,0 +1,218 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// DefaultInlineThreshold is the largest inlining cost of the functions whose
+// calls are inlined, unless set with WithInlineThreshold.
+const DefaultInlineThreshold = 16
+
+// WithInlineThreshold sets the largest inlining cost of the functions whose
+// calls are inlined.
+func WithInlineThreshold(cost int) Option {
+	return func(c *Compiler) {
+		c.inlineThreshold = cost
+	}
+}
+
+// inlineFunction is a function bound by a let statement whose calls can be
+// inlined.
+type inlineFunction struct {
+	literal *ast.FunctionLiteral
+
+	// The global symbols the names of the body resolved to when the
+	// function was compiled. Once one of them is redefined, the body would
+	// mean something else if compiled again, so it is no longer inlined.
+	globals map[string]Symbol
+}
+
+// inlineCost estimates how much inlining fn grows each of its call sites,
+// roughly in instructions: every node of the body costs 1, every parameter 1
+// for storing the argument and every call 2 more. ok is false when fn can't be
+// inlined: when it calls name, the name it is bound to, returns anywhere but
+// in its last statement, or contains function literals or try expressions.
+func inlineCost(fn *ast.FunctionLiteral, name string) (cost int, ok bool) {
+	cost = len(fn.Parameters)
+	ok = true
+
+	statements := fn.Body.Statements
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		cost++
+
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if node.Value == name || node.Value == "quote" || node.Value == "unquote" {
+				ok = false
+			}
+
+		case *ast.ReturnStatement:
+			if node != statements[len(statements)-1] {
+				ok = false
+			}
+
+		case *ast.CallExpression:
+			cost += 2
+
+		case *ast.FunctionLiteral, *ast.MacroLiteral, *ast.TryExpression:
+			ok = false
+		}
+
+		return ok
+	}, nil)
+
+	return cost, ok
+}
+
+// recordInlineFunction makes the calls of the function bound by node
+// inlinable, when it is small enough and has no free variables. Like
+// propagateConstant, it is only called for the lets that always run.
+func (c *Compiler) recordInlineFunction(node *ast.LetStatement) {
+	literal, ok := node.Value.(*ast.FunctionLiteral)
+	if !ok || !c.closedFunctions[literal] {
+		return
+	}
+
+	cost, ok := inlineCost(literal, node.Name.Value)
+	if !ok || cost > c.inlineThreshold {
+		return
+	}
+
+	symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+	if !ok {
+		return
+	}
+
+	globals := map[string]Symbol{}
+	root := c.globalSymbolTable()
+	ast.Inspect(literal.Body, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			if symbol, ok := root.store[ident.Value]; ok {
+				globals[ident.Value] = symbol
+			}
+		}
+		return true
+	}, nil)
+
+	c.symbolTable.defineFunction(symbol, &inlineFunction{
+		literal: literal,
+		globals: globals,
+	})
+}
+
+// compileInlineCall compiles a call of a function bound to an inlinable
+// function as the function body, with the arguments stored in slots of the
+// calling function, reporting whether it did. The source map records the
+// call around the instructions of the body, for stack traces to show a frame
+// for the function.
+//
+// Calls in tail position are left to replace the frame of their caller, as
+// in the evaluator, and the calls in tail position of the body are recorded
+// as such, for the frame of the function to go once they run.
+func (c *Compiler) compileInlineCall(node *ast.CallExpression) (bool, error) {
+	if c.optimization < OptimizeInline ||
+		c.scopes[c.scopeIndex].tailCalls[node] || c.inlinedTails[node] {
+		return false, nil
+	}
+
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return false, nil
+	}
+
+	fn, ok := c.symbolTable.function(ident.Value)
+	if !ok || len(node.Arguments) != len(fn.literal.Parameters) {
+		return false, nil
+	}
+
+	root := c.globalSymbolTable()
+	for name, symbol := range fn.globals {
+		if root.store[name] != symbol {
+			return false, nil
+		}
+	}
+
+	for _, arg := range node.Arguments {
+		if err := c.Compile(arg); err != nil {
+			return true, err
+		}
+	}
+
+	caller := c.symbolTable
+	c.symbolTable = newInlineSymbolTable(caller)
+	defer func() {
+		c.symbolTable.release()
+		c.symbolTable = caller
+	}()
+
+	parameters := make([]Symbol, len(fn.literal.Parameters))
+	for i, parameter := range fn.literal.Parameters {
+		parameters[i] = c.symbolTable.Define(parameter.Value)
+	}
+
+	for i := len(parameters) - 1; i >= 0; i-- {
+		c.storeSymbol(parameters[i])
+	}
+
+	name := fn.literal.Name
+	if name == "" {
+		name = object.AnonymousFunction
+	}
+
+	outer, outerTails := c.inlined, c.inlinedTails
+	c.inlined = &code.InlinedCall{
+		Function: name,
+		NumArgs:  len(fn.literal.Parameters),
+		Pos:      node.Token.Pos,
+		Outer:    outer,
+	}
+	c.inlinedTails = ast.TailCalls(fn.literal.Body)
+	defer func() {
+		c.inlined, c.inlinedTails = outer, outerTails
+	}()
+
+	return true, c.compileInlineBody(fn.literal.Body)
+}
+
+// compileInlineBody compiles the body of an inlined function, leaving the
+// value the function returns on the stack.
+func (c *Compiler) compileInlineBody(body *ast.BlockStatement) error {
+	statements := body.Statements
+
+	if n := len(statements); n > 0 {
+		if ret, ok := statements[n-1].(*ast.ReturnStatement); ok {
+			if err := c.compileStatements(statements[:n-1], true); err != nil {
+				return err
+			}
+
+			if ret.ReturnValue == nil {
+				c.emit(code.OpNull)
+				return nil
+			}
+			return c.Compile(ret.ReturnValue)
+		}
+	}
+
+	if err := c.compileStatements(statements, true); err != nil {
+		return err
+	}
+
+	if len(statements) > 0 && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+func (c *Compiler) globalSymbolTable() *SymbolTable {
+	table := c.symbolTable
+	for table.Outer != nil {
+		table = table.Outer
+	}
+
+	return table
+}
@@ -0,0 +1,241 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// OptimizationLevel selects the optimizations the compiler makes. Each level
+// includes the ones below it.
+type OptimizationLevel int
+
+const (
+	// OptimizeNone compiles every expression as written.
+	OptimizeNone OptimizationLevel = iota
+
+	// OptimizeConstants computes the expressions whose value is known at
+	// compile time, uses the value of top-level lets bound to such
+	// expressions in place of loading them, and compiles only the taken
+	// branch of ifs with a known condition.
+	OptimizeConstants
+
+	// OptimizePeephole also rewrites wasteful sequences of the emitted
+	// instructions.
+	OptimizePeephole
+
+	// OptimizeDeadCode also drops the statements after a return or throw,
+	// and the expression statements whose value is unused and whose
+	// evaluation has no effect.
+	OptimizeDeadCode
+
+	// OptimizeInline also inlines the calls of small functions bound by
+	// lets, see WithInlineThreshold.
+	OptimizeInline
+
+	// OptimizeSpecialize also replaces common instruction sequences with
+	// specialized instructions, see specialize.
+	OptimizeSpecialize
+)
+
+// Option configures a Compiler.
+type Option func(*Compiler)
+
+// WithOptimization sets the optimization level, OptimizeNone by default.
+func WithOptimization(level OptimizationLevel) Option {
+	return func(c *Compiler) {
+		c.optimization = level
+	}
+}
+
+// fold returns the value of node when it is known at compile time. Nodes
+// whose evaluation raises an error, such as 1 / 0, or depends on run time
+// settings, such as an overflowing 1 + 9223372036854775807, are not folded,
+// so that they fail, or not, when run.
+func (c *Compiler) fold(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewInteger(node.Big), true
+		}
+		return &object.Integer{Value: node.Value}, true
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+
+	case *ast.NullLiteral:
+		return &object.Null{}, true
+
+	case *ast.Identifier:
+		return c.symbolTable.constant(node.Value)
+
+	case *ast.PrefixExpression:
+		right, ok := c.fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+
+		return foldPrefix(node.Operator, right)
+
+	case *ast.InfixExpression:
+		left, ok := c.fold(node.Left)
+		if !ok {
+			return nil, false
+		}
+
+		right, ok := c.fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+
+		return foldInfix(node.Operator, left, right)
+
+	default:
+		return nil, false
+	}
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "!":
+		return &object.Boolean{Value: !isTruthy(right)}, true
+
+	case "-":
+		if right.Type() != object.INTEGER_OBJ {
+			return nil, false
+		}
+
+		return object.NegateInteger(right)
+
+	default:
+		return nil, false
+	}
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	integers := left.Type() == object.INTEGER_OBJ &&
+		right.Type() == object.INTEGER_OBJ
+
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ &&
+			operator == "+" {
+			return &object.String{
+				Value: left.(*object.String).Value + right.(*object.String).Value,
+			}, true
+		}
+
+		if !integers || (operator == "/" || operator == "%") && object.IsZero(right) {
+			return nil, false
+		}
+
+		return object.IntegerOperation(operator, left, right)
+
+	case "<", ">":
+		if !integers {
+			return nil, false
+		}
+
+		comparison := object.CompareIntegers(left, right)
+		if operator == "<" {
+			return &object.Boolean{Value: comparison < 0}, true
+		}
+		return &object.Boolean{Value: comparison > 0}, true
+
+	case "==":
+		return &object.Boolean{Value: object.Equal(left, right)}, true
+
+	case "!=":
+		return &object.Boolean{Value: !object.Equal(left, right)}, true
+
+	default:
+		return nil, false
+	}
+}
+
+// isTruthy reports whether value counts as true in a condition.
+func isTruthy(value object.Object) bool {
+	switch value := value.(type) {
+	case *object.Boolean:
+		return value.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// emitValue emits the instruction that pushes a folded value.
+func (c *Compiler) emitValue(value object.Object) {
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *object.Null:
+		c.emit(code.OpNull)
+
+	default:
+		c.emit(code.OpConstant, c.addConstant(value))
+	}
+}
+
+// compileKnownIf compiles the branch of node that runs when its condition is
+// known at compile time, reporting whether it was.
+func (c *Compiler) compileKnownIf(node *ast.IfExpression) (bool, error) {
+	condition, ok := c.fold(node.Condition)
+	if !ok {
+		return false, nil
+	}
+
+	branch := node.Alternative
+	if isTruthy(condition) {
+		branch = node.Consequence
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return true, nil
+	}
+
+	return true, c.compileValueBlock(branch)
+}
+
+// propagateConstant records the value of the name bound by a top-level let
+// statement, when it is known at compile time, for the uses of the name to
+// be folded. A let in a block might not run, so only top-level ones count.
+func (c *Compiler) propagateConstant(node *ast.LetStatement) {
+	value, ok := c.fold(node.Value)
+	if !ok {
+		return
+	}
+
+	symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+	if ok && symbol.Scope == GlobalScope {
+		c.symbolTable.defineConstant(symbol, value)
+	}
+}
+
+// finish runs the peephole and specialization passes over the IR of a
+// function or the main program, when the optimization level calls for them,
+// and lowers it to bytecode.
+func (c *Compiler) finish(fn *ir.Function) (code.Instructions, code.SourceMap, code.HandlerTable) {
+	fn.Simplify()
+
+	if c.optimization >= OptimizePeephole {
+		peephole(fn)
+	}
+	if c.optimization >= OptimizeSpecialize {
+		c.specialize(fn)
+	}
+
+	fn.Link()
+	return ir.Lower(fn)
+}
@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
+)
+
+// peephole rewrites wasteful instruction sequences of a function or the main
+// program until there is none left:
+//
+//	OpJump to the next instruction      (removed)
+//	OpTrue; OpJumpNotTruthy             (removed)
+//	OpFalse or OpNull; OpJumpNotTruthy  OpJump
+//	OpBang; OpBang                      (removed, when only truthiness counts)
+//	OpSetGlobal x; OpGetGlobal x        OpDup; OpSetGlobal x
+//	OpSetLocal x; OpGetLocal x          OpDup; OpSetLocal x
+//
+// A sequence is only rewritten within a block, so nothing jumps into its
+// middle. A rewritten instruction takes the location, the position and
+// inlined calls, of the one it replaces.
+func peephole(fn *ir.Function) {
+	for {
+		fn.Simplify()
+
+		changed := false
+		for i, b := range fn.Blocks {
+			var next *ir.Block
+			if i+1 < len(fn.Blocks) {
+				next = fn.Blocks[i+1]
+			}
+
+			if rewrite(b, next) {
+				changed = true
+			}
+		}
+
+		if !changed {
+			return
+		}
+	}
+}
+
+// rewrite rewrites the sequences of b, laid out before the block next,
+// reporting whether it did.
+func rewrite(b *ir.Block, next *ir.Block) bool {
+	in := b.Instructions
+	var out []ir.Instruction
+
+	// Whether the last instruction of out is the one before the current,
+	// unchanged.
+	previousKept := false
+
+	for i := 0; i < len(in); i++ {
+		current := in[i]
+
+		var following ir.Instruction
+		hasNext := i+1 < len(in)
+		if hasNext {
+			following = in[i+1]
+		}
+
+		switch {
+		case current.Op == code.OpJump && current.Target == next:
+			previousKept = false
+			continue
+
+		case current.Op == code.OpTrue && hasNext && following.Op == code.OpJumpNotTruthy:
+			i++
+			previousKept = false
+			continue
+
+		case (current.Op == code.OpFalse || current.Op == code.OpNull) &&
+			hasNext && following.Op == code.OpJumpNotTruthy:
+			jump := current
+			jump.Op, jump.Target = code.OpJump, following.Target
+			out = append(out, jump)
+			i++
+			previousKept = false
+			continue
+
+		case current.Op == code.OpBang && hasNext && following.Op == code.OpBang:
+			jumps := i+2 < len(in) && in[i+2].Op == code.OpJumpNotTruthy
+			boolean := previousKept && isBoolean(out[len(out)-1].Op)
+
+			if jumps || boolean {
+				i++
+				previousKept = false
+				continue
+			}
+
+		case (current.Op == code.OpSetGlobal && hasNext && following.Op == code.OpGetGlobal ||
+			current.Op == code.OpSetLocal && hasNext && following.Op == code.OpGetLocal) &&
+			current.Operands[0] == following.Operands[0]:
+			dup, set := current, following
+			dup.Op, dup.Operands = code.OpDup, nil
+			set.Op = current.Op
+			out = append(out, dup, set)
+			i++
+			previousKept = false
+			continue
+		}
+
+		out = append(out, current)
+		previousKept = true
+	}
+
+	if len(out) == len(in) && sameOps(out, in) {
+		return false
+	}
+
+	b.Instructions = out
+	return true
+}
+
+func sameOps(a, b []ir.Instruction) bool {
+	for i := range a {
+		if a[i].Op != b[i].Op {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isBoolean reports whether op always pushes a boolean.
+func isBoolean(op code.Opcode) bool {
+	switch op {
+	case code.OpTrue, code.OpFalse, code.OpBang,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/ir"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// getLocals and calls are the specialized forms of OpGetLocal and OpCall,
+// by operand.
+var (
+	getLocals = []code.Opcode{
+		code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3,
+	}
+	calls = []code.Opcode{code.OpCall0, code.OpCall1, code.OpCall2, code.OpCall3}
+)
+
+// compareJumps are the compare-and-jump instructions that fuse a comparison
+// with the OpJumpNotTruthy after it.
+var compareJumps = map[code.Opcode]code.Opcode{
+	code.OpLessThan:    code.OpJumpNotLess,
+	code.OpGreaterThan: code.OpJumpNotGreater,
+	code.OpEqual:       code.OpJumpNotEqual,
+	code.OpNotEqual:    code.OpJumpEqual,
+}
+
+// specialize replaces the instructions of a function or the main program
+// with the specialized instructions that do their work in fewer steps:
+//
+//	OpGetLocal 0 to 3                OpGetLocal0 to OpGetLocal3
+//	OpConstant k; OpAdd              OpAddConstant k  (k an integer)
+//	OpConstant k; OpSub              OpSubConstant k  (k an integer)
+//	OpLessThan; OpJumpNotTruthy t    OpJumpNotLess t
+//	OpGreaterThan; OpJumpNotTruthy t OpJumpNotGreater t
+//	OpEqual; OpJumpNotTruthy t       OpJumpNotEqual t
+//	OpNotEqual; OpJumpNotTruthy t    OpJumpEqual t
+//	OpCall 0 to 3                    OpCall0 to OpCall3
+//
+// A fused instruction takes the location of the instruction of the sequence
+// that can fail, for runtime errors to point at its source. As in the
+// peephole pass, a sequence is only fused within a block.
+func (c *Compiler) specialize(fn *ir.Function) {
+	for _, b := range fn.Blocks {
+		in := b.Instructions
+		var out []ir.Instruction
+
+		for i := 0; i < len(in); i++ {
+			current := in[i]
+
+			var next ir.Instruction
+			hasNext := i+1 < len(in)
+			if hasNext {
+				next = in[i+1]
+			}
+
+			jump, compares := compareJumps[current.Op]
+
+			switch {
+			case current.Op == code.OpGetLocal && current.Operands[0] < len(getLocals):
+				current.Op, current.Operands = getLocals[current.Operands[0]], nil
+
+			case current.Op == code.OpCall && current.Operands[0] < len(calls):
+				current.Op, current.Operands = calls[current.Operands[0]], nil
+
+			case current.Op == code.OpConstant && hasNext &&
+				(next.Op == code.OpAdd || next.Op == code.OpSub) &&
+				c.constants[current.Operands[0]].Type() == object.INTEGER_OBJ:
+				op := code.OpAddConstant
+				if next.Op == code.OpSub {
+					op = code.OpSubConstant
+				}
+
+				next.Op, next.Operands = op, current.Operands
+				current = next
+				i++
+
+			case compares && hasNext && next.Op == code.OpJumpNotTruthy:
+				current.Op, current.Target = jump, next.Target
+				i++
+			}
+
+			out = append(out, current)
+		}
+
+		b.Instructions = out
+	}
+}
@@ -1,5 +1,7 @@
 package compiler
 
+import "github.com/ZeroBl21/go-monkey/src/object"
+
 type SymbolScope string
 
 const (
@@ -7,6 +9,10 @@ const (
 	LocalScope   SymbolScope = "LOCAL"
 	BuiltinScope SymbolScope = "BUILTIN"
 	FreeScope    SymbolScope = "FREE"
+
+	// FunctionScope is the scope of the name a function literal is bound
+	// to, inside the literal.
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -21,12 +27,27 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 
+	// Values of the global symbols bound to constants, by index.
+	constants map[int]object.Object
+	// Functions whose calls can be inlined, by the index of the symbol
+	// bound to them.
+	functions map[int]*inlineFunction
+
+	// The table of an inlined function body defines its names in the slots
+	// of frame, the table of the function or program it is inlined into,
+	// and gives them back once the body is compiled.
+	frame *SymbolTable
+	slots []Symbol
+	spare []Symbol
+
 	FreeSymbols []Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{
 		store:       map[string]Symbol{},
+		constants:   map[int]object.Object{},
+		functions:   map[int]*inlineFunction{},
 		FreeSymbols: []Symbol{},
 	}
 }
@@ -39,6 +60,16 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
+	if s.frame != nil {
+		symbol := s.frame.allocate()
+		symbol.Name = name
+
+		s.store[name] = symbol
+		s.slots = append(s.slots, symbol)
+
+		return symbol
+	}
+
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
@@ -52,6 +83,47 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return symbol
 }
 
+// newInlineSymbolTable returns the table of a function body inlined into the
+// function or program of caller. Names not defined by the body resolve to
+// globals and builtins, as in a function without free variables.
+func newInlineSymbolTable(caller *SymbolTable) *SymbolTable {
+	frame := caller
+	if caller.frame != nil {
+		frame = caller.frame
+	}
+
+	globals := caller
+	for globals.Outer != nil {
+		globals = globals.Outer
+	}
+
+	s := NewEnclosedSymbolTable(globals)
+	s.frame = frame
+
+	return s
+}
+
+// allocate reserves a slot for an inlined function body, reusing the ones
+// given back by the bodies inlined before.
+func (s *SymbolTable) allocate() Symbol {
+	if n := len(s.spare); n > 0 {
+		symbol := s.spare[n-1]
+		s.spare = s.spare[:n-1]
+		return symbol
+	}
+
+	symbol := s.Define("")
+	delete(s.store, "")
+
+	return symbol
+}
+
+// release gives the slots of an inlined function body back to its frame.
+func (s *SymbolTable) release() {
+	s.frame.spare = append(s.frame.spare, s.slots...)
+	s.slots = nil
+}
+
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
@@ -59,6 +131,16 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineFunctionName defines name as the function whose scope s is, for the
+// function to refer to itself without capturing the variable it is bound to,
+// which only holds it once it is created.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+
+	return symbol
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -91,3 +173,66 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 
 	return symbol, ok
 }
+
+// defineConstant records that the global symbol is bound to value for good.
+func (s *SymbolTable) defineConstant(symbol Symbol, value object.Object) {
+	s.constants[symbol.Index] = value
+}
+
+// constant returns the value of the global symbol name when it is bound to a
+// constant. Unlike Resolve, it doesn't define free symbols.
+func (s *SymbolTable) constant(name string) (object.Object, bool) {
+	for table := s; table != nil; table = table.Outer {
+		symbol, ok := table.store[name]
+		if !ok {
+			continue
+		}
+
+		if symbol.Scope != GlobalScope {
+			return nil, false
+		}
+
+		value, ok := table.constants[symbol.Index]
+		return value, ok
+	}
+
+	return nil, false
+}
+
+// defined reports whether name resolves. Unlike Resolve, it doesn't define
+// free symbols.
+func (s *SymbolTable) defined(name string) bool {
+	for table := s; table != nil; table = table.Outer {
+		if _, ok := table.store[name]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defineFunction records that the symbol is bound to a function whose calls
+// can be inlined.
+func (s *SymbolTable) defineFunction(symbol Symbol, fn *inlineFunction) {
+	s.functions[symbol.Index] = fn
+}
+
+// function returns the inlinable function bound to the global or local
+// symbol name.
+func (s *SymbolTable) function(name string) (*inlineFunction, bool) {
+	for table := s; table != nil; table = table.Outer {
+		symbol, ok := table.store[name]
+		if !ok {
+			continue
+		}
+
+		if symbol.Scope != GlobalScope && symbol.Scope != LocalScope {
+			return nil, false
+		}
+
+		fn, ok := table.functions[symbol.Index]
+		return fn, ok
+	}
+
+	return nil, false
+}
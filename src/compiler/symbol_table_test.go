@@ -294,3 +294,39 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+	local.Define("a")
+	local.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := local.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v",
+			expected.Name, expected, result)
+	}
+
+	nested := NewEnclosedSymbolTable(local)
+	free := Symbol{Name: "a", Scope: FreeScope, Index: 0}
+
+	result, ok = nested.Resolve(free.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", free.Name)
+	}
+
+	if result != free {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", free.Name, free, result)
+	}
+
+	if nested.FreeSymbols[0] != expected {
+		t.Errorf("wrong free symbol. want=%+v, got=%+v",
+			expected, nested.FreeSymbols[0])
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/token"
 )
 
 var (
@@ -47,6 +48,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// Literals
 
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewInteger(node.Big)
+		}
 		return &object.Integer{Value: node.Value}
 
 	case *ast.StringLiteral:
@@ -55,10 +59,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.NullLiteral:
+		return NULL
+
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{
+			Name:       node.Name,
 			Parameters: params,
 			Body:       body,
 			Env:        env,
@@ -80,148 +88,289 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.ThrowExpression:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		err := newError(node.Token.Pos, "uncaught exception: %s", val.Inspect())
+		err.Value = val
+		return err
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node, right, env)
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "??" {
+			return evalCoalesceExpression(left, node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node, left, right, env)
 
-	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression:
+		result, _ := evalChain(node.(ast.Expression), env)
+		return result
+	}
+
+	return nil
+}
+
+// evalChain evaluates a chain of call, index and member expressions. When an
+// optional link (`?.` or `?[`) meets null, the rest of the chain is skipped,
+// the chain evaluates to null and shortCircuited is reported.
+func evalChain(
+	node ast.Expression,
+	env *object.Environment,
+) (result object.Object, shortCircuited bool) {
+	switch node := node.(type) {
 
-		return applyFunction(function, args)
+	case *ast.CallExpression:
+		return evalCall(node, env, false)
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
+		left, short := evalChainOperand(node.Left, env)
+		if short || isError(left) {
+			return left, short
+		}
+		if node.Optional && left == NULL {
+			return NULL, true
 		}
 		index := Eval(node.Index, env)
 		if isError(index) {
-			return index
+			return index, false
+		}
+		return evalIndexExpression(node, left, index), false
+
+	case *ast.MemberExpression:
+		obj, short := evalChainOperand(node.Object, env)
+		if short || isError(obj) {
+			return obj, short
+		}
+		if node.Optional && obj == NULL {
+			return NULL, true
 		}
-		return evalIndexExpression(left, index)
+		return evalMemberExpression(node, obj), false
 	}
 
-	return nil
+	return Eval(node, env), false
+}
+
+// evalCall evaluates a call link of a chain. A call in tail position of a
+// function body is returned as a *tailCall when the callee is a function.
+func evalCall(
+	node *ast.CallExpression,
+	env *object.Environment,
+	tail bool,
+) (result object.Object, shortCircuited bool) {
+	if isCallTo(node, "quote") {
+		if len(node.Arguments) != 1 {
+			return newError(node.Token.Pos, "wrong number of arguments to quote. got=%d, want=1",
+				len(node.Arguments)), false
+		}
+		return quote(node.Arguments[0], env), false
+	}
+
+	function, short := evalChainOperand(node.Function, env)
+	if short || isError(function) {
+		return function, short
+	}
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0], false
+	}
+
+	if fn, ok := function.(*object.Function); ok && tail &&
+		len(args) == len(fn.Parameters) {
+		return &tailCall{fn: fn, args: args}, false
+	}
+
+	return applyFunction(function, args, env, node.Token.Pos), false
+}
+
+// evalChainOperand evaluates the left-hand side of a chain link, continuing
+// the same chain when the operand is itself a link.
+func evalChainOperand(
+	node ast.Expression,
+	env *object.Environment,
+) (object.Object, bool) {
+	switch node.(type) {
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression:
+		return evalChain(node, env)
+	default:
+		return Eval(node, env), false
+	}
 }
 
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := map[object.HashKey]object.HashPair{}
+	hash := object.NewHash(len(node.Pairs))
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(node.Token.Pos, "unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(key) {
-			return key
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
-			Key:   key,
-			Value: value,
-		}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{
-		Pairs: pairs,
-	}
+	return hash
 }
 
-func evalIndexExpression(left, index object.Object) object.Object {
+func evalIndexExpression(
+	node *ast.IndexExpression,
+	left, index object.Object,
+) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
-		return evalHashIndexExpression(left, index)
+		return evalHashIndexExpression(node, left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(node.Token.Pos, "index operator not supported: %s", left.Type())
 	}
 }
 
-func evalHashIndexExpression(hash, index object.Object) object.Object {
+func evalHashIndexExpression(
+	node *ast.IndexExpression,
+	hash, index object.Object,
+) object.Object {
 	hashObject := hash.(*object.Hash)
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(node.Token.Pos, "unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
 	max := int64(len(arrayObject.Elements) - 1)
 
-	if idx < 0 || idx > max {
+	// A BigInt index is always out of range.
+	integer, ok := index.(*object.Integer)
+	if !ok || integer.Value < 0 || integer.Value > max {
 		return NULL
 	}
 
-	return arrayObject.Elements[idx]
+	return arrayObject.Elements[integer.Value]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func evalMemberExpression(
+	node *ast.MemberExpression,
+	obj object.Object,
+) object.Object {
+	name := node.Property.Value
+
+	member, ok := object.GetMember(obj, name)
+	if !ok {
+		if node.Optional {
+			return NULL
+		}
+		return newError(node.Token.Pos, "no field or method %q on %s", name, obj.Type())
+	}
+
+	return member
+}
+
+// applyFunction calls fn with args. The call is made at pos by the code
+// running in env.
+func applyFunction(
+	fn object.Object,
+	args []object.Object,
+	env *object.Environment,
+	pos token.Position,
+) object.Object {
 	switch function := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(function, args)
-		evaluated := Eval(function.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) != len(function.Parameters) {
+			return newError(pos, "wrong number of arguments: want=%d, got=%d",
+				len(function.Parameters), len(args))
+		}
 
-	case *object.Builtin:
-		if result := function.Fn(args...); result != nil {
-			return result
+		// The calls in tail position are made here, in place of the call
+		// that returned them, rather than nested in it.
+		for {
+			extendedEnv := extendFunctionEnv(function, args, env, pos)
+			evaluated := evalTailBlock(function.Body, extendedEnv, true)
+			if err, ok := evaluated.(*object.Error); ok && err.Trace == nil {
+				err.Trace = extendedEnv.StackTrace(err.Pos)
+			}
+
+			call, ok := unwrapReturnValue(evaluated).(*tailCall)
+			if !ok {
+				return unwrapReturnValue(evaluated)
+			}
+			function, args = call.fn, call.args
 		}
-		return NULL
+
+	case *object.Builtin:
+		return builtinResult(function.Fn(args...), pos)
+
+	case *object.BoundMethod:
+		return builtinResult(function.Call(args...), pos)
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(pos, "not a function: %s", fn.Type())
+	}
+}
+
+// builtinResult returns the result of a call of a builtin function or method
+// made at pos, positioning its errors at the call.
+func builtinResult(result object.Object, pos token.Position) object.Object {
+	switch result := result.(type) {
+	case nil:
+		return NULL
+	case *object.Error:
+		if !result.Pos.IsValid() {
+			result.Pos = pos
+		}
 	}
+
+	return result
 }
 
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
+	caller *object.Environment,
+	pos token.Position,
 ) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewCallEnvironment(fn.Env, caller, fn.Name, len(args), pos)
 
 	for paramID, param := range fn.Parameters {
 		env.Set(param.Value, args[paramID])
@@ -267,7 +416,7 @@ func evalIdentifier(
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError(node.Token.Pos, "identifier not found: "+node.Value)
 }
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
@@ -280,6 +429,9 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		case *object.ReturnValue:
 			return result.Value
 		case *object.Error:
+			if result.Trace == nil {
+				result.Trace = env.StackTrace(result.Pos)
+			}
 			return result
 		}
 	}
@@ -311,19 +463,65 @@ func evalIfExpression(
 	node *ast.IfExpression,
 	env *object.Environment,
 ) object.Object {
+	branch, result := ifBranch(node, env)
+	if branch == nil {
+		return result
+	}
+
+	return Eval(branch, env)
+}
+
+// ifBranch evaluates the condition of node and returns the branch to
+// evaluate, or the value of node when there is none.
+func ifBranch(
+	node *ast.IfExpression,
+	env *object.Environment,
+) (*ast.BlockStatement, object.Object) {
 	condition := Eval(node.Condition, env)
 	if isError(condition) {
-		return condition
+		return nil, condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(node.Consequence, env)
+		return node.Consequence, nil
+	}
+	if node.Alternative != nil && node.Alternative.Statements != nil {
+		return node.Alternative, nil
+	}
+
+	return nil, NULL
+}
+
+// evalTryExpression evaluates the try block, then the catch block if the try
+// block raised an error, and finally the finally block. An error or return
+// from the finally block replaces the result of the other blocks.
+func evalTryExpression(
+	node *ast.TryExpression,
+	env *object.Environment,
+) object.Object {
+	result := Eval(node.Block, env)
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		if err.Trace == nil {
+			err.Trace = env.StackTrace(err.Pos)
+		}
+		env.Set(node.Parameter.Value, err.Caught())
+		result = Eval(node.Catch, env)
+	}
+
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		if isError(finally) || finally != nil &&
+			finally.Type() == object.RETURN_VALUE_OBJ {
+			return finally
+		}
 	}
-	if node.Alternative.Statements != nil {
-		return Eval(node.Alternative, env)
+
+	if result == nil {
+		return NULL
 	}
 
-	return NULL
+	return result
 }
 
 func isTruthy(obj object.Object) bool {
@@ -340,20 +538,24 @@ func isTruthy(obj object.Object) bool {
 }
 
 func evalInfixExpression(
-	operator string,
+	node *ast.InfixExpression,
 	left, right object.Object,
+	env *object.Environment,
 ) object.Object {
+	operator := node.Operator
+
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(node, left, right, env)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(node, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError(
+			node.Token.Pos,
 			"type mismatch: %s %s %s",
 			left.Type(),
 			operator,
@@ -361,6 +563,7 @@ func evalInfixExpression(
 		)
 	default:
 		return newError(
+			node.Token.Pos,
 			"unknown operator: %s %s %s",
 			left.Type(),
 			operator,
@@ -369,10 +572,25 @@ func evalInfixExpression(
 	}
 }
 
+// evalCoalesceExpression only evaluates the right side of `??` when the left
+// side is null.
+func evalCoalesceExpression(
+	left object.Object,
+	right ast.Expression,
+	env *object.Environment,
+) object.Object {
+	if left != NULL {
+		return left
+	}
+
+	return Eval(right, env)
+}
+
 func evalStringInfixExpression(
-	operator string,
+	node *ast.InfixExpression,
 	left, right object.Object,
 ) object.Object {
+	operator := node.Operator
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
@@ -386,6 +604,7 @@ func evalStringInfixExpression(
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError(
+			node.Token.Pos,
 			"unknown operator: %s %s %s",
 			left.Type(),
 			operator,
@@ -395,33 +614,38 @@ func evalStringInfixExpression(
 }
 
 func evalIntegerInfixExpression(
-	operator string,
+	node *ast.InfixExpression,
 	left, right object.Object,
+	env *object.Environment,
 ) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	operator := node.Operator
 
 	switch operator {
 	// Additive & Multiplicative
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/", "%":
+		if (operator == "/" || operator == "%") && object.IsZero(right) {
+			return newError(node.Token.Pos, "division by zero")
+		}
+
+		result, fits := object.IntegerOperation(operator, left, right)
+		if !fits && env.CheckedArithmetic() {
+			return newError(node.Token.Pos, "integer overflow: %s %s %s",
+				left.Inspect(), operator, right.Inspect())
+		}
+
+		return result
 	// Relational
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
 	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	default:
 		return newError(
+			node.Token.Pos,
 			"unknown operator: %s %s %s",
 			left.Type(),
 			operator,
@@ -430,14 +654,20 @@ func evalIntegerInfixExpression(
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(
+	node *ast.PrefixExpression,
+	right object.Object,
+	env *object.Environment,
+) object.Object {
+	operator := node.Operator
+
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusOperatorExpression(right)
+		return evalMinusOperatorExpression(node, right, env)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(node.Token.Pos, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -454,15 +684,22 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusOperatorExpression(right object.Object) object.Object {
+func evalMinusOperatorExpression(
+	node *ast.PrefixExpression,
+	right object.Object,
+	env *object.Environment,
+) object.Object {
 	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		return newError(node.Token.Pos, "unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{
-		Value: -value,
+	result, fits := object.NegateInteger(right)
+	if !fits && env.CheckedArithmetic() {
+		return newError(node.Token.Pos,
+			"integer overflow: -(%s)", right.Inspect())
 	}
+
+	return result
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -472,9 +709,11 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func newError(format string, a ...any) *object.Error {
+// newError returns an error raised by the code at pos.
+func newError(pos token.Position, format string, a ...any) *object.Error {
 	return &object.Error{
 		Message: fmt.Sprintf(format, a...),
+		Pos:     pos,
 	}
 }
 
@@ -3,6 +3,7 @@ package evaluator
 import (
 	"testing"
 
+	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/lexer"
 	"github.com/ZeroBl21/go-monkey/src/object"
 	"github.com/ZeroBl21/go-monkey/src/parser"
@@ -27,6 +28,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 * 7 % 4", 2},
 	}
 
 	for _, tt := range tests {
@@ -35,6 +39,42 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected string
+	}{
+		{"1 / 0", false, "ERROR: 1:3: division by zero"},
+		{"1 % 0", false, "ERROR: 1:3: division by zero"},
+		{"let f = fn(x) {\n  x / (x - 1)\n}; f(1)", false,
+			"ERROR: 2:5: division by zero"},
+		{"9223372036854775807 + 1", true,
+			"ERROR: 1:21: integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", true,
+			"ERROR: 1:22: integer overflow: -9223372036854775807 - 2"},
+		{"let f = fn(x) { x * x }; f(4294967296)", true,
+			"ERROR: 1:19: integer overflow: 4294967296 * 4294967296"},
+		{"(-9223372036854775807 - 1) / -1", true,
+			"ERROR: 1:28: integer overflow: -9223372036854775808 / -1"},
+		{"-(-9223372036854775807 - 1)", true,
+			"ERROR: 1:1: integer overflow: -(-9223372036854775808)"},
+		{"9223372036854775807 + 0", true, "9223372036854775807"},
+		{"(-9223372036854775807 - 1) % -1", true, "0"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetCheckedArithmetic(tt.checked)
+
+		evaluated := Eval(testParseProgram(tt.input), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	t.Helper()
 	result, ok := obj.(*object.Integer)
@@ -225,26 +265,29 @@ func TestHashLiteral(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+	for i, pair := range result.Pairs() {
+		if pair.Key.(object.Hashable).HashKey() != expected[i].key.HashKey() {
+			t.Errorf("wrong key for pair %d. want=%s, got=%s",
+				i, expected[i].key.Inspect(), pair.Key.Inspect())
 		}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -294,6 +337,102 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`{"title": 5}.title`, 5},
+		{`let book = {"pages": {"count": 5}}; book.pages.count`, 5},
+		{`let obj = {"double": fn(x) { x * 2 }}; obj.double(3)`, 6},
+		{`{"len": 5}.len`, 5},
+		{`[1, 2, 3].len()`, 3},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`[].push(1).last()`, 1},
+		{`"hello".len()`, 5},
+		{`"Hello".upper()`, "HELLO"},
+		{`"Hello".lower()`, "hello"},
+		{`let up = "abc".upper; up()`, "ABC"},
+		{`{"title": 5}.author`, errorMessage(`no field or method "author" on HASH`)},
+		{`5.len()`, errorMessage(`no field or method "len" on INTEGER`)},
+		{`"abc".push(1)`, errorMessage(`no field or method "push" on STRING`)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorMessage:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != string(expected) {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestNullSafeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`null`, nil},
+		{`null == null`, true},
+		{`null ?? 5`, 5},
+		{`3 ?? 5`, 3},
+		{`false ?? 5`, false},
+		{`null ?? null ?? 7`, 7},
+		{`1 ?? -true`, 1},
+		{`null ?? -true`, errorMessage("unknown operator: -BOOLEAN")},
+		{`let a = null; a?.b`, nil},
+		{`let a = null; a?.b.c.d`, nil},
+		{`let a = null; a?.b.c()`, nil},
+		{`let a = null; a?["b"]["c"]`, nil},
+		{`let a = {"b": {"c": 1}}; a?.b?.c`, 1},
+		{`let a = {"b": {"c": 1}}; a?.b.c`, 1},
+		{`let a = {"b": null}; a.b?.c.d`, nil},
+		{`let a = {"b": {}}; a.b?.c`, nil},
+		{`let a = {"b": {}}; a?.b.c`, errorMessage(`no field or method "c" on HASH`)},
+		{`let a = {"b": [1, 2]}; a?.b?[1]`, 2},
+		{`let a = null; a?.b ?? 9`, 9},
+		{`let a = {"f": fn() { null }}; a.f()?.g ?? 4`, 4},
+		{`let xs = [[1]]; xs[1]?[0]`, nil},
+		{`null.b`, errorMessage(`no field or method "b" on NULL`)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case errorMessage:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != string(expected) {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -381,11 +520,18 @@ func TestErrorHandling(t *testing.T) {
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{"foobar;", "identifier not found: foobar"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{`throw "boom"`, "uncaught exception: boom"},
+		{"try { 1 / 0 } finally { 2 }", "division by zero"},
+		{"try { 1 / 0 } catch (e) { throw e.message }", "uncaught exception: division by zero"},
 		{`"Hello" - "World";`, "unknown operator: STRING - STRING"},
 		{`"Hello" * "World";`, "unknown operator: STRING * STRING"},
 		{`"Hello" / "World";`, "unknown operator: STRING / STRING"},
 		{`"Hello" < "World";`, "unknown operator: STRING < STRING"},
 		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{"name": -true}`, "unknown operator: -BOOLEAN"},
+		{`{"b": -true, "a": 1 + true}`, "unknown operator: -BOOLEAN"},
+		{`{"b": 1 + true, "a": -true}`, "type mismatch: INTEGER + BOOLEAN"},
 	}
 
 	for _, tt := range tests {
@@ -470,6 +616,29 @@ func TestFunctionCall(t *testing.T) {
 	}
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`let iter = fn(i, sum) {
+				if (i == 1000000) { return sum; }
+				iter(i + 1, sum + i)
+			};
+			iter(0, 0)`,
+			499999500000,
+		},
+		{"let f = fn(x) { if (x > 0) { f(x - 1) } else { x } }; f(100000)", 0},
+		{"let f = fn(g) { g(1) }; f(fn(x) { x + 1 })", 2},
+		{"let f = fn(a) { len(a) }; f([1, 2])", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 	let newAdder = fn(x) {
@@ -552,6 +721,23 @@ func TestBuiltinFunctions(t *testing.T) {
 
 // Helpers
 
+type errorMessage string
+
+func testStringObject(t *testing.T, obj object.Object, expected string) bool {
+	t.Helper()
+	result, ok := obj.(*object.String)
+	if !ok {
+		t.Errorf("object is not String. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%q, want=%q",
+			result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testNullObject(t *testing.T, obj object.Object) bool {
 	t.Helper()
 	if obj != NULL {
@@ -561,6 +747,39 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	return true
 }
 
+func TestEvalProgramLoadedFromJSON(t *testing.T) {
+	tests := []string{
+		"let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(10)",
+		`let book = {"title": "Go", "pages": 300}; [book.title, book["pages"]]`,
+		"let a = [1, 2, 3]; a.push(4)[3] + a?[0] + (null?.x ?? -1)",
+		"if (false) { 1 }",
+		"fn(x) { x * 2 }",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		data, err := ast.Marshal(program)
+		if err != nil {
+			t.Fatalf("marshal error: %s", err)
+		}
+		loaded, err := ast.UnmarshalProgram(data)
+		if err != nil {
+			t.Fatalf("unmarshal error: %s", err)
+		}
+
+		want := Eval(program, object.NewEnvironment())
+		got := Eval(loaded, object.NewEnvironment())
+
+		if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+			t.Errorf("%q: wrong result. want=%s, got=%s",
+				input, want.Inspect(), got.Inspect())
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
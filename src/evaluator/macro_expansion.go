@@ -0,0 +1,242 @@
+package evaluator
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// MacroError is returned by ExpandMacros. Pos points at the macro call site.
+type MacroError struct {
+	Pos     token.Position
+	Macro   string
+	Message string
+}
+
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("%s: in macro %s: %s", e.Pos, e.Macro, e.Message)
+}
+
+// DefineMacros removes every top-level `let name = macro(...) { ... }` from
+// the program and binds the macro in env instead.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	definitions := []int{}
+
+	for i, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			addMacro(statement, env)
+			definitions = append(definitions, i)
+		}
+	}
+
+	for i := len(definitions) - 1; i >= 0; i-- {
+		definitionIndex := definitions[i]
+		program.Statements = append(
+			program.Statements[:definitionIndex],
+			program.Statements[definitionIndex+1:]...,
+		)
+	}
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	letStatement, ok := node.(*ast.LetStatement)
+	if !ok {
+		return false
+	}
+
+	_, ok = letStatement.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+func addMacro(stmt ast.Statement, env *object.Environment) {
+	letStatement, _ := stmt.(*ast.LetStatement)
+	macroLiteral, _ := letStatement.Value.(*ast.MacroLiteral)
+
+	macro := &object.Macro{
+		Parameters: macroLiteral.Parameters,
+		Body:       macroLiteral.Body,
+		Env:        env,
+	}
+
+	env.Set(letStatement.Name.Value, macro)
+}
+
+// ExpandMacros replaces every call to a macro bound in env with the AST the
+// macro returns. The expansion is hygienic: bindings introduced by the macro
+// body are renamed so they never clash with the code passed as arguments.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	var err error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		if err != nil {
+			return node
+		}
+
+		callExpression, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+
+		ident, macro, ok := isMacroCall(callExpression, env)
+		if !ok {
+			return node
+		}
+
+		fail := func(format string, a ...any) ast.Node {
+			err = &MacroError{
+				Pos:     ident.Token.Pos,
+				Macro:   ident.Value,
+				Message: fmt.Sprintf(format, a...),
+			}
+			return node
+		}
+
+		if len(callExpression.Arguments) != len(macro.Parameters) {
+			return fail("wrong number of arguments: want=%d, got=%d",
+				len(macro.Parameters), len(callExpression.Arguments))
+		}
+
+		args := quoteArgs(callExpression)
+		evalEnv := extendMacroEnv(macro, args)
+
+		evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
+		if errObj, ok := evaluated.(*object.Error); ok {
+			return fail("%s", errObj.Message)
+		}
+
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			if evaluated == nil {
+				return fail("macro must return a quoted AST node, got nothing")
+			}
+			return fail("macro must return a quoted AST node, got %s",
+				evaluated.Type())
+		}
+
+		return hygienize(quote.Node, args)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return expanded, nil
+}
+
+func isMacroCall(
+	exp *ast.CallExpression,
+	env *object.Environment,
+) (*ast.Identifier, *object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, nil, false
+	}
+
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, nil, false
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return identifier, macro, true
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+
+	return args
+}
+
+func extendMacroEnv(
+	macro *object.Macro,
+	args []*object.Quote,
+) *object.Environment {
+	extended := object.NewEnclosedEnvironment(macro.Env)
+
+	for paramIdx, param := range macro.Parameters {
+		extended.Set(param.Value, args[paramIdx])
+	}
+
+	return extended
+}
+
+// Hygiene
+
+var gensymCounter atomic.Int64
+
+// gensym returns a fresh identifier name. The '@' can't appear in source, so
+// the name can never be captured by user code.
+func gensym(name string) string {
+	return fmt.Sprintf("%s@%d", name, gensymCounter.Add(1))
+}
+
+// hygienize renames every binding that the macro itself introduces, together
+// with the macro's own references to it. Nodes that came from the arguments
+// are left untouched, so they keep referring to the caller's bindings.
+func hygienize(expanded ast.Node, args []*object.Quote) ast.Node {
+	fromCaller := map[ast.Node]bool{}
+	for _, arg := range args {
+		ast.Inspect(arg.Node, func(node ast.Node) bool {
+			fromCaller[node] = true
+			return true
+		}, nil)
+	}
+
+	renames := map[string]string{}
+	introduce := func(ident *ast.Identifier) {
+		if _, ok := renames[ident.Value]; !ok {
+			renames[ident.Value] = gensym(ident.Value)
+		}
+	}
+
+	// Property names after a dot are not references to bindings.
+	properties := map[ast.Node]bool{}
+
+	ast.Inspect(expanded, func(node ast.Node) bool {
+		if fromCaller[node] {
+			return false
+		}
+
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			introduce(node.Name)
+		case *ast.FunctionLiteral:
+			for _, param := range node.Parameters {
+				introduce(param)
+			}
+		case *ast.MemberExpression:
+			properties[node.Property] = true
+		}
+
+		return true
+	}, nil)
+
+	if len(renames) == 0 {
+		return expanded
+	}
+
+	return ast.Modify(expanded, func(node ast.Node) ast.Node {
+		ident, ok := node.(*ast.Identifier)
+		if !ok || fromCaller[node] || properties[node] {
+			return node
+		}
+
+		name, ok := renames[ident.Value]
+		if !ok {
+			return node
+		}
+
+		return &ast.Identifier{Token: ident.Token, Value: name}
+	})
+}
@@ -0,0 +1,223 @@
+package evaluator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/lexer"
+	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/parser"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+
+	if macro.Parameters[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", macro.Parameters[0])
+	}
+	if macro.Parameters[1].String() != "y" {
+		t.Fatalf("parameter is not 'y'. got=%q", macro.Parameters[1])
+	}
+
+	expectedBody := "(x + y)"
+
+	if macro.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let infixExpression = macro() { quote(1 + 2); };
+
+			infixExpression();
+			`,
+			`(1 + 2)`,
+		},
+		{
+			`
+			let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+
+			reverse(2 + 2, 10 - 5);
+			`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("macro expansion failed: %s", err)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q",
+				expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandMacrosIsHygienic(t *testing.T) {
+	input := `
+	let benchmark = macro(label, body) {
+		quote(fn() {
+			let start = 1000;
+			let result = unquote(body);
+			[unquote(label), result, start];
+		}());
+	};
+
+	let start = 1;
+	let result = benchmark("sum", start + 41);
+	[result[0], result[1], result[2], start];
+	`
+
+	evaluated := testEvalWithMacros(t, input)
+
+	array, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	testStringObject(t, array.Elements[0], "sum")
+	testIntegerObject(t, array.Elements[1], 42)
+	testIntegerObject(t, array.Elements[2], 1000)
+	testIntegerObject(t, array.Elements[3], 1)
+
+	// Property names are not bindings and must survive renaming.
+	input = `
+	let m = macro() { quote(fn() { let len = 3; "ab".len() + len }()) };
+	m();
+	`
+	testIntegerObject(t, testEvalWithMacros(t, input), 5)
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *MacroError
+	}{
+		{
+			"let m = macro(a) { quote(a) };\nm(1, 2);",
+			&MacroError{
+				Pos:     token.Position{Line: 2, Column: 1},
+				Macro:   "m",
+				Message: "wrong number of arguments: want=1, got=2",
+			},
+		},
+		{
+			"let m = macro() { 1 };\n\n  let x = m();",
+			&MacroError{
+				Pos:     token.Position{Line: 3, Column: 11},
+				Macro:   "m",
+				Message: "macro must return a quoted AST node, got INTEGER",
+			},
+		},
+		{
+			"let m = macro(a) { -true };\nputs(m(1));",
+			&MacroError{
+				Pos:     token.Position{Line: 2, Column: 6},
+				Macro:   "m",
+				Message: "unknown operator: -BOOLEAN",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+
+		var macroErr *MacroError
+		if !errors.As(err, &macroErr) {
+			t.Fatalf("expected *MacroError. got=%T (%v)", err, err)
+		}
+
+		if *macroErr != *tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, macroErr)
+		}
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testEvalWithMacros(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	program := testParseProgram(input)
+	macroEnv := object.NewEnvironment()
+
+	DefineMacros(program, macroEnv)
+	expanded, err := ExpandMacros(program, macroEnv)
+	if err != nil {
+		t.Fatalf("macro expansion failed: %s", err)
+	}
+
+	return Eval(expanded, object.NewEnvironment())
+}
@@ -0,0 +1,109 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+func quote(node ast.Node, env *object.Environment) object.Object {
+	var err *object.Error
+
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		if err != nil || !isUnquoteCall(node) {
+			return node
+		}
+
+		call, _ := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			err = newError(call.Token.Pos, "wrong number of arguments to unquote. got=%d, want=1",
+				len(call.Arguments))
+			return node
+		}
+
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+
+		converted, ok := convertObjectToASTNode(unquoted, call.Token.Pos)
+		if !ok {
+			err = newError(call.Token.Pos, "cannot unquote %s into an AST node", unquoted.Type())
+			return node
+		}
+
+		return converted
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return &object.Quote{Node: node}
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	return isCallTo(node, "unquote")
+}
+
+func isCallTo(node ast.Node, name string) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return false
+	}
+
+	return ident.Value == name
+}
+
+// convertObjectToASTNode turns the result of an unquote back into syntax. The
+// new nodes get pos so errors in them point at the unquote call.
+func convertObjectToASTNode(
+	obj object.Object,
+	pos token.Position,
+) (ast.Node, bool) {
+	switch obj := obj.(type) {
+
+	case *object.Integer:
+		t := token.Token{
+			Type:    token.INT,
+			Literal: fmt.Sprintf("%d", obj.Value),
+			Pos:     pos,
+		}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.BigInt:
+		t := token.Token{Type: token.INT, Literal: obj.Inspect(), Pos: pos}
+		return &ast.IntegerLiteral{Token: t, Big: obj.Value}, true
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+
+	case *object.Null:
+		t := token.Token{Type: token.NULL, Literal: "null", Pos: pos}
+		return &ast.NullLiteral{Token: t}, true
+
+	case *object.Quote:
+		return obj.Node, true
+
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,78 @@
+package evaluator
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// tailCall is a call in tail position of a function body, made by
+// applyFunction once the body returns it, so that loops written as tail calls
+// don't grow the Go stack.
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+}
+
+func (c *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (c *tailCall) Inspect() string         { return "tail call" }
+
+// evalTailBlock evaluates block like evalBlockStatement, returning the calls
+// in tail position, the ones ast.TailCalls reports, as *tailCall. value
+// reports whether the value of the block is returned by the function.
+func evalTailBlock(
+	block *ast.BlockStatement,
+	env *object.Environment,
+	value bool,
+) object.Object {
+	var result object.Object
+
+	for i, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			if stmt.ReturnValue == nil {
+				result = Eval(stmt, env)
+				break
+			}
+
+			result = evalTail(stmt.ReturnValue, env, true)
+			if !isError(result) {
+				result = &object.ReturnValue{Value: result}
+			}
+
+		case *ast.ExpressionStatement:
+			result = evalTail(stmt.Expression, env, value && i == len(block.Statements)-1)
+
+		default:
+			result = Eval(stmt, env)
+		}
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func evalTail(exp ast.Expression, env *object.Environment, value bool) object.Object {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		if value {
+			result, _ := evalCall(exp, env, true)
+			return result
+		}
+
+	case *ast.IfExpression:
+		branch, result := ifBranch(exp, env)
+		if branch == nil {
+			return result
+		}
+
+		return evalTailBlock(branch, env, value)
+	}
+
+	return Eval(exp, env)
+}
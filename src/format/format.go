@@ -0,0 +1,685 @@
+// Package format pretty-prints Monkey source code in its canonical style: two
+// spaces of indentation, one statement per line, a semicolon after every
+// statement but if expressions, and only the parentheses that precedence
+// requires. Calls, arrays and hashes that don't fit in MaxWidth columns are
+// broken into one element per line. Comments are kept.
+//
+// Formatting is idempotent: formatting formatted code changes nothing.
+package format
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/lexer"
+	"github.com/ZeroBl21/go-monkey/src/parser"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// MaxWidth is the column limit the formatter tries to keep lines under.
+const MaxWidth = 80
+
+const indentation = "  "
+
+// Source parses src and returns it formatted. It fails if src doesn't parse.
+func Source(src []byte) ([]byte, error) {
+	l := lexer.New(string(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{
+		lines:    strings.Split(string(src), "\n"),
+		comments: l.Comments(),
+	}
+	pr.program(program)
+
+	return []byte(pr.out.String()), nil
+}
+
+// Node formats a single node. Since there is no source, no comments are
+// printed and no blank lines are kept.
+func Node(node ast.Node) string {
+	p := &printer{}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		p.program(node)
+	case ast.Statement:
+		p.statement(node)
+	case ast.Expression:
+		p.expression(node)
+	}
+
+	return p.out.String()
+}
+
+type printer struct {
+	out    strings.Builder
+	col    int // Column of the next write, starting at 0
+	indent int
+
+	lines    []string        // Source lines, to find blank lines
+	comments []lexer.Comment // Comments not printed yet, in source order
+
+	flat           bool // Never break lists; set while measuring
+	atStart        bool // Nothing printed yet in the current block or list
+	lineHasComment bool // A comment already ends the current line
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = len(s) - i - 1
+	} else {
+		p.col += len(s)
+	}
+}
+
+func (p *printer) newline() {
+	p.write("\n" + strings.Repeat(indentation, p.indent))
+	p.lineHasComment = false
+}
+
+// startItem moves to a fresh line for the next statement or own-line
+// comment, keeping a single blank line if the source had one before pos.
+func (p *printer) startItem(pos token.Position) {
+	if p.out.Len() > 0 {
+		if !p.atStart && p.blankLineBefore(pos) {
+			p.write("\n")
+		}
+		p.newline()
+	}
+
+	p.atStart = false
+}
+
+// blankLineBefore reports whether pos starts its source line and the line
+// above it is blank.
+func (p *printer) blankLineBefore(pos token.Position) bool {
+	if !pos.IsValid() || pos.Line < 2 || pos.Line > len(p.lines) {
+		return false
+	}
+
+	line := p.lines[pos.Line-1]
+	if pos.Column-1 > len(line) || strings.TrimSpace(line[:pos.Column-1]) != "" {
+		return false
+	}
+
+	return strings.TrimSpace(p.lines[pos.Line-2]) == ""
+}
+
+// measure prints with fn on a scratch printer that starts at the current
+// column, and returns the result.
+func (p *printer) measure(fn func(q *printer)) string {
+	q := &printer{col: p.col, indent: p.indent, flat: true}
+	fn(q)
+
+	return q.out.String()
+}
+
+// fits reports whether the first line of s fits after the current column.
+func (p *printer) fits(s string) bool {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+
+	return p.col+len(s) <= MaxWidth
+}
+
+// Comments
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// hasCommentIn reports whether a pending comment lies between from and to.
+func (p *printer) hasCommentIn(from, to token.Position) bool {
+	if !from.IsValid() || !to.IsValid() {
+		return false
+	}
+
+	for _, c := range p.comments {
+		if before(from, c.Pos) && before(c.Pos, to) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flushComments prints every pending comment that starts before pos.
+func (p *printer) flushComments(pos token.Position) {
+	if !pos.IsValid() {
+		return
+	}
+
+	for len(p.comments) > 0 && before(p.comments[0].Pos, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if c.Trailing && !p.lineHasComment && p.out.Len() > 0 {
+			p.write(" ")
+		} else {
+			p.startItem(c.Pos)
+		}
+
+		p.write(c.Text)
+		p.lineHasComment = true
+	}
+}
+
+func (p *printer) flushAllComments() {
+	p.flushComments(token.Position{Line: int(^uint(0) >> 1)})
+}
+
+// Statements
+
+func (p *printer) program(program *ast.Program) {
+	p.atStart = true
+	p.statements(program.Statements)
+	p.flushAllComments()
+
+	if p.out.Len() > 0 {
+		p.write("\n")
+	}
+}
+
+func (p *printer) statements(statements []ast.Statement) {
+	for i, s := range statements {
+		pos := statementPos(s)
+		p.flushComments(pos)
+		p.startItem(pos)
+
+		p.statement(s)
+
+		var next ast.Statement
+		if i+1 < len(statements) {
+			next = statements[i+1]
+		}
+		if p.needsSemicolon(s, next) {
+			p.write(";")
+		}
+
+		p.atStart = false
+	}
+}
+
+func (p *printer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.write("let " + s.Name.Value + " = ")
+		p.expression(s.Value)
+
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expression(s.ReturnValue)
+		}
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression)
+
+	case *ast.BlockStatement:
+		p.block(s)
+
+	default:
+		p.write(s.String())
+	}
+}
+
+// needsSemicolon reports whether s must be followed by a semicolon. An if or
+// try expression statement doesn't need one, unless next would otherwise be
+// parsed as its operand, as in `if (x) { a } else { b }; -1`.
+func (p *printer) needsSemicolon(s, next ast.Statement) bool {
+	es, ok := s.(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+
+	switch es.Expression.(type) {
+	case *ast.IfExpression, *ast.TryExpression:
+	default:
+		return true
+	}
+
+	if next == nil {
+		return false
+	}
+
+	start := p.measure(func(q *printer) { q.statement(next) })
+	return start != "" && strings.ContainsRune("([-", rune(start[0]))
+}
+
+func (p *printer) block(b *ast.BlockStatement) {
+	if exp, ok := p.inlineExpression(b); ok {
+		p.write("{ ")
+		p.expression(exp)
+		p.write(" }")
+		return
+	}
+
+	p.multilineBlock(b)
+}
+
+// inlineExpression returns the only expression of b if b can be printed on
+// the current line as `{ expression }`.
+func (p *printer) inlineExpression(b *ast.BlockStatement) (ast.Expression, bool) {
+	if len(b.Statements) != 1 || p.hasCommentIn(b.Token.Pos, b.Rbrace.Pos) {
+		return nil, false
+	}
+
+	stmt, ok := b.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+
+	inline := p.measure(func(q *printer) {
+		q.write("{ ")
+		q.expression(stmt.Expression)
+		q.write(" }")
+	})
+	if strings.Contains(inline, "\n") || !p.fits(inline) {
+		return nil, false
+	}
+
+	return stmt.Expression, true
+}
+
+// Expressions
+
+func (p *printer) expression(e ast.Expression) {
+	switch e := e.(type) {
+
+	case *ast.Identifier:
+		p.write(e.Value)
+
+	case *ast.IntegerLiteral:
+		if e.Token.Literal != "" {
+			p.write(e.Token.Literal)
+		} else if e.Big != nil {
+			p.write(e.Big.String())
+		} else {
+			p.write(strconv.FormatInt(e.Value, 10))
+		}
+
+	case *ast.StringLiteral:
+		p.write(`"` + e.Value + `"`)
+
+	case *ast.Boolean:
+		p.write(strconv.FormatBool(e.Value))
+
+	case *ast.NullLiteral:
+		p.write("null")
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.operand(e.Right, precedence(e.Right) < parser.PREFIX)
+
+	case *ast.InfixExpression:
+		prec := parser.Precedence(token.TokenType(e.Operator))
+		p.operand(e.Left, precedence(e.Left) < prec)
+		p.write(" " + e.Operator + " ")
+		p.operand(e.Right, precedence(e.Right) <= prec)
+
+	case *ast.CallExpression:
+		p.operand(e.Function, precedence(e.Function) < parser.CALL)
+		p.list("(", ")", e.Token.Pos, expressionItems(e.Arguments))
+
+	case *ast.IndexExpression:
+		p.operand(e.Left, precedence(e.Left) < parser.CALL)
+		if e.Optional {
+			p.write("?[")
+		} else {
+			p.write("[")
+		}
+		p.expression(e.Index)
+		p.write("]")
+
+	case *ast.MemberExpression:
+		p.operand(e.Object, precedence(e.Object) < parser.CALL)
+		if e.Optional {
+			p.write("?.")
+		} else {
+			p.write(".")
+		}
+		p.write(e.Property.Value)
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", e.Token.Pos, expressionItems(e.Elements))
+
+	case *ast.HashLiteral:
+		p.list("{", "}", e.Token.Pos, hashItems(e))
+
+	case *ast.FunctionLiteral:
+		p.write("fn")
+		p.parameters(e.Parameters)
+		p.block(e.Body)
+
+	case *ast.MacroLiteral:
+		p.write("macro")
+		p.parameters(e.Parameters)
+		p.block(e.Body)
+
+	case *ast.IfExpression:
+		p.ifExpression(e)
+
+	case *ast.ThrowExpression:
+		p.write("throw ")
+		p.expression(e.Value)
+
+	case *ast.TryExpression:
+		p.tryExpression(e)
+
+	default:
+		p.write(e.String())
+	}
+}
+
+func (p *printer) operand(e ast.Expression, parens bool) {
+	if parens {
+		p.write("(")
+	}
+
+	p.expression(e)
+
+	if parens {
+		p.write(")")
+	}
+}
+
+func (p *printer) parameters(params []*ast.Identifier) {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.Value
+	}
+
+	p.write("(" + strings.Join(names, ", ") + ") ")
+}
+
+func (p *printer) ifExpression(e *ast.IfExpression) {
+	hasElse := e.Alternative != nil && e.Alternative.Statements != nil
+
+	p.write("if (")
+	p.expression(e.Condition)
+	p.write(") ")
+
+	consequence, consequenceOk := p.inlineExpression(e.Consequence)
+	alternative, alternativeOk := consequence, true
+	if hasElse {
+		alternative, alternativeOk = p.inlineExpression(e.Alternative)
+	}
+
+	// Both branches go on one line or neither does.
+	if consequenceOk && alternativeOk {
+		inline := func(q *printer) {
+			q.write("{ ")
+			q.expression(consequence)
+			q.write(" }")
+			if hasElse {
+				q.write(" else { ")
+				q.expression(alternative)
+				q.write(" }")
+			}
+		}
+
+		if s := p.measure(inline); !strings.Contains(s, "\n") && p.fits(s) {
+			inline(p)
+			return
+		}
+	}
+
+	p.multilineBlock(e.Consequence)
+	if hasElse {
+		p.write(" else ")
+		p.multilineBlock(e.Alternative)
+	}
+}
+
+func (p *printer) tryExpression(e *ast.TryExpression) {
+	blocks := []*ast.BlockStatement{e.Block, e.Catch, e.Finally}
+
+	clause := func(q *printer, i int) {
+		switch i {
+		case 0:
+			q.write("try ")
+		case 1:
+			q.write(" catch (" + e.Parameter.Value + ") ")
+		case 2:
+			q.write(" finally ")
+		}
+	}
+
+	// All the blocks go on one line or none does.
+	inline := func(q *printer) {
+		for i, b := range blocks {
+			if b == nil {
+				continue
+			}
+			clause(q, i)
+			q.block(b)
+		}
+	}
+
+	oneLine := true
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		if _, ok := p.inlineExpression(b); !ok {
+			oneLine = false
+		}
+	}
+
+	if oneLine {
+		if s := p.measure(inline); !strings.Contains(s, "\n") && p.fits(s) {
+			inline(p)
+			return
+		}
+	}
+
+	for i, b := range blocks {
+		if b == nil {
+			continue
+		}
+		clause(p, i)
+		p.multilineBlock(b)
+	}
+}
+
+// multilineBlock prints b like block, but never on a single line.
+func (p *printer) multilineBlock(b *ast.BlockStatement) {
+	if len(b.Statements) == 0 && !p.hasCommentIn(b.Token.Pos, b.Rbrace.Pos) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	p.atStart = true
+
+	p.statements(b.Statements)
+	p.flushComments(b.Rbrace.Pos)
+
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.atStart = false
+}
+
+// Lists
+
+// An item is one element of a call, array or hash literal.
+type item struct {
+	pos   token.Position
+	print func(p *printer)
+}
+
+func expressionItems(expressions []ast.Expression) []item {
+	items := make([]item, len(expressions))
+	for i, e := range expressions {
+		items[i] = item{pos: startPos(e), print: func(p *printer) { p.expression(e) }}
+	}
+
+	return items
+}
+
+// hashItems returns the pairs of hash in source order.
+func hashItems(hash *ast.HashLiteral) []item {
+	items := make([]item, len(hash.Pairs))
+	for i, pair := range hash.Pairs {
+		items[i] = item{pos: startPos(pair.Key), print: func(p *printer) {
+			p.expression(pair.Key)
+			p.write(": ")
+			p.expression(pair.Value)
+		}}
+	}
+
+	return items
+}
+
+// list prints items between open and close. They stay on one line if they
+// fit, where only the last item may span several lines, as a function
+// literal passed last does. Otherwise every item gets a line of its own.
+func (p *printer) list(open, close string, openPos token.Position, items []item) {
+	p.write(open)
+
+	if len(items) == 0 {
+		p.write(close)
+		return
+	}
+
+	if p.flat || p.listFits(items, close) &&
+		!p.hasCommentIn(openPos, items[len(items)-1].pos) {
+		for i, it := range items {
+			if i > 0 {
+				p.write(", ")
+			}
+			it.print(p)
+		}
+		p.write(close)
+		return
+	}
+
+	p.indent++
+	p.atStart = true
+
+	for i, it := range items {
+		if i > 0 {
+			p.write(",")
+		}
+		p.flushComments(it.pos)
+		p.newline()
+		it.print(p)
+	}
+
+	p.indent--
+	p.newline()
+	p.write(close)
+	p.atStart = false
+}
+
+func (p *printer) listFits(items []item, close string) bool {
+	lastStart := 0
+	flat := p.measure(func(q *printer) {
+		for i, it := range items {
+			if i > 0 {
+				q.write(", ")
+			}
+			lastStart = q.out.Len()
+			it.print(q)
+		}
+		q.write(close)
+	})
+
+	if i := strings.IndexByte(flat, '\n'); i >= 0 && i < lastStart {
+		return false
+	}
+
+	return p.fits(flat)
+}
+
+// Positions and precedence
+
+// precedence returns how tightly e binds, so it can be parenthesized when it
+// is the operand of an operator that binds tighter.
+func precedence(e ast.Expression) parser.BindingPower {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(token.TokenType(e.Operator))
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.MemberExpression:
+		return parser.INDEX
+	case *ast.ThrowExpression:
+		return parser.LOWEST
+	default:
+		return parser.INDEX + 1
+	}
+}
+
+func statementPos(s ast.Statement) token.Position {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token.Pos
+	case *ast.ReturnStatement:
+		return s.Token.Pos
+	case *ast.ExpressionStatement:
+		return s.Token.Pos
+	case *ast.BlockStatement:
+		return s.Token.Pos
+	default:
+		return token.Position{}
+	}
+}
+
+// startPos returns the position of the first token of e.
+func startPos(e ast.Expression) token.Position {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return startPos(e.Left)
+	case *ast.CallExpression:
+		return startPos(e.Function)
+	case *ast.IndexExpression:
+		return startPos(e.Left)
+	case *ast.MemberExpression:
+		return startPos(e.Object)
+	case *ast.Identifier:
+		return e.Token.Pos
+	case *ast.IntegerLiteral:
+		return e.Token.Pos
+	case *ast.StringLiteral:
+		return e.Token.Pos
+	case *ast.Boolean:
+		return e.Token.Pos
+	case *ast.NullLiteral:
+		return e.Token.Pos
+	case *ast.PrefixExpression:
+		return e.Token.Pos
+	case *ast.ArrayLiteral:
+		return e.Token.Pos
+	case *ast.HashLiteral:
+		return e.Token.Pos
+	case *ast.FunctionLiteral:
+		return e.Token.Pos
+	case *ast.MacroLiteral:
+		return e.Token.Pos
+	case *ast.IfExpression:
+		return e.Token.Pos
+	case *ast.ThrowExpression:
+		return e.Token.Pos
+	case *ast.TryExpression:
+		return e.Token.Pos
+	default:
+		return token.Position{}
+	}
+}
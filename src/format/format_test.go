@@ -0,0 +1,284 @@
+package format
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/lexer"
+	"github.com/ZeroBl21/go-monkey/src/parser"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Spacing and semicolons
+		{"let   x=1", "let x = 1;\n"},
+		{"let x = 1;;; x", "let x = 1;\nx;\n"},
+		{"return 1 ; ", "return 1;\n"},
+		{"if(x){1}else{2}", "if (x) { 1 } else { 2 }\n"},
+		{"if (x) { 1 }; -1", "if (x) { 1 };\n-1;\n"},
+		{"if (x) { 1 } let y = 2", "if (x) { 1 }\nlet y = 2;\n"},
+		{"a.b?.c?[d] ?? null", "a.b?.c?[d] ?? null;\n"},
+		{`{"a":1,"b":[1,2]}`, "{\"a\": 1, \"b\": [1, 2]};\n"},
+		{"fn(){}", "fn() {};\n"},
+		{"1_000", "1_000;\n"},
+
+		// Parentheses
+		{"(1 + 2) * 3", "(1 + 2) * 3;\n"},
+		{"1 + (2 * 3)", "1 + 2 * 3;\n"},
+		{"(1 - 2) - 3", "1 - 2 - 3;\n"},
+		{"1 - (2 - 3)", "1 - (2 - 3);\n"},
+		{"(a % b) * c", "a % b * c;\n"},
+		{"a % (b * c)", "a % (b * c);\n"},
+		{"-(1 + 2)", "-(1 + 2);\n"},
+		{"(-a).b", "(-a).b;\n"},
+		{"-(a.b)", "-a.b;\n"},
+		{"(a + b)(c)", "(a + b)(c);\n"},
+		{"(f(x))[0]", "f(x)[0];\n"},
+		{"!(a == b)", "!(a == b);\n"},
+		{"(a ?? b) == c", "(a ?? b) == c;\n"},
+		{"a ?? (b == c)", "a ?? b == c;\n"},
+		{"a ?? throw b", "a ?? (throw b);\n"},
+		{"(a ?? throw b) ?? c", "a ?? (throw b) ?? c;\n"},
+		{"throw a ?? b", "throw a ?? b;\n"},
+
+		// Blocks
+		{
+			"let f = fn(x) { let y = x; y }",
+			"let f = fn(x) {\n  let y = x;\n  y;\n};\n",
+		},
+		{
+			"if (x) { 1 } else { let y = 2; y }",
+			"if (x) {\n  1;\n} else {\n  let y = 2;\n  y;\n}\n",
+		},
+		{
+			"let x = try{f()}catch(e){0}finally{g()}",
+			"let x = try { f() } catch (e) { 0 } finally { g() };\n",
+		},
+		{
+			"try { let y = f(); y } catch (e) { 0 }; -1",
+			"try {\n  let y = f();\n  y;\n} catch (e) {\n  0;\n};\n-1;\n",
+		},
+		{
+			"try { f() } finally { } 1",
+			"try {\n  f();\n} finally {}\n1;\n",
+		},
+		{
+			"let f = fn() {\n  return 1;\n}",
+			"let f = fn() {\n  return 1;\n};\n",
+		},
+
+		// Line breaking
+		{
+			`puts("a fairly long string argument", "another fairly long string argument", 1)`,
+			`puts("a fairly long string argument", "another fairly long string argument", 1);` + "\n",
+		},
+		{
+			`puts("a fairly long string argument", "another fairly long string argument", 123)`,
+			"puts(\n" +
+				"  \"a fairly long string argument\",\n" +
+				"  \"another fairly long string argument\",\n" +
+				"  123\n" +
+				");\n",
+		},
+		{
+			`let book = {"title": "Writing A Compiler In Go", "author": "Thorsten Ball", "year": 2018}`,
+			"let book = {\n" +
+				"  \"title\": \"Writing A Compiler In Go\",\n" +
+				"  \"author\": \"Thorsten Ball\",\n" +
+				"  \"year\": 2018\n" +
+				"};\n",
+		},
+		{
+			"let numbers = [[1000000000, 2000000000, 3000000000], [4000000000, 5000000000], [6]]",
+			"let numbers = [\n" +
+				"  [1000000000, 2000000000, 3000000000],\n" +
+				"  [4000000000, 5000000000],\n" +
+				"  [6]\n" +
+				"];\n",
+		},
+		{
+			"map(arr, fn(x) { let y = x * 2; y })",
+			"map(arr, fn(x) {\n  let y = x * 2;\n  y;\n});\n",
+		},
+
+		// Comments
+		{
+			"// leading\n\n// before x\nlet x = 1; // x\n\n\n// before y\nlet y = 2;\n// end",
+			"// leading\n\n// before x\nlet x = 1; // x\n\n// before y\nlet y = 2;\n// end\n",
+		},
+		{
+			"let f = fn() { // open\n  x // last\n  // closing\n}",
+			"let f = fn() { // open\n  x; // last\n  // closing\n};\n",
+		},
+		{
+			"puts(1, // one\n  2)",
+			"puts(\n  1, // one\n  2\n);\n",
+		},
+		{
+			"let x = 1 + // a\n  2 + // b\n  3;",
+			"let x = 1 + 2 + 3; // a\n// b\n",
+		},
+		{
+			"if (x) {\n  // nothing yet\n}",
+			"if (x) {\n  // nothing yet\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) failed: %s", tt.input, err)
+			continue
+		}
+
+		if string(formatted) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q",
+				tt.input, tt.expected, formatted)
+		}
+
+		testFormatted(t, tt.input, formatted)
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source([]byte("let = 1;"))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	expected := "expected next token to be IDENT, got = instead"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob("../../examples/*.lang")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+
+	for _, path := range paths {
+		input, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted, err := Source(input)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", filepath.Base(path)+".golden")
+		if *update {
+			if err := os.WriteFile(golden, formatted, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s. Run go test with -update to create it", err)
+		}
+
+		if string(formatted) != string(expected) {
+			t.Errorf("%s: output differs from %s.\nwant=%s\ngot= %s",
+				path, golden, expected, formatted)
+		}
+
+		testFormatted(t, string(input), formatted)
+	}
+}
+
+func TestNode(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(x) { (x + 1) * 2 }; f(1)")).ParseProgram()
+
+	expected := "let f = fn(x) { (x + 1) * 2 };\nf(1);\n"
+	if got := Node(program); got != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, got)
+	}
+
+	if got := Node(program.Statements[1]); got != "f(1)" {
+		t.Errorf("wrong output. want=%q, got=%q", "f(1)", got)
+	}
+}
+
+// testFormatted checks that formatted means the same as input, keeps all of
+// its comments and doesn't change when formatted again.
+func testFormatted(t *testing.T, input string, formatted []byte) {
+	t.Helper()
+
+	want := dump(parser.New(lexer.New(input)).ParseProgram())
+	got := dump(parser.New(lexer.New(string(formatted))).ParseProgram())
+	if got != want {
+		t.Errorf("formatting changed the program.\nwant=%q\ngot= %q", want, got)
+	}
+
+	if count(input) != count(string(formatted)) {
+		t.Errorf("formatting lost comments. want=%d, got=%d",
+			count(input), count(string(formatted)))
+	}
+
+	again, err := Source(formatted)
+	if err != nil {
+		t.Errorf("formatted output doesn't parse: %s", err)
+		return
+	}
+	if string(again) != string(formatted) {
+		t.Errorf("formatting is not idempotent.\nfirst= %q\nsecond=%q",
+			formatted, again)
+	}
+}
+
+// dump prints the structure of node, parenthesizing every node.
+func dump(node ast.Node) string {
+	var out strings.Builder
+
+	ast.Inspect(node, func(node ast.Node) bool {
+		fmt.Fprintf(&out, "(%T", node)
+
+		switch node := node.(type) {
+		case *ast.Identifier:
+			fmt.Fprintf(&out, " %s", node.Value)
+		case *ast.IntegerLiteral:
+			fmt.Fprintf(&out, " %d", node.Value)
+		case *ast.StringLiteral:
+			fmt.Fprintf(&out, " %q", node.Value)
+		case *ast.Boolean:
+			fmt.Fprintf(&out, " %t", node.Value)
+		case *ast.PrefixExpression:
+			fmt.Fprintf(&out, " %s", node.Operator)
+		case *ast.InfixExpression:
+			fmt.Fprintf(&out, " %s", node.Operator)
+		case *ast.IndexExpression:
+			fmt.Fprintf(&out, " %t", node.Optional)
+		case *ast.MemberExpression:
+			fmt.Fprintf(&out, " %t", node.Optional)
+		}
+
+		return true
+	}, func(ast.Node) {
+		out.WriteString(")")
+	})
+
+	return out.String()
+}
+
+func count(input string) int {
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Type != "EOF"; tok = l.NextToken() {
+	}
+
+	return len(l.Comments())
+}
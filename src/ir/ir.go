@@ -0,0 +1,258 @@
+// Package ir is the intermediate representation the compiler lowers the
+// syntax tree into before assembling the bytecode: the instructions of a
+// function in basic blocks, with the flow of control between them as
+// explicit edges. The instructions are those of the VM and keep its model,
+// pushing and popping values, with the locals in the slots of the frame.
+package ir
+
+import (
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// Function is a compiled function or the main program.
+type Function struct {
+	Name          string
+	NumParameters int
+	NumLocals     int
+	// Index of the constant of the compiled function, -1 for the main
+	// program.
+	Constant int
+
+	// The blocks in the order they are laid out in the bytecode. Control
+	// falls through from a block that doesn't end in a jump, return or
+	// throw into the next one.
+	Blocks   []*Block
+	Handlers []Handler
+}
+
+// Block is a basic block: instructions that run one after the other, only
+// entered at the first, and only left after the last, which is the only one
+// that can be a jump, return or throw.
+type Block struct {
+	// The position of the block in the layout of its function, set by
+	// Link.
+	ID           int
+	Instructions []Instruction
+
+	// The blocks control goes to after the block and comes from before it,
+	// set by Link. Errors going to handlers are not edges.
+	Succs []*Block
+	Preds []*Block
+}
+
+// Instruction is an instruction of the VM. The operand of a jump is the
+// offset of its Target, only known once the function is lowered.
+type Instruction struct {
+	Op       code.Opcode
+	Operands []int
+	Target   *Block
+	// Position of the source code the instruction was compiled from, and
+	// the calls inlined around it, see code.SourceMapEntry.
+	Pos      token.Position
+	Inlined  *code.InlinedCall
+	TailCall bool
+}
+
+// Handler sends the errors raised in the blocks from Start up to End,
+// excluded, to Target, with the stack unwound to Depth values above the
+// locals. See code.Handler.
+type Handler struct {
+	Start, End, Target *Block
+	Depth              int
+	Finally            bool
+}
+
+// IsJump reports whether op jumps to the offset of its operand.
+func IsJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNull, code.OpJumpNotNull,
+		code.OpJumpNotLess, code.OpJumpNotGreater, code.OpJumpNotEqual,
+		code.OpJumpEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// EndsBlock reports whether op can only be the last instruction of a
+// block: a jump, return or throw.
+func EndsBlock(op code.Opcode) bool {
+	return IsJump(op) || !continues(op)
+}
+
+// continues reports whether control can go on to the instruction after op.
+func continues(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpReturnValue, code.OpReturn, code.OpThrow:
+		return false
+	default:
+		return true
+	}
+}
+
+// Ended reports whether b ends in a jump, return or throw.
+func (b *Block) Ended() bool {
+	n := len(b.Instructions)
+	return n > 0 && EndsBlock(b.Instructions[n-1].Op)
+}
+
+// FallsThrough reports whether control can go from the end of b into the
+// block after it.
+func (b *Block) FallsThrough() bool {
+	n := len(b.Instructions)
+	return n == 0 || continues(b.Instructions[n-1].Op)
+}
+
+// Link numbers the blocks of f in layout order and sets their edges.
+func (f *Function) Link() {
+	for i, b := range f.Blocks {
+		b.ID = i
+		b.Succs, b.Preds = nil, nil
+	}
+
+	for i, b := range f.Blocks {
+		if n := len(b.Instructions); n > 0 && b.Instructions[n-1].Target != nil {
+			b.Succs = append(b.Succs, b.Instructions[n-1].Target)
+		}
+		if b.FallsThrough() && i+1 < len(f.Blocks) && !contains(b.Succs, f.Blocks[i+1]) {
+			b.Succs = append(b.Succs, f.Blocks[i+1])
+		}
+
+		for _, succ := range b.Succs {
+			succ.Preds = append(succ.Preds, b)
+		}
+	}
+}
+
+func contains(blocks []*Block, b *Block) bool {
+	for _, block := range blocks {
+		if block == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Simplify removes the empty blocks of f, but the last, and merges the
+// blocks control only reaches by falling through into the block before
+// them. The bytecode of f stays the same.
+func (f *Function) Simplify() {
+	referenced := f.references()
+
+	var blocks []*Block
+	var empty []*Block
+
+	for i, b := range f.Blocks {
+		if len(b.Instructions) == 0 && i+1 < len(f.Blocks) {
+			empty = append(empty, b)
+			continue
+		}
+
+		// The empty blocks before b were at its start.
+		f.redirect(empty, b, referenced)
+		empty = empty[:0]
+
+		if n := len(blocks); n > 0 && !blocks[n-1].Ended() && !referenced[b] {
+			prev := blocks[n-1]
+			prev.Instructions = append(prev.Instructions, b.Instructions...)
+			continue
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	f.Blocks = blocks
+}
+
+// references returns the blocks jumps and handlers refer to.
+func (f *Function) references() map[*Block]bool {
+	referenced := map[*Block]bool{}
+
+	for _, b := range f.Blocks {
+		for _, ins := range b.Instructions {
+			if ins.Target != nil {
+				referenced[ins.Target] = true
+			}
+		}
+	}
+
+	for _, h := range f.Handlers {
+		referenced[h.Start] = true
+		referenced[h.End] = true
+		referenced[h.Target] = true
+	}
+
+	return referenced
+}
+
+// redirect makes the jumps and handlers referring to the blocks from refer
+// to to instead, and updates the blocks referenced.
+func (f *Function) redirect(from []*Block, to *Block, referenced map[*Block]bool) {
+	moved := map[*Block]bool{}
+	for _, b := range from {
+		if referenced[b] {
+			moved[b] = true
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	for _, b := range f.Blocks {
+		for i := range b.Instructions {
+			if moved[b.Instructions[i].Target] {
+				b.Instructions[i].Target = to
+			}
+		}
+	}
+
+	for i := range f.Handlers {
+		h := &f.Handlers[i]
+		if moved[h.Start] {
+			h.Start = to
+		}
+		if moved[h.End] {
+			h.End = to
+		}
+		if moved[h.Target] {
+			h.Target = to
+		}
+	}
+
+	referenced[to] = true
+}
+
+// Copy returns a copy of f, for passes to change without changing f.
+func (f *Function) Copy() *Function {
+	copied := *f
+	copied.Blocks = make([]*Block, len(f.Blocks))
+
+	blocks := map[*Block]*Block{}
+	for i, b := range f.Blocks {
+		copied.Blocks[i] = &Block{ID: b.ID}
+		blocks[b] = copied.Blocks[i]
+	}
+
+	for i, b := range f.Blocks {
+		instructions := make([]Instruction, len(b.Instructions))
+		for j, ins := range b.Instructions {
+			ins.Operands = append([]int(nil), ins.Operands...)
+			if ins.Target != nil {
+				ins.Target = blocks[ins.Target]
+			}
+			instructions[j] = ins
+		}
+		copied.Blocks[i].Instructions = instructions
+	}
+
+	copied.Handlers = make([]Handler, len(f.Handlers))
+	for i, h := range f.Handlers {
+		h.Start, h.End, h.Target = blocks[h.Start], blocks[h.End], blocks[h.Target]
+		copied.Handlers[i] = h
+	}
+
+	copied.Link()
+	return &copied
+}
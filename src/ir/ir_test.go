@@ -0,0 +1,193 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+func TestLiftAndLower(t *testing.T) {
+	instructions := concat(
+		// 0000
+		code.MustMake(code.OpGetGlobal, 0),
+		// 0003
+		code.MustMake(code.OpJumpNotTruthy, 13),
+		// 0006
+		code.MustMake(code.OpConstant, 0),
+		// 0009
+		code.MustMake(code.OpMinus),
+		// 0010
+		code.MustMake(code.OpJump, 16),
+		// 0013
+		code.MustMake(code.OpConstant, 1),
+		// 0016
+		code.MustMake(code.OpPop),
+	)
+	sourceMap := code.SourceMap{}.
+		Add(0, token.Position{}).
+		Add(9, token.Position{Line: 1, Column: 5}).
+		Add(10, token.Position{})
+	handlers := code.HandlerTable{{Start: 6, End: 10, Target: 13, Depth: 0}}
+
+	fn, err := Lift(instructions, sourceMap, handlers)
+	if err != nil {
+		t.Fatalf("Lift failed: %s", err)
+	}
+	fn.Name = "<main>"
+
+	expected := `fn <main> (0 params, 0 locals)
+b0:
+  OpGetGlobal 0
+  OpJumpNotTruthy b3
+  -> b3 b1
+b1: <- b0
+  OpConstant 0
+  OpMinus                  ; 1:5
+  -> b2
+b2: <- b1
+  OpJump b4                ; -
+  -> b4
+b3: <- b0
+  OpConstant 1
+  -> b4
+b4: <- b2 b3
+  OpPop
+handler b1..b2 -> b3, depth 0
+`
+	if fn.String() != expected {
+		t.Errorf("function wrong.\nwant=\n%s\ngot=\n%s", expected, fn.String())
+	}
+
+	lowered, loweredMap, loweredHandlers := Lower(fn)
+	if lowered.String() != instructions.String() {
+		t.Errorf("instructions wrong.\nwant=\n%s\ngot=\n%s", instructions, lowered)
+	}
+	for offset := range instructions {
+		if loweredMap.Lookup(offset) != sourceMap.Lookup(offset) {
+			t.Errorf("position of %d wrong. want=%s, got=%s",
+				offset, sourceMap.Lookup(offset), loweredMap.Lookup(offset))
+		}
+	}
+	if len(loweredHandlers) != 1 || loweredHandlers[0] != handlers[0] {
+		t.Errorf("handlers wrong. want=%v, got=%v", handlers, loweredHandlers)
+	}
+}
+
+func TestLiftErrors(t *testing.T) {
+	tests := []struct {
+		instructions code.Instructions
+		handlers     code.HandlerTable
+		expected     string
+	}{
+		{
+			concat(code.MustMake(code.OpJump, 1), code.MustMake(code.OpNull)),
+			nil,
+			"jump or handler to 1, not an instruction",
+		},
+		{
+			code.MustMake(code.OpNull),
+			code.HandlerTable{{Start: 0, End: 1, Target: 2}},
+			"jump or handler to 2, not an instruction",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Lift(tt.instructions, nil, tt.handlers)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("error wrong. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestLowerWideJumps(t *testing.T) {
+	far := &Block{}
+	for range 70000 {
+		far.Instructions = append(far.Instructions, Instruction{Op: code.OpNull})
+	}
+	end := &Block{Instructions: []Instruction{{Op: code.OpReturn}}}
+	entry := &Block{Instructions: []Instruction{{Op: code.OpJump, Target: end}}}
+
+	fn := &Function{Constant: -1, Blocks: []*Block{entry, far, end}}
+	instructions, _, _ := Lower(fn)
+
+	// The jump is made wide, moving its target further.
+	expected, err := code.MakeWide(code.OpJump, 70006)
+	if err != nil {
+		t.Fatalf("MakeWide failed: %s", err)
+	}
+	if got := instructions[:len(expected)]; got.String() != code.Instructions(expected).String() {
+		t.Errorf("jump wrong. want=%q, got=%q", code.Instructions(expected), got)
+	}
+	if len(instructions) != 70007 {
+		t.Errorf("length wrong. want=70007, got=%d", len(instructions))
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	exit := &Block{}
+	body := &Block{Instructions: []Instruction{{Op: code.OpPop}}}
+	empty := &Block{}
+	rest := &Block{Instructions: []Instruction{{Op: code.OpNull}}}
+	entry := &Block{Instructions: []Instruction{
+		{Op: code.OpTrue},
+		{Op: code.OpJumpNotTruthy, Target: empty},
+	}}
+
+	fn := &Function{
+		Name:     "<main>",
+		Constant: -1,
+		Blocks:   []*Block{entry, body, empty, rest, exit},
+		Handlers: []Handler{{Start: body, End: empty, Target: exit}},
+	}
+	fn.Simplify()
+	fn.Link()
+
+	// The jump and the handler go to the block after the empty one, and the
+	// block control only falls into is merged into the one before.
+	expected := `fn <main> (0 params, 0 locals)
+b0:
+  OpTrue
+  OpJumpNotTruthy b2
+  -> b2 b1
+b1: <- b0
+  OpPop
+  -> b2
+b2: <- b0 b1
+  OpNull
+  -> b3
+b3: <- b2
+handler b1..b2 -> b3, depth 0
+`
+	if fn.String() != expected {
+		t.Errorf("function wrong.\nwant=\n%s\ngot=\n%s", expected, fn.String())
+	}
+
+	fn.Handlers = nil
+	fn.Simplify()
+	fn.Link()
+
+	expected = `fn <main> (0 params, 0 locals)
+b0:
+  OpTrue
+  OpJumpNotTruthy b2
+  -> b2 b1
+b1: <- b0
+  OpPop
+  -> b2
+b2: <- b0 b1
+  OpNull
+`
+	if fn.String() != expected {
+		t.Errorf("function wrong.\nwant=\n%s\ngot=\n%s", expected, fn.String())
+	}
+}
+
+func concat(s ...code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
@@ -0,0 +1,222 @@
+package ir
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+)
+
+// Lower assembles the blocks of f in their order, and returns the bytecode
+// with its source map and handler table. Each instruction takes its
+// narrowest form, which for jumps depends on where their targets end up: a
+// jump is made wide once its target is out of reach, moving the code after
+// it, until no more targets move out of reach. Handlers whose blocks are
+// empty are dropped.
+func Lower(f *Function) (code.Instructions, code.SourceMap, code.HandlerTable) {
+	wide := map[*Instruction]bool{}
+
+	var offsets map[*Block]int
+	for {
+		offsets = map[*Block]int{}
+		offset := 0
+		for _, b := range f.Blocks {
+			offsets[b] = offset
+			for i := range b.Instructions {
+				offset += len(assemble(&b.Instructions[i], 0, wide))
+			}
+		}
+
+		widened := false
+		for _, b := range f.Blocks {
+			for i := range b.Instructions {
+				ins := &b.Instructions[i]
+				if ins.Target == nil || wide[ins] {
+					continue
+				}
+
+				if _, err := code.Make(ins.Op, offsets[ins.Target]); err != nil {
+					wide[ins] = true
+					widened = true
+				}
+			}
+		}
+
+		if !widened {
+			break
+		}
+	}
+
+	instructions := code.Instructions{}
+	var sourceMap code.SourceMap
+	for _, b := range f.Blocks {
+		for i := range b.Instructions {
+			ins := &b.Instructions[i]
+			sourceMap = sourceMap.AddEntry(code.SourceMapEntry{
+				Offset:   len(instructions),
+				Pos:      ins.Pos,
+				Inlined:  ins.Inlined,
+				TailCall: ins.TailCall,
+			})
+			instructions = append(instructions, assemble(ins, offsets[ins.Target], wide)...)
+		}
+	}
+
+	var handlers code.HandlerTable
+	for _, h := range f.Handlers {
+		start, end := offsets[h.Start], offsets[h.End]
+		if start < end {
+			handlers = append(handlers, code.Handler{
+				Start:   start,
+				End:     end,
+				Target:  offsets[h.Target],
+				Depth:   h.Depth,
+				Finally: h.Finally,
+			})
+		}
+	}
+
+	return instructions, sourceMap, handlers
+}
+
+// assemble returns the bytes of ins, jumping to target if it is a jump,
+// prefixed by OpWide when an operand doesn't fit in the widths of the
+// definition of its opcode or the jump is in wide.
+func assemble(ins *Instruction, target int, wide map[*Instruction]bool) []byte {
+	operands := ins.Operands
+	if ins.Target != nil {
+		operands = []int{target}
+	}
+
+	bytes, err := code.Make(ins.Op, operands...)
+	if err == nil && !wide[ins] {
+		return bytes
+	}
+
+	bytes, wideErr := code.MakeWide(ins.Op, operands...)
+	if wideErr != nil {
+		// The compiler only emits defined opcodes, with operands that are
+		// indexes and counts of things in memory.
+		panic(fmt.Sprintf("ir: %s", wideErr))
+	}
+
+	return bytes
+}
+
+// Lift decodes the bytecode ins, with its source map and handler table,
+// into a function, for bytecode that wasn't compiled from one.
+func Lift(
+	ins code.Instructions,
+	sourceMap code.SourceMap,
+	handlers code.HandlerTable,
+) (*Function, error) {
+	type decoded struct {
+		offset   int
+		op       code.Opcode
+		operands []int
+	}
+
+	var instructions []decoded
+	// The offsets blocks start at: jump targets, the instructions after
+	// jumps, returns and throws, and the bounds of the handlers.
+	starts := map[int]bool{0: true}
+
+	for offset := 0; offset < len(ins); {
+		start := offset
+		if code.Opcode(ins[offset]) == code.OpWide && offset+1 < len(ins) {
+			offset++
+		}
+
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, err
+		}
+		if start != offset {
+			def = def.Wide()
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		op := code.Opcode(ins[offset])
+		offset += 1 + read
+
+		instructions = append(instructions, decoded{start, op, operands})
+		if IsJump(op) {
+			starts[operands[0]] = true
+		}
+		if EndsBlock(op) {
+			starts[offset] = true
+		}
+	}
+
+	for _, h := range handlers {
+		starts[h.Start] = true
+		starts[h.End] = true
+		starts[h.Target] = true
+	}
+
+	offsets := map[int]bool{len(ins): true}
+	for _, d := range instructions {
+		offsets[d.offset] = true
+	}
+
+	var sorted []int
+	for offset := range starts {
+		sorted = append(sorted, offset)
+	}
+	sort.Ints(sorted)
+
+	for _, offset := range sorted {
+		if !offsets[offset] {
+			return nil, fmt.Errorf("jump or handler to %d, not an instruction", offset)
+		}
+	}
+
+	blocks := map[int]*Block{}
+	for offset := range starts {
+		blocks[offset] = &Block{}
+	}
+
+	f := &Function{Constant: -1}
+	for _, d := range instructions {
+		if starts[d.offset] {
+			f.Blocks = append(f.Blocks, blocks[d.offset])
+		}
+
+		entry := sourceMap.Entry(d.offset)
+		instruction := Instruction{
+			Op:       d.op,
+			Pos:      entry.Pos,
+			Inlined:  entry.Inlined,
+			TailCall: entry.TailCall,
+		}
+		if IsJump(d.op) {
+			instruction.Target = blocks[d.operands[0]]
+		} else {
+			instruction.Operands = d.operands
+		}
+
+		b := f.Blocks[len(f.Blocks)-1]
+		b.Instructions = append(b.Instructions, instruction)
+	}
+
+	// The block at the end, if jumps or handlers refer to it.
+	if starts[len(ins)] || len(f.Blocks) == 0 {
+		if blocks[len(ins)] == nil {
+			blocks[len(ins)] = &Block{}
+		}
+		f.Blocks = append(f.Blocks, blocks[len(ins)])
+	}
+
+	for _, h := range handlers {
+		f.Handlers = append(f.Handlers, Handler{
+			Start:   blocks[h.Start],
+			End:     blocks[h.End],
+			Target:  blocks[h.Target],
+			Depth:   h.Depth,
+			Finally: h.Finally,
+		})
+	}
+
+	f.Link()
+	return f, nil
+}
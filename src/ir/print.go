@@ -0,0 +1,122 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// String prints f, for example:
+//
+//	fn max (constant 1, 2 params, 2 locals)
+//	b0:
+//	  OpGetLocal 0
+//	  OpGetLocal 1
+//	  OpGreaterThan           ; 1:22
+//	  OpJumpNotTruthy b2
+//	  -> b1 b2
+//	b1: <- b0
+//	  OpGetLocal 0
+//	  OpReturnValue           ; 1:1
+//	b2: <- b0
+//	  ...
+//
+// The position of the source code an instruction was compiled from, with the
+// inlined calls it is in, is printed where it changes. The edges of a block are printed after it, and
+// the blocks control comes from after its label. Run Link first for them to
+// be up to date.
+func (f *Function) String() string {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "fn %s (", f.Name)
+	if f.Constant >= 0 {
+		fmt.Fprintf(&out, "constant %d, ", f.Constant)
+	}
+	fmt.Fprintf(&out, "%d params, %d locals)\n", f.NumParameters, f.NumLocals)
+
+	location := positionString(token.Position{})
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&out, "b%d:", b.ID)
+		if len(b.Preds) > 0 {
+			fmt.Fprintf(&out, " <- %s", labels(b.Preds))
+		}
+		out.WriteString("\n")
+
+		for _, ins := range b.Instructions {
+			line := "  " + ins.String()
+			if l := ins.location(); l != location {
+				location = l
+				line = fmt.Sprintf("%-26s ; %s", line, location)
+			}
+			out.WriteString(line + "\n")
+		}
+
+		if len(b.Succs) > 0 {
+			fmt.Fprintf(&out, "  -> %s\n", labels(b.Succs))
+		}
+	}
+
+	for _, h := range f.Handlers {
+		fmt.Fprintf(&out, "handler b%d..b%d -> b%d, depth %d", h.Start.ID, h.End.ID, h.Target.ID, h.Depth)
+		if h.Finally {
+			out.WriteString(", finally")
+		}
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
+
+// String prints ins like code.Instructions does, with the label of the
+// block a jump goes to as its operand.
+func (ins Instruction) String() string {
+	def, err := code.Lookup(byte(ins.Op))
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err)
+	}
+
+	parts := []string{def.Name}
+	if ins.Target != nil {
+		parts = append(parts, fmt.Sprintf("b%d", ins.Target.ID))
+	}
+	for _, operand := range ins.Operands {
+		parts = append(parts, fmt.Sprint(operand))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// location prints the position of ins and the inlined calls around it, for
+// example "2:5 in f from 4:11", with "tail" for a call in tail position of the
+// innermost inlined body.
+func (ins Instruction) location() string {
+	parts := []string{positionString(ins.Pos)}
+	for call := ins.Inlined; call != nil; call = call.Outer {
+		parts = append(parts, fmt.Sprintf("in %s from %s", call.Function, positionString(call.Pos)))
+	}
+	if ins.TailCall {
+		parts = append(parts, "tail")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func labels(blocks []*Block) string {
+	names := make([]string, len(blocks))
+	for i, b := range blocks {
+		names[i] = fmt.Sprintf("b%d", b.ID)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func positionString(pos token.Position) string {
+	if !pos.IsValid() {
+		return "-"
+	}
+
+	return pos.String()
+}
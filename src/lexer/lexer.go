@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/ZeroBl21/go-monkey/src/token"
 )
@@ -11,6 +12,20 @@ type Lexer struct {
 	position     int  // Current position in input
 	readPosition int  // Current reading position in input (after current char)
 	ch           byte // current char under examination
+
+	line   int // Line of the current char
+	column int // Column of the current char
+
+	lastLine int       // Line of the last token returned
+	comments []Comment // Comments skipped so far, in source order
+}
+
+// A Comment is a `//` comment. Trailing comments follow a token on the same
+// line; the others stand on a line of their own.
+type Comment struct {
+	Text     string // Includes the leading "//"
+	Pos      token.Position
+	Trailing bool
 }
 
 func New(input string) *Lexer {
@@ -19,6 +34,8 @@ func New(input string) *Lexer {
 		position:     0,
 		readPosition: 0,
 		ch:           0,
+		line:         1,
+		column:       0,
 	}
 	l.readChar()
 
@@ -26,6 +43,12 @@ func New(input string) *Lexer {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -44,14 +67,28 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPosition]
 }
 
+// Comments returns the comments skipped by NextToken so far.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	l.lastLine = tok.Pos.Line
+
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
-	if l.ch == '/' && l.peekChar() == '/' {
+	for l.ch == '/' && l.peekChar() == '/' {
 		l.skipComment()
 	}
 
+	pos := token.Position{Line: l.line, Column: l.column}
+
 	switch l.ch {
 
 	case '=':
@@ -99,6 +136,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -111,6 +150,19 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COLON, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
+	case '?':
+		switch l.peekChar() {
+		case '?':
+			tok = l.newTwoCharToken(token.COALESCE)
+		case '.':
+			tok = l.newTwoCharToken(token.OPTIONAL_DOT)
+		case '[':
+			tok = l.newTwoCharToken(token.OPTIONAL_LBRACKET)
+		default:
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -132,6 +184,7 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		}
 
@@ -144,6 +197,7 @@ func (l *Lexer) NextToken() token.Token {
 				tok.Type = token.INT
 				tok.Literal = digit
 			}
+			tok.Pos = pos
 			return tok
 		}
 
@@ -151,6 +205,7 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
@@ -161,9 +216,19 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) skipComment() {
-	for l.ch != '\n' || l.ch == 0 {
+	comment := Comment{
+		Pos:      token.Position{Line: l.line, Column: l.column},
+		Trailing: l.lastLine == l.line,
+	}
+
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	comment.Text = strings.TrimRight(l.input[position:l.position], " \t\r")
+	l.comments = append(l.comments, comment)
+
 	l.skipWhitespace()
 }
 
@@ -224,6 +289,17 @@ func isDigit(ch byte) bool {
 	return ('0' <= ch && ch <= '9') || ch == '_'
 }
 
+// newTwoCharToken consumes the current and the next char as a single token.
+func (l *Lexer) newTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+
+	return token.Token{
+		Type:    tokenType,
+		Literal: string(ch) + string(l.ch),
+	}
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{
 		Type:    tokenType,
@@ -17,7 +17,7 @@ func TestNextToken(t *testing.T) {
 
 	let result = add(five, ten);
 
-	!-/*5;
+	!-/*%5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -37,6 +37,8 @@ func TestNextToken(t *testing.T) {
 	1000_
 	1_000
 	1_0_0_0
+	book.title;
+	null ?? book?.title?["x"] ?
 	`
 
 	tests := []struct {
@@ -90,6 +92,7 @@ func TestNextToken(t *testing.T) {
 		{token.MINUS, "-"},
 		{token.SLASH, "/"},
 		{token.ASTERISK, "*"},
+		{token.PERCENT, "%"},
 		{token.INT, "5"},
 		{token.SEMICOLON, ";"},
 
@@ -152,6 +155,21 @@ func TestNextToken(t *testing.T) {
 		{token.INT, "1_000"},
 		{token.INT, "1_0_0_0"},
 
+		{token.IDENT, "book"},
+		{token.DOT, "."},
+		{token.IDENT, "title"},
+		{token.SEMICOLON, ";"},
+
+		{token.NULL, "null"},
+		{token.COALESCE, "??"},
+		{token.IDENT, "book"},
+		{token.OPTIONAL_DOT, "?."},
+		{token.IDENT, "title"},
+		{token.OPTIONAL_LBRACKET, "?["},
+		{token.STRING, "x"},
+		{token.RBRACKET, "]"},
+		{token.ILLEGAL, "?"},
+
 		{token.EOF, ""},
 	}
 
@@ -171,3 +189,80 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let five = 5;
+	five == "x"
+// comment
+  ?.`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Line: 1, Column: 10}},
+		{token.INT, token.Position{Line: 1, Column: 12}},
+		{token.SEMICOLON, token.Position{Line: 1, Column: 13}},
+		{token.IDENT, token.Position{Line: 2, Column: 2}},
+		{token.EQ, token.Position{Line: 2, Column: 7}},
+		{token.STRING, token.Position{Line: 2, Column: 10}},
+		{token.OPTIONAL_DOT, token.Position{Line: 4, Column: 3}},
+		{token.EOF, token.Position{Line: 4, Column: 5}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - TokenType wrong. Expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - Pos wrong. Expected=%s, got=%s",
+				i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+// second
+let x = 1; // trailing
+x // at the end`
+
+	expected := []Comment{
+		{Text: "// leading", Pos: token.Position{Line: 1, Column: 1}},
+		{Text: "// second", Pos: token.Position{Line: 2, Column: 1}},
+		{
+			Text:     "// trailing",
+			Pos:      token.Position{Line: 3, Column: 12},
+			Trailing: true,
+		},
+		{
+			Text:     "// at the end",
+			Pos:      token.Position{Line: 4, Column: 3},
+			Trailing: true,
+		},
+	}
+
+	l := New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d (%+v)",
+			len(expected), len(comments), comments)
+	}
+
+	for i, want := range expected {
+		if comments[i] != want {
+			t.Errorf("comments[%d] wrong. want=%+v, got=%+v", i, want, comments[i])
+		}
+	}
+}
@@ -1,40 +1,91 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 
+	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/compiler"
+	"github.com/ZeroBl21/go-monkey/src/format"
+	"github.com/ZeroBl21/go-monkey/src/lexer"
+	"github.com/ZeroBl21/go-monkey/src/parser"
 	"github.com/ZeroBl21/go-monkey/src/repl"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(runFmt(os.Args[2:]))
+		case "parse":
+			os.Exit(runParse(os.Args[2:]))
+		}
+	}
+
 	fileFlag := flag.String("file", "", "Path to a file to be evaluated")
-	compileFlag := flag.Bool("compile", false, "Enable compilation mode")
+	compileFlag := flag.Bool("compile", false, "Enable compilation mode, same as -engine vm")
+	engineFlag := flag.String(
+		"engine",
+		"eval",
+		"Engine that runs the code: eval, vm (the stack VM) or register (the register VM)",
+	)
 	lexerFlag := flag.Bool("lexer", false, "Enable lexer mode to print tokens")
 	precedenceFlag := flag.Bool(
 		"precedence",
 		false,
 		"Enable precedence mode to show parsed program",
 	)
+	checkedFlag := flag.Bool(
+		"checked",
+		false,
+		"Make int64 overflow an error instead of promoting to big integers",
+	)
+	dumpIRFlag := flag.Bool(
+		"dump-ir",
+		false,
+		"Print the intermediate representation of the compiled code instead of running it",
+	)
+	optimizeFlag := flag.Int(
+		"O",
+		int(compiler.OptimizeSpecialize),
+		"Optimization level of the compiler, 0 to disable optimizations",
+	)
 	flag.Parse()
 
-	replFlagsMap := map[bool]int{
-		*compileFlag:    repl.CompileFlag,
-		*lexerFlag:      repl.LexerFlag,
-		*precedenceFlag: repl.PrecedenceFlag,
+	switch *engineFlag {
+	case "eval", "vm", "register":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine: %s\n", *engineFlag)
+		os.Exit(2)
+	}
+
+	replFlags := []struct {
+		condition bool
+		flag      int
+	}{
+		{*compileFlag || *engineFlag == "vm", repl.CompileFlag},
+		{*engineFlag == "register", repl.RegisterFlag},
+		{*lexerFlag, repl.LexerFlag},
+		{*precedenceFlag, repl.PrecedenceFlag},
+		{*checkedFlag, repl.CheckedFlag},
+		{*dumpIRFlag, repl.DumpIRFlag},
 	}
 
 	flags := 0
-	for condition, flag := range replFlagsMap {
-		if condition {
-			flags |= flag
+	for _, f := range replFlags {
+		if f.condition {
+			flags |= f.flag
 		}
 	}
 
 	replInstance := repl.New(os.Stdin, os.Stdout)
 	replInstance.SetFlags(flags)
+	replInstance.SetOptimizationLevel(compiler.OptimizationLevel(*optimizeFlag))
 
 	if *fileFlag != "" {
 		data, err := os.ReadFile(*fileFlag)
@@ -43,9 +94,12 @@ func main() {
 			return
 		}
 
-		if *compileFlag {
+		switch {
+		case flags&repl.DumpIRFlag != 0:
+			replInstance.DumpIR(string(data))
+		case flags&(repl.CompileFlag|repl.RegisterFlag) != 0:
 			replInstance.EvaluateLineCompiled(string(data))
-		} else {
+		default:
 			replInstance.EvaluateLine(string(data))
 		}
 
@@ -65,3 +119,130 @@ func main() {
 
 	replInstance.Start()
 }
+
+// runFmt implements `monkey fmt [-check] [-w] [files...]` and returns the
+// exit status. Without files it formats standard input.
+func runFmt(args []string) int {
+	fmtFlags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	checkFlag := fmtFlags.Bool(
+		"check",
+		false,
+		"List files that are not formatted and exit with status 1",
+	)
+	writeFlag := fmtFlags.Bool("w", false, "Write the result back to the files")
+	fmtFlags.Parse(args)
+
+	if fmtFlags.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading stdin:", err)
+			return 2
+		}
+
+		formatted, err := format.Source(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %s\n", err)
+			return 2
+		}
+
+		if *checkFlag {
+			if !bytes.Equal(data, formatted) {
+				fmt.Println("<stdin>")
+				return 1
+			}
+			return 0
+		}
+
+		os.Stdout.Write(formatted)
+		return 0
+	}
+
+	status := 0
+	for _, path := range fmtFlags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading file:", err)
+			status = 2
+			continue
+		}
+
+		formatted, err := format.Source(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 2
+			continue
+		}
+
+		switch {
+		case *checkFlag:
+			if !bytes.Equal(data, formatted) {
+				fmt.Println(path)
+				status = max(status, 1)
+			}
+
+		case *writeFlag:
+			if bytes.Equal(data, formatted) {
+				continue
+			}
+			if err := os.WriteFile(path, formatted, 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing file:", err)
+				status = 2
+			}
+
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+
+	return status
+}
+
+// runParse implements `monkey parse [-json] [file]` and returns the exit
+// status. It prints the parsed program, as JSON with -json.
+func runParse(args []string) int {
+	parseFlags := flag.NewFlagSet("parse", flag.ExitOnError)
+	jsonFlag := parseFlags.Bool("json", false, "Print the syntax tree as JSON")
+	parseFlags.Parse(args)
+
+	var data []byte
+	var err error
+	if parseFlags.NArg() == 0 {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(parseFlags.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading file:", err)
+		return 2
+	}
+
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		return 1
+	}
+	for _, msg := range p.Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", msg)
+	}
+
+	if !*jsonFlag {
+		fmt.Println(program.String())
+		return 0
+	}
+
+	encoded, err := ast.Marshal(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding program:", err)
+		return 2
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, encoded, "", "  ")
+	out.WriteByte('\n')
+	out.WriteTo(os.Stdout)
+
+	return 0
+}
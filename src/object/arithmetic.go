@@ -0,0 +1,160 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// NewInteger returns value as an Integer when it fits in an int64 and as a
+// BigInt otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+
+	return &BigInt{Value: value}
+}
+
+// IntegerOperation applies an arithmetic operator, one of + - * / %, to two
+// integers. fits reports whether the result fits in an int64; when it
+// doesn't, the result is a BigInt. Like the Go operators, / and % truncate
+// towards zero and panic when right is zero.
+func IntegerOperation(operator string, left, right Object) (result Object, fits bool) {
+	a, aOk := left.(*Integer)
+	b, bOk := right.(*Integer)
+
+	if aOk && bOk {
+		var value int64
+		var ok bool
+
+		switch operator {
+		case "+":
+			value, ok = AddInt64(a.Value, b.Value)
+		case "-":
+			value, ok = SubInt64(a.Value, b.Value)
+		case "*":
+			value, ok = MulInt64(a.Value, b.Value)
+		case "/":
+			value, ok = DivInt64(a.Value, b.Value)
+		case "%":
+			value, ok = a.Value%b.Value, true
+		default:
+			panic(fmt.Sprintf("unknown integer operator %s", operator))
+		}
+
+		if ok {
+			return &Integer{Value: value}, true
+		}
+	}
+
+	x, y := bigValue(left), bigValue(right)
+	value := new(big.Int)
+
+	switch operator {
+	case "+":
+		value.Add(x, y)
+	case "-":
+		value.Sub(x, y)
+	case "*":
+		value.Mul(x, y)
+	case "/":
+		value.Quo(x, y)
+	case "%":
+		value.Rem(x, y)
+	default:
+		panic(fmt.Sprintf("unknown integer operator %s", operator))
+	}
+
+	return NewInteger(value), value.IsInt64()
+}
+
+// NegateInteger returns -value, reporting whether it fits in an int64.
+func NegateInteger(value Object) (Object, bool) {
+	if i, ok := value.(*Integer); ok {
+		if result, ok := NegInt64(i.Value); ok {
+			return &Integer{Value: result}, true
+		}
+	}
+
+	result := new(big.Int).Neg(bigValue(value))
+	return NewInteger(result), result.IsInt64()
+}
+
+// CompareIntegers returns -1, 0 or +1 when a is less than, equal to or
+// greater than b.
+func CompareIntegers(a, b Object) int {
+	x, xOk := a.(*Integer)
+	y, yOk := b.(*Integer)
+
+	if xOk && yOk {
+		switch {
+		case x.Value < y.Value:
+			return -1
+		case x.Value > y.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return bigValue(a).Cmp(bigValue(b))
+}
+
+// IsZero reports whether value is the integer 0.
+func IsZero(value Object) bool {
+	switch value := value.(type) {
+	case *Integer:
+		return value.Value == 0
+	case *BigInt:
+		return value.Value.Sign() == 0
+	default:
+		return false
+	}
+}
+
+func bigValue(value Object) *big.Int {
+	switch value := value.(type) {
+	case *Integer:
+		return big.NewInt(value.Value)
+	case *BigInt:
+		return value.Value
+	default:
+		panic(fmt.Sprintf("%s is not an integer", value.Type()))
+	}
+}
+
+// The functions below do integer arithmetic and report whether the result
+// fits in an int64. When it doesn't, the result wraps around.
+
+func AddInt64(a, b int64) (int64, bool) {
+	result := a + b
+	return result, (result > a) == (b > 0)
+}
+
+func SubInt64(a, b int64) (int64, bool) {
+	result := a - b
+	return result, (result < a) == (b > 0)
+}
+
+func MulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+
+	result := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return result, false
+	}
+
+	return result, result/b == a
+}
+
+// DivInt64 panics when b is 0, like the / operator.
+func DivInt64(a, b int64) (int64, bool) {
+	return a / b, !(a == math.MinInt64 && b == -1)
+}
+
+func NegInt64(a int64) (int64, bool) {
+	return -a, a != math.MinInt64
+}
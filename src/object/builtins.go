@@ -30,7 +30,7 @@ func init() {
 func RegisterBuiltin(name string, fn BuiltinFunction) {
 	Builtins = append(Builtins, BuiltinsFns{
 		Name:    name,
-		Builtin: &Builtin{Fn: fn},
+		Builtin: &Builtin{Name: name, Fn: fn},
 	})
 }
 
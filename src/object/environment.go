@@ -1,8 +1,22 @@
 package object
 
+import "github.com/ZeroBl21/go-monkey/src/token"
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	call  *call
+
+	checkedArithmetic bool
+}
+
+// call is the function call that created an environment. The calls link the
+// environments of the active functions into the call stack.
+type call struct {
+	function string
+	numArgs  int
+	pos      token.Position // The position of the call in the caller
+	caller   *Environment
 }
 
 func NewEnvironment() *Environment {
@@ -19,6 +33,48 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewCallEnvironment returns the environment for a call to the function named
+// name, enclosed by the environment of the function. The call was made at pos
+// by the code running in caller.
+func NewCallEnvironment(
+	outer, caller *Environment,
+	name string,
+	numArgs int,
+	pos token.Position,
+) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.call = &call{
+		function: name,
+		numArgs:  numArgs,
+		pos:      pos,
+		caller:   caller,
+	}
+
+	return env
+}
+
+// StackTrace returns the call stack of the code running in e, where pos is the
+// position reached in the innermost function.
+func (e *Environment) StackTrace(pos token.Position) StackTrace {
+	var trace StackTrace
+
+	for ; e != nil && e.call != nil; e = e.call.caller {
+		name := e.call.function
+		if name == "" {
+			name = AnonymousFunction
+		}
+
+		trace = append(trace, StackFrame{
+			Function: name,
+			Pos:      pos,
+			NumArgs:  e.call.numArgs,
+		})
+		pos = e.call.pos
+	}
+
+	return append(trace, StackFrame{Function: MainFunction, Pos: pos})
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -31,3 +87,20 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// SetCheckedArithmetic makes integer results that don't fit in an int64 an
+// error instead of promoting them to BigInts, for all the code that runs in e
+// and the environments enclosed by it.
+func (e *Environment) SetCheckedArithmetic(checked bool) {
+	e.checkedArithmetic = checked
+}
+
+func (e *Environment) CheckedArithmetic() bool {
+	for ; e != nil; e = e.outer {
+		if e.checkedArithmetic {
+			return true
+		}
+	}
+
+	return false
+}
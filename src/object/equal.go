@@ -0,0 +1,57 @@
+package object
+
+// Equal reports whether a and b are equal, as the == operator sees them.
+// Integers, whether Integer or BigInt, strings, booleans and nulls are
+// compared by value, arrays and hashes element by element, and everything
+// else by identity. Objects of different types are never equal.
+func Equal(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer, *BigInt:
+		return CompareIntegers(a, b) == 0
+
+	case *String:
+		return a.Value == b.(*String).Value
+
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+
+	case *Null:
+		return true
+
+	case *Array:
+		b := b.(*Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+
+		for i, element := range a.Elements {
+			if !Equal(element, b.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+
+	case *Hash:
+		b := b.(*Hash)
+		if a.Len() != b.Len() {
+			return false
+		}
+
+		for _, pair := range a.Pairs() {
+			value, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return a == b
+	}
+}
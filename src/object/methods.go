@@ -0,0 +1,78 @@
+package object
+
+import "strings"
+
+// The built-in methods of every object type are stored here, keyed by the
+// receiver type and then by method name.
+var Methods = map[ObjectType]map[string]*Builtin{}
+
+// init registers the built-in methods of the core types.
+func init() {
+	RegisterMethod(STRING_OBJ, "len", _lenFn)
+	RegisterMethod(STRING_OBJ, "upper", _upperFn)
+	RegisterMethod(STRING_OBJ, "lower", _lowerFn)
+
+	RegisterMethod(ARRAY_OBJ, "len", _lenFn)
+	RegisterMethod(ARRAY_OBJ, "first", _firstFn)
+	RegisterMethod(ARRAY_OBJ, "last", _lastFn)
+	RegisterMethod(ARRAY_OBJ, "rest", _restFn)
+	RegisterMethod(ARRAY_OBJ, "push", _pushFn)
+}
+
+// RegisterMethod registers a built-in method for the given type. The receiver
+// is passed to fn as its first argument.
+func RegisterMethod(t ObjectType, name string, fn BuiltinFunction) {
+	if Methods[t] == nil {
+		Methods[t] = map[string]*Builtin{}
+	}
+
+	Methods[t][name] = &Builtin{Name: name, Fn: fn}
+}
+
+func LookupMethod(t ObjectType, name string) (*Builtin, bool) {
+	method, ok := Methods[t][name]
+	return method, ok
+}
+
+// GetMember resolves `obj.name`. Hashes are searched for a string key first,
+// then the method table of the object type is consulted.
+func GetMember(obj Object, name string) (Object, bool) {
+	if hash, ok := obj.(*Hash); ok {
+		if value, ok := hash.Get(&String{Value: name}); ok {
+			return value, true
+		}
+	}
+
+	method, ok := LookupMethod(obj.Type(), name)
+	if !ok {
+		return nil, false
+	}
+
+	return &BoundMethod{Name: name, Receiver: obj, Method: method}, true
+}
+
+// Internal Methods
+
+func _upperFn(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type() != STRING_OBJ {
+		return newError("argument to `upper` must be STRING, got=%s",
+			args[0].Type())
+	}
+
+	return &String{Value: strings.ToUpper(args[0].(*String).Value)}
+}
+
+func _lowerFn(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type() != STRING_OBJ {
+		return newError("argument to `lower` must be STRING, got=%s",
+			args[0].Type())
+	}
+
+	return &String{Value: strings.ToLower(args[0].(*String).Value)}
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math/big"
 	"strings"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/token"
 )
 
 type ObjectType string
@@ -20,11 +22,13 @@ const (
 	BOOLEAN_OBJ           ObjectType = "BOOLEAN"
 	FUNCTION_OBJ          ObjectType = "FUNCTION"
 	BUILTIN_OBJ           ObjectType = "BUILTIN"
-	CLOSURE_OBJ           ObjectType = "CLOSURE"
+	BOUND_METHOD_OBJ      ObjectType = "BOUND_METHOD"
 	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
 	RETURN_VALUE_OBJ      ObjectType = "RETURN_VALUE"
 	ARRAY_OBJ             ObjectType = "ARRAY"
 	HASH_OBJ              ObjectType = "HASH"
+	QUOTE_OBJ             ObjectType = "QUOTE"
+	MACRO_OBJ             ObjectType = "MACRO"
 )
 
 type Object interface {
@@ -38,6 +42,7 @@ type HashKey struct {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -61,6 +66,27 @@ func (o *Integer) HashKey() HashKey {
 	}
 }
 
+// BigInt is an integer that doesn't fit in an int64. Arithmetic promotes
+// Integers to BigInts when a result overflows and demotes the results that
+// fit back to Integers, so a BigInt never holds the value of an Integer. Both
+// have the INTEGER type.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (o *BigInt) Type() ObjectType { return INTEGER_OBJ }
+func (o *BigInt) Inspect() string  { return o.Value.String() }
+func (o *BigInt) HashKey() HashKey {
+	if o.Value.IsInt64() {
+		return (&Integer{Value: o.Value.Int64()}).HashKey()
+	}
+
+	return HashKey{
+		Type:  o.Type(),
+		Value: hashString(o.Value.String()),
+	}
+}
+
 type String struct {
 	Value string
 }
@@ -68,15 +94,21 @@ type String struct {
 func (o *String) Type() ObjectType { return STRING_OBJ }
 func (o *String) Inspect() string  { return o.Value }
 func (o *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(o.Value))
-
 	return HashKey{
 		Type:  o.Type(),
-		Value: h.Sum64(),
+		Value: hashString(o.Value),
 	}
 }
 
+// hashString hashes the value of strings. Tests replace it to force
+// collisions.
+var hashString = func(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	return h.Sum64()
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -105,14 +137,26 @@ type ReturnValue struct {
 func (o *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (o *ReturnValue) Inspect() string  { return o.Value.Inspect() }
 
+// Error is a runtime error. Pos is the position of the code that raised it,
+// when known.
 type Error struct {
 	Message string
+	Pos     token.Position
+	Trace   StackTrace // The call stack where the error was raised, if known
+	Value   Object     // The thrown value, for errors raised by throw
 }
 
 func (o *Error) Type() ObjectType { return ERROR_OBJ }
-func (o *Error) Inspect() string  { return "ERROR: " + o.Message }
+func (o *Error) Inspect() string {
+	if o.Pos.IsValid() {
+		return fmt.Sprintf("ERROR: %s: %s", o.Pos, o.Message)
+	}
+
+	return "ERROR: " + o.Message
+}
 
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -140,26 +184,52 @@ func (f *Function) Inspect() string {
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	Fn   BuiltinFunction
 }
 
 func (o *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (o *Builtin) Inspect() string  { return "builtin function" }
 
+// BoundMethod is a built-in method together with the receiver it was looked
+// up on. Calling it passes the receiver as the first argument.
+type BoundMethod struct {
+	Name     string
+	Receiver Object
+	Method   *Builtin
+}
+
+func (o *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+func (o *BoundMethod) Inspect() string {
+	return fmt.Sprintf("builtin method %s.%s", o.Receiver.Type(), o.Name)
+}
+
+// Call invokes the method with the receiver prepended to args.
+func (o *BoundMethod) Call(args ...Object) Object {
+	return o.Method.Fn(append([]Object{o.Receiver}, args...)...)
+}
+
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
 }
 
-func (o *Closure) Type() ObjectType { return CLOSURE_OBJ }
+// Type is the type of functions, as in the evaluator: a closure is how the
+// VMs represent a function value.
+func (o *Closure) Type() ObjectType { return FUNCTION_OBJ }
 func (o *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", o)
 }
 
 type CompiledFunction struct {
+	Name          string
 	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	Handlers      code.HandlerTable
 	NumLocals     int
 	NumParameters int
+	// The most values the function has on the stack above its locals.
+	MaxStackDepth int
 }
 
 func (o *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -192,16 +262,76 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in insertion order and indexes them by HashKey, so
+// lookups take constant time while printing and iteration are deterministic.
+// Different keys can share a HashKey, so every bucket of the index holds the
+// positions of all the pairs with that HashKey and the keys themselves are
+// compared with Equal to find the right one. The zero value is an empty hash
+// ready to use.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs []HashPair
+	index map[HashKey][]int
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs: make([]HashPair, 0, size),
+		index: make(map[HashKey][]int, size),
+	}
+}
+
+// Set stores value under key. Setting an existing key replaces its value but
+// keeps its original position.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+
+	if i, ok := h.find(hashKey, key); ok {
+		h.pairs[i].Value = value
+		return
+	}
+
+	if h.index == nil {
+		h.index = map[HashKey][]int{}
+	}
+
+	h.index[hashKey] = append(h.index[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Get returns the value stored under key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.find(key.HashKey(), key)
+	if !ok {
+		return nil, false
+	}
+
+	return h.pairs[i].Value, true
+}
+
+// find returns the position of the pair whose key equals key.
+func (h *Hash) find(hashKey HashKey, key Hashable) (int, bool) {
+	for _, i := range h.index[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			return i, true
+		}
+	}
+
+	return 0, false
 }
 
+// Len returns the number of pairs in the hash.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in insertion order. The slice must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -212,3 +342,38 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+// Quote wraps an unevaluated AST node, as produced by `quote(expr)`.
+type Quote struct {
+	Node ast.Node
+}
+
+func (o *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (o *Quote) Inspect() string {
+	return "QUOTE(" + o.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
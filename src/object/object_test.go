@@ -1,6 +1,13 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +27,280 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have different hash keys")
 	}
 }
+
+func TestHashKeepsInsertionOrder(t *testing.T) {
+	var hash Hash
+
+	hash.Set(&String{Value: "b"}, &Integer{Value: 1})
+	hash.Set(&Integer{Value: 1}, &Integer{Value: 2})
+	hash.Set(&Boolean{Value: true}, &Integer{Value: 3})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 4})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 5})
+
+	expected := "{b: 5, 1: 2, true: 3, a: 4}"
+	for i := 0; i < 10; i++ {
+		if hash.Inspect() != expected {
+			t.Fatalf("wrong Inspect. want=%q, got=%q", expected, hash.Inspect())
+		}
+	}
+
+	if hash.Len() != 4 {
+		t.Errorf("wrong Len. want=4, got=%d", hash.Len())
+	}
+
+	keys := []string{}
+	for _, pair := range hash.Pairs() {
+		keys = append(keys, pair.Key.Inspect())
+	}
+	if strings.Join(keys, " ") != "b 1 true a" {
+		t.Errorf("wrong key order. got=%q", keys)
+	}
+
+	value, ok := hash.Get(&String{Value: "b"})
+	if !ok || value.(*Integer).Value != 5 {
+		t.Errorf("wrong value for b. got=%v", value)
+	}
+
+	if _, ok := hash.Get(&String{Value: "c"}); ok {
+		t.Errorf("found a value for a missing key")
+	}
+
+	if _, ok := NewHash(0).Get(&Integer{Value: 1}); ok {
+		t.Errorf("found a value in an empty hash")
+	}
+}
+
+func TestHashKeepsCollidingKeysApart(t *testing.T) {
+	defer func(original func(string) uint64) { hashString = original }(hashString)
+	hashString = func(string) uint64 { return 42 }
+
+	hash := NewHash(0)
+	hash.Set(&String{Value: "alice"}, &Integer{Value: 1})
+	hash.Set(&String{Value: "bob"}, &Integer{Value: 2})
+	hash.Set(&Integer{Value: 42}, &Integer{Value: 3})
+	hash.Set(&String{Value: "alice"}, &Integer{Value: 4})
+
+	if (&String{Value: "alice"}).HashKey() != (&String{Value: "bob"}).HashKey() {
+		t.Fatalf("the injected hash function wasn't used")
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{&String{Value: "alice"}, 4},
+		{&String{Value: "bob"}, 2},
+		{&Integer{Value: 42}, 3},
+	}
+
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if !ok {
+			t.Errorf("no value for %s", tt.key.Inspect())
+			continue
+		}
+		if value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value for %s. want=%d, got=%s",
+				tt.key.Inspect(), tt.expected, value.Inspect())
+		}
+	}
+
+	if _, ok := hash.Get(&String{Value: "carol"}); ok {
+		t.Errorf("found a value for a missing key that collides")
+	}
+
+	if hash.Len() != 3 || hash.Inspect() != "{alice: 4, bob: 2, 42: 3}" {
+		t.Errorf("wrong pairs. got=%s", hash.Inspect())
+	}
+}
+
+func TestEqual(t *testing.T) {
+	one := &Integer{Value: 1}
+	builtin := &Builtin{}
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash(len(pairs) / 2)
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable), pairs[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, &Integer{Value: 2}, false},
+		{one, &Boolean{Value: true}, false},
+		{one, &String{Value: "1"}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Boolean{Value: false}, &Boolean{Value: false}, true},
+		{&Null{}, &Null{}, true},
+		{&Array{Elements: []Object{one}}, &Array{Elements: []Object{one}}, true},
+		{&Array{Elements: []Object{one}}, &Array{Elements: []Object{}}, false},
+		{&Array{Elements: []Object{one}}, one, false},
+		{
+			hash(&String{Value: "a"}, one, one, &String{Value: "b"}),
+			hash(one, &String{Value: "b"}, &String{Value: "a"}, one),
+			true,
+		},
+		{hash(&String{Value: "a"}, one), hash(&String{Value: "b"}, one), false},
+		{hash(), hash(one, one), false},
+		{builtin, builtin, true},
+		{builtin, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("test %d: Equal(%s, %s) wrong. want=%t, got=%t",
+				i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	const (
+		max = math.MaxInt64
+		min = math.MinInt64
+	)
+
+	tests := []struct {
+		name     string
+		fn       func(a, b int64) (int64, bool)
+		a, b     int64
+		expected int64
+		ok       bool
+	}{
+		{"add", AddInt64, 1, 2, 3, true},
+		{"add", AddInt64, max, 0, max, true},
+		{"add", AddInt64, max, 1, min, false},
+		{"add", AddInt64, min, -1, max, false},
+		{"add", AddInt64, min, max, -1, true},
+		{"sub", SubInt64, 1, 2, -1, true},
+		{"sub", SubInt64, min, 1, max, false},
+		{"sub", SubInt64, max, -1, min, false},
+		{"sub", SubInt64, -1, max, min, true},
+		{"mul", MulInt64, 6, -7, -42, true},
+		{"mul", MulInt64, 0, min, 0, true},
+		{"mul", MulInt64, -1 << 32, 1 << 31, min, true},
+		{"mul", MulInt64, 1 << 32, 1 << 32, 0, false},
+		{"mul", MulInt64, -1, min, min, false},
+		{"mul", MulInt64, min, -1, min, false},
+		{"div", DivInt64, 7, -2, -3, true},
+		{"div", DivInt64, min, -1, min, false},
+	}
+
+	for _, tt := range tests {
+		result, ok := tt.fn(tt.a, tt.b)
+		if result != tt.expected || ok != tt.ok {
+			t.Errorf("%s(%d, %d) wrong. want=(%d, %t), got=(%d, %t)",
+				tt.name, tt.a, tt.b, tt.expected, tt.ok, result, ok)
+		}
+	}
+
+	if result, ok := NegInt64(min); result != min || ok {
+		t.Errorf("NegInt64(min) wrong. got=(%d, %t)", result, ok)
+	}
+	if result, ok := NegInt64(max); result != -max || !ok {
+		t.Errorf("NegInt64(max) wrong. got=(%d, %t)", result, ok)
+	}
+}
+
+func TestBigInt(t *testing.T) {
+	big64 := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	if got := NewInteger(big.NewInt(-5)); got.(*Integer).Value != -5 {
+		t.Errorf("NewInteger didn't demote. got=%#v", got)
+	}
+
+	huge, ok := NewInteger(big64).(*BigInt)
+	if !ok || huge.Inspect() != "18446744073709551616" {
+		t.Fatalf("NewInteger didn't promote. got=%#v", huge)
+	}
+
+	// Not normalized, but still the same value as the Integer.
+	small := &BigInt{Value: big.NewInt(42)}
+	if small.HashKey() != (&Integer{Value: 42}).HashKey() {
+		t.Errorf("equal Integer and BigInt have different hash keys")
+	}
+	if !Equal(small, &Integer{Value: 42}) || !Equal(&Integer{Value: 42}, small) {
+		t.Errorf("equal Integer and BigInt are not Equal")
+	}
+	if small.Inspect() != (&Integer{Value: 42}).Inspect() {
+		t.Errorf("equal Integer and BigInt print differently")
+	}
+	if Equal(huge, &Integer{Value: 0}) || Equal(huge, &String{Value: huge.Inspect()}) {
+		t.Errorf("different values are Equal")
+	}
+
+	hash := NewHash(0)
+	hash.Set(small, &String{Value: "small"})
+	if value, ok := hash.Get(&Integer{Value: 42}); !ok || value.Inspect() != "small" {
+		t.Errorf("an Integer didn't find the pair of an equal BigInt")
+	}
+
+	tests := []struct {
+		operator string
+		left     Object
+		right    Object
+		expected string
+		fits     bool
+	}{
+		{"+", &Integer{Value: math.MaxInt64}, &Integer{Value: 1}, "9223372036854775808", false},
+		{"-", huge, huge, "0", true},
+		{"*", huge, &Integer{Value: -1}, "-18446744073709551616", false},
+		{"/", huge, &Integer{Value: 1 << 33}, "2147483648", true},
+		{"%", huge, &Integer{Value: 7}, "2", true},
+		{"/", &Integer{Value: -7}, &Integer{Value: 2}, "-3", true},
+	}
+
+	for _, tt := range tests {
+		result, fits := IntegerOperation(tt.operator, tt.left, tt.right)
+		if result.Inspect() != tt.expected || fits != tt.fits {
+			t.Errorf("%s %s %s wrong. want=(%s, %t), got=(%s, %t)",
+				tt.left.Inspect(), tt.operator, tt.right.Inspect(),
+				tt.expected, tt.fits, result.Inspect(), fits)
+		}
+
+		if _, isBig := result.(*BigInt); isBig == fits {
+			t.Errorf("%s %s %s: result not normalized. got=%T",
+				tt.left.Inspect(), tt.operator, tt.right.Inspect(), result)
+		}
+	}
+
+	if CompareIntegers(huge, &Integer{Value: math.MaxInt64}) != 1 {
+		t.Errorf("huge isn't greater than MaxInt64")
+	}
+}
+
+func TestStackTraceString(t *testing.T) {
+	trace := StackTrace{
+		{Function: "check", Pos: token.Position{Line: 2, Column: 5}, NumArgs: 2},
+		{Function: AnonymousFunction, NumArgs: 1},
+		{Function: MainFunction, Pos: token.Position{Line: 4, Column: 1}},
+	}
+
+	expected := "  at check (2:5, 2 args)\n" +
+		"  at <anonymous> (1 arg)\n" +
+		"  at <main> (4:1)\n"
+	if got := trace.String(); got != expected {
+		t.Errorf("wrong trace.\nwant=%q\ngot= %q", expected, got)
+	}
+
+	deep := make(StackTrace, 100)
+	for i := range deep {
+		deep[i] = StackFrame{Function: "f", NumArgs: i}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(deep.String(), "\n"), "\n")
+	if len(lines) != maxTraceFrames+1 {
+		t.Fatalf("wrong number of lines. want=%d, got=%d",
+			maxTraceFrames+1, len(lines))
+	}
+	if lines[10] != "  ... 80 frames elided ..." {
+		t.Errorf("wrong elision line. got=%q", lines[10])
+	}
+	if lines[11] != "  at f (90 args)" {
+		t.Errorf("wrong frame after elision. got=%q", lines[11])
+	}
+}
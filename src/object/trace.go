@@ -0,0 +1,93 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// Names used in stack traces for code that isn't in a named function.
+const (
+	MainFunction      = "<main>"
+	AnonymousFunction = "<anonymous>"
+)
+
+// maxTraceFrames is the number of frames printed by StackTrace.String before
+// the middle of a deep stack is elided.
+const maxTraceFrames = 20
+
+// StackFrame is one active call when a runtime error is raised. Pos is the
+// position reached in the function: where the error was raised in the
+// innermost frame and the call to the next frame in the others.
+type StackFrame struct {
+	Function string
+	Pos      token.Position
+	NumArgs  int
+}
+
+func (f StackFrame) String() string {
+	if f.Function == MainFunction {
+		if f.Pos.IsValid() {
+			return fmt.Sprintf("%s (%s)", f.Function, f.Pos)
+		}
+		return f.Function
+	}
+
+	args := "args"
+	if f.NumArgs == 1 {
+		args = "arg"
+	}
+
+	if f.Pos.IsValid() {
+		return fmt.Sprintf("%s (%s, %d %s)", f.Function, f.Pos, f.NumArgs, args)
+	}
+	return fmt.Sprintf("%s (%d %s)", f.Function, f.NumArgs, args)
+}
+
+// StackTrace is the call stack of a runtime error, innermost frame first.
+type StackTrace []StackFrame
+
+// String prints one frame per line, eliding the middle of deep stacks.
+func (t StackTrace) String() string {
+	var out strings.Builder
+
+	for i, frame := range t {
+		if len(t) > maxTraceFrames && i == maxTraceFrames/2 {
+			fmt.Fprintf(&out, "  ... %d frames elided ...\n",
+				len(t)-maxTraceFrames)
+		}
+		if len(t) > maxTraceFrames &&
+			i >= maxTraceFrames/2 && i < len(t)-maxTraceFrames/2 {
+			continue
+		}
+
+		fmt.Fprintf(&out, "  at %s\n", frame)
+	}
+
+	return out.String()
+}
+
+// ErrorValue is the value a catch clause binds for a runtime error: a hash
+// with its message and the frames of its trace as strings.
+func ErrorValue(message string, trace StackTrace) *Hash {
+	frames := make([]Object, len(trace))
+	for i, frame := range trace {
+		frames[i] = &String{Value: frame.String()}
+	}
+
+	hash := NewHash(2)
+	hash.Set(&String{Value: "message"}, &String{Value: message})
+	hash.Set(&String{Value: "trace"}, &Array{Elements: frames})
+
+	return hash
+}
+
+// Caught returns the value a catch clause binds for e.
+func (e *Error) Caught() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+
+	return ErrorValue(e.Message, e.Trace)
+}
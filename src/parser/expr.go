@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
@@ -13,6 +14,7 @@ type BindingPower int
 const (
 	_ BindingPower = iota
 	LOWEST
+	COALESCE     // ??
 	EQUALS       // ==
 	LESS_GREATER // > or <
 	SUM          // +
@@ -31,8 +33,24 @@ var precedences = map[token.TokenType]BindingPower{
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
+
+	token.COALESCE:          COALESCE,
+	token.OPTIONAL_DOT:      INDEX,
+	token.OPTIONAL_LBRACKET: INDEX,
+}
+
+// Precedence returns the binding power of t when it is used as an infix or
+// postfix operator, or LOWEST if it isn't one.
+func Precedence(t token.TokenType) BindingPower {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+
+	return LOWEST
 }
 
 func (p *Parser) parseExpression(precedence BindingPower) ast.Expression {
@@ -71,17 +89,27 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
+	if err == nil {
+		lit.Value = value
+		return lit
+	}
+
+	bigValue, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
 
-	lit.Value = value
+	lit.Big = bigValue
 
 	return lit
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -94,20 +122,26 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
-	return &ast.ArrayLiteral{
+	array := &ast.ArrayLiteral{
 		Token:    p.curToken,
-		Elements: p.parseExpressionList(token.RBRACKET),
+		Elements: nil,
 	}
+
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+
+	return array
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{
 		Token: p.curToken,
-		Pairs: map[ast.Expression]ast.Expression{},
+		Pairs: []ast.HashPair{},
 	}
+	seen := map[string]bool{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		keyPos := p.curToken.Pos
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(token.COLON) {
@@ -117,7 +151,14 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if id, ok := literalKey(key); ok {
+			if seen[id] {
+				p.duplicateKeyWarning(keyPos, key)
+			}
+			seen[id] = true
+		}
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -131,6 +172,37 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// literalKey identifies a literal hash key by its type and value, so that
+// 1 and "1" are different keys.
+func literalKey(key ast.Expression) (string, bool) {
+	switch key := key.(type) {
+	case *ast.StringLiteral:
+		return fmt.Sprintf("string:%s", key.Value), true
+	case *ast.IntegerLiteral:
+		if key.Big != nil {
+			return "integer:" + key.Big.String(), true
+		}
+		return fmt.Sprintf("integer:%d", key.Value), true
+	case *ast.Boolean:
+		return fmt.Sprintf("boolean:%t", key.Value), true
+	case *ast.NullLiteral:
+		return "null", true
+	default:
+		return "", false
+	}
+}
+
+func (p *Parser) duplicateKeyWarning(pos token.Position, key ast.Expression) {
+	literal := key.String()
+	if str, ok := key.(*ast.StringLiteral); ok {
+		literal = strconv.Quote(str.Value)
+	}
+
+	msg := fmt.Sprintf("%s: duplicate key %s in hash literal",
+		pos, literal)
+	p.warnings = append(p.warnings, msg)
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	fn := &ast.FunctionLiteral{
 		Token:      p.curToken,
@@ -153,6 +225,28 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return fn
 }
 
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	macro := &ast.MacroLiteral{
+		Token:      p.curToken,
+		Parameters: []*ast.Identifier{},
+		Body:       &ast.BlockStatement{},
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	macro.Parameters = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	macro.Body = p.parseBlockStatement()
+
+	return macro
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	identifiers := []*ast.Identifier{}
 
@@ -264,10 +358,72 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseThrowExpression() ast.Expression {
+	exp := &ast.ThrowExpression{Token: p.curToken}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+
+	return exp
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	exp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		exp.Parameter = &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		exp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		exp.Finally = p.parseBlockStatement()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.errors = append(p.errors, "expected catch or finally after try block")
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{
-		Token: p.curToken,
-		Left:  left,
+		Token:    p.curToken,
+		Left:     left,
+		Optional: p.curTokenIs(token.OPTIONAL_LBRACKET),
 	}
 
 	p.nextToken()
@@ -280,12 +436,35 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{
+		Token:    p.curToken,
+		Object:   object,
+		Optional: p.curTokenIs(token.OPTIONAL_DOT),
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	exp.Property = &ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+
+	return exp
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	return &ast.CallExpression{
+	call := &ast.CallExpression{
 		Token:     p.curToken,
 		Function:  function,
-		Arguments: p.parseExpressionList(token.RPAREN),
+		Arguments: nil,
 	}
+
+	call.Arguments = p.parseExpressionList(token.RPAREN)
+
+	return call
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
@@ -319,17 +498,9 @@ func (p *Parser) noPrefixParseFn(t token.TokenType) {
 }
 
 func (p *Parser) peekPrecedence() BindingPower {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
-	}
-
-	return LOWEST
+	return Precedence(p.peekToken.Type)
 }
 
 func (p *Parser) curPrecedence() BindingPower {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
-	}
-
-	return LOWEST
+	return Precedence(p.curToken.Type)
 }
@@ -14,8 +14,9 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l        *lexer.Lexer
+	errors   []string
+	warnings []string
 
 	curToken  token.Token
 	peekToken token.Token
@@ -28,6 +29,7 @@ func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
 		errors:         []string{},
+		warnings:       []string{},
 		curToken:       token.Token{},
 		peekToken:      token.Token{},
 		prefixParseFns: map[token.TokenType]prefixParseFn{},
@@ -46,6 +48,12 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Warnings returns problems that don't stop the program from running, such
+// as duplicate keys in a hash literal.
+func (p *Parser) Warnings() []string {
+	return p.warnings
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
@@ -1,11 +1,18 @@
 package parser
 
 import (
+	"encoding/json"
 	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/lexer"
+	"github.com/ZeroBl21/go-monkey/src/token"
 )
 
 func TestLetStatement(t *testing.T) {
@@ -159,6 +166,48 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testLiteralExpression(t, stmt.Expression, 5)
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		value    int64
+		expected string
+	}{
+		{"9223372036854775807", 9223372036854775807, ""},
+		{"9223372036854775808", 0, "9223372036854775808"},
+		{"123_456_789_012_345_678_901", 0, "123456789012345678901"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.value {
+			t.Errorf("literal.Value not %d. got=%d", tt.value, literal.Value)
+		}
+
+		switch {
+		case tt.expected == "" && literal.Big != nil:
+			t.Errorf("literal.Big is set. got=%s", literal.Big)
+		case tt.expected != "" && literal.Big == nil:
+			t.Errorf("literal.Big is not set")
+		case tt.expected != "" && literal.Big.String() != tt.expected:
+			t.Errorf("literal.Big not %s. got=%s", tt.expected, literal.Big)
+		}
+
+		if literal.String() != tt.input {
+			t.Errorf("literal.String() not %q. got=%q", tt.input, literal.String())
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -302,7 +351,8 @@ func TestParsingHashLiteral(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -338,7 +388,8 @@ func TestParsingHashLiteralIntegerKeys(t *testing.T) {
 		3: 8,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -373,7 +424,8 @@ func TestParsingHashLiteralBooleanKeys(t *testing.T) {
 		false: 2,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.Boolean. got=%T", key)
@@ -409,7 +461,8 @@ func TestParsingHashLiteralWithExpressions(t *testing.T) {
 		"three": func(e ast.Expression) { testInfixExpression(t, e, 15, "/", 3) },
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -425,6 +478,69 @@ func TestParsingHashLiteralWithExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingHashLiteralKeepsSourceOrder(t *testing.T) {
+	input := `{"b": 1, 3: 2, "a": 3, true: 4}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	hashmap, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	expected := []string{"b", "3", "a", "true"}
+	if len(hashmap.Pairs) != len(expected) {
+		t.Fatalf("hashmap.Pairs length not %d. got=%d",
+			len(expected), len(hashmap.Pairs))
+	}
+
+	for i, pair := range hashmap.Pairs {
+		if pair.Key.String() != expected[i] {
+			t.Errorf("key %d wrong. want=%q, got=%q", i, expected[i], pair.Key)
+		}
+		testIntegerLiteral(t, pair.Value, int64(i+1))
+	}
+}
+
+func TestDuplicateHashKeyWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`{"a": 1, "b": 2}`, []string{}},
+		{`{1: 1, "1": 2, true: 3, null: 4}`, []string{}},
+		{`{x: 1, x: 2}`, []string{}},
+		{
+			`{"a": 1, "b": 2, "a": 3}`,
+			[]string{`1:18: duplicate key "a" in hash literal`},
+		},
+		{
+			"{1: 1,\n 1: 2, true: 3, true: 4, null: 5, null: 6}",
+			[]string{
+				"2:2: duplicate key 1 in hash literal",
+				"2:17: duplicate key true in hash literal",
+				"2:35: duplicate key null in hash literal",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if !reflect.DeepEqual(p.Warnings(), tt.expected) {
+			t.Errorf("%s: wrong warnings. want=%q, got=%q",
+				tt.input, tt.expected, p.Warnings())
+		}
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	input := "fn(x, y) { x + y; }"
 
@@ -477,6 +593,53 @@ func TestFunctionLiteral(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
 func TestFunctionParameters(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -516,6 +679,31 @@ func TestFunctionParameters(t *testing.T) {
 	}
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Errorf("function literal name wrong. want=%q, got=%q",
+			"myFunction", function.Name)
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -578,6 +766,180 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingMemberExpressions(t *testing.T) {
+	input := "book.title"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	memberExp, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MemberExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, memberExp.Object, "book") {
+		return
+	}
+
+	if !testIdentifier(t, memberExp.Property, "title") {
+		return
+	}
+}
+
+func TestParsingOptionalChains(t *testing.T) {
+	input := "a?.b?[0]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+	if !indexExp.Optional {
+		t.Errorf("indexExp.Optional is not true")
+	}
+
+	memberExp, ok := indexExp.Left.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("indexExp.Left not *ast.MemberExpression. got=%T",
+			indexExp.Left)
+	}
+	if !memberExp.Optional {
+		t.Errorf("memberExp.Optional is not true")
+	}
+}
+
+func TestNullLiteralExpression(t *testing.T) {
+	l := lexer.New("null;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.NullLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.NullLiteral. got=%T", stmt.Expression)
+	}
+	if literal.TokenLiteral() != "null" {
+		t.Errorf("literal.TokenLiteral not %q. got=%q", "null",
+			literal.TokenLiteral())
+	}
+}
+
+func TestParsingMemberExpressionErrors(t *testing.T) {
+	l := lexer.New("book.1")
+	p := New(l)
+	p.ParseProgram()
+
+	expected := "expected next token to be IDENT, got INT instead"
+	if len(p.Errors()) == 0 || p.Errors()[0] != expected {
+		t.Fatalf("wrong parser errors. want=%q, got=%q", expected, p.Errors())
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedStr string
+		hasCatch    bool
+		hasFinally  bool
+	}{
+		{"try { f() } catch (e) { e }", "try f()catch(e) e", true, false},
+		{"try { f() } finally { g() }", "try f()finally g()", false, true},
+		{
+			"try { f() } catch (e) { e } finally { g() }",
+			"try f()catch(e) efinally g()",
+			true,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T",
+				stmt.Expression)
+		}
+
+		if exp.String() != tt.expectedStr {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expectedStr, exp.String())
+		}
+
+		if (exp.Catch != nil) != tt.hasCatch {
+			t.Errorf("wrong catch clause. got=%v", exp.Catch)
+		}
+		if tt.hasCatch && !testIdentifier(t, exp.Parameter, "e") {
+			return
+		}
+		if (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong finally clause. got=%v", exp.Finally)
+		}
+	}
+}
+
+func TestThrowExpression(t *testing.T) {
+	l := lexer.New(`throw "boom";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.ThrowExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.ThrowExpression. got=%T",
+			stmt.Expression)
+	}
+
+	str, ok := exp.Value.(*ast.StringLiteral)
+	if !ok || str.Value != "boom" {
+		t.Errorf("exp.Value is not the string %q. got=%#v", "boom", exp.Value)
+	}
+}
+
+func TestParsingTryExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 }", "expected catch or finally after try block"},
+		{"try { 1 } catch { 2 }", "expected next token to be (, got { instead"},
+		{"try { 1 } catch (1) { 2 }", "expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q, got=%q",
+				tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -664,6 +1026,7 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 - 5", 5, "-", 5},
 		{"5 * 5", 5, "*", 5},
 		{"5 / 5", 5, "/", 5},
+		{"5 % 5", 5, "%", 5},
 		{"5 < 5", 5, "<", 5},
 		{"5 > 5", 5, ">", 5},
 		{"5 == 5", 5, "==", 5},
@@ -739,6 +1102,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b / c",
 			"(a + (b / c))",
 		},
+		{
+			"a * b % c + d",
+			"(((a * b) % c) + d)",
+		},
 		{
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
@@ -815,6 +1182,38 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a.b.c",
+			"((a.b).c)",
+		},
+		{
+			"a.b(1) + c.d",
+			"((a.b)(1) + (c.d))",
+		},
+		{
+			"-a.b[1]",
+			"(-((a.b)[1]))",
+		},
+		{
+			"a?.b?[c].d",
+			"(((a?.b)?[c]).d)",
+		},
+		{
+			"a ?? b ?? c",
+			"((a ?? b) ?? c)",
+		},
+		{
+			"a ?? b == c",
+			"(a ?? (b == c))",
+		},
+		{
+			"a?.b ?? null",
+			"((a?.b) ?? null)",
+		},
+		{
+			"a ?? throw b + c",
+			"(a ?? throw (b + c))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1043,3 +1442,108 @@ func testLiteralExpression(
 	t.Errorf("type of exp not handled. got=%T", exp)
 	return false
 }
+
+func TestNodeTokensAreFirstTokens(t *testing.T) {
+	input := "f(1, 2);\n[3, 4];\nx + y;"
+
+	program := New(lexer.New(input)).ParseProgram()
+	tok := func(t token.TokenType, literal string, line, column int) token.Token {
+		pos := token.Position{Line: line, Column: column}
+		return token.Token{Type: t, Literal: literal, Pos: pos}
+	}
+
+	tests := []struct {
+		node     ast.Node
+		expected token.Token
+	}{
+		{
+			program.Statements[0],
+			tok(token.IDENT, "f", 1, 1),
+		},
+		{
+			program.Statements[0].(*ast.ExpressionStatement).Expression,
+			tok(token.LPAREN, "(", 1, 2),
+		},
+		{
+			program.Statements[1].(*ast.ExpressionStatement).Expression,
+			tok(token.LBRACKET, "[", 2, 1),
+		},
+		{
+			program.Statements[2],
+			tok(token.IDENT, "x", 3, 1),
+		},
+	}
+
+	for i, tt := range tests {
+		var got token.Token
+		switch node := tt.node.(type) {
+		case *ast.ExpressionStatement:
+			got = node.Token
+		case *ast.CallExpression:
+			got = node.Token
+		case *ast.ArrayLiteral:
+			got = node.Token
+		}
+
+		if got != tt.expected {
+			t.Errorf("tests[%d] - wrong token. want=%+v, got=%+v", i, tt.expected, got)
+		}
+	}
+}
+
+// TestJSONRoundTrip encodes every program that appears as a string literal
+// in this file and checks that decoding gives back the same tree.
+func TestJSONRoundTrip(t *testing.T) {
+	file, err := goparser.ParseFile(gotoken.NewFileSet(), "parser_test.go", nil, 0)
+	if err != nil {
+		t.Fatalf("could not parse parser_test.go: %s", err)
+	}
+
+	tested := 0
+	goast.Inspect(file, func(n goast.Node) bool {
+		lit, ok := n.(*goast.BasicLit)
+		if !ok || lit.Kind != gotoken.STRING {
+			return true
+		}
+
+		input, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return true
+		}
+
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 || len(program.Statements) == 0 {
+			return true
+		}
+
+		data, err := json.Marshal(program)
+		if err != nil {
+			t.Errorf("%q: json.Marshal failed: %s", input, err)
+			return true
+		}
+
+		decoded, err := ast.UnmarshalProgram(data)
+		if err != nil {
+			t.Errorf("%q: UnmarshalProgram failed: %s", input, err)
+			return true
+		}
+
+		again, _ := json.Marshal(decoded)
+		if string(again) != string(data) {
+			t.Errorf("%q: round trip changed the program.\nwant=%s\ngot= %s",
+				input, data, again)
+		}
+		if !reflect.DeepEqual(decoded, program) {
+			t.Errorf("%q: round trip changed the program.\nwant=%#v\ngot= %#v",
+				input, program, decoded)
+		}
+
+		tested++
+		return true
+	})
+
+	if tested < 100 {
+		t.Errorf("only %d programs round-tripped, expected the whole file", tested)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/ZeroBl21/go-monkey/src/ast"
 	"github.com/ZeroBl21/go-monkey/src/compiler"
 	"github.com/ZeroBl21/go-monkey/src/evaluator"
 	"github.com/ZeroBl21/go-monkey/src/lexer"
@@ -25,18 +26,23 @@ const (
 	CompileFlag = 1 << iota
 	LexerFlag
 	PrecedenceFlag
+	CheckedFlag
+	RegisterFlag
+	DumpIRFlag
 )
 
 type REPL struct {
-	env     *object.Environment
-	scanner *bufio.Scanner
-	out     io.Writer
+	env      *object.Environment
+	macroEnv *object.Environment
+	scanner  *bufio.Scanner
+	out      io.Writer
 
 	constants   []object.Object
 	globals     []object.Object
 	symbolTable *compiler.SymbolTable
 
-	flags int32
+	flags        int32
+	optimization compiler.OptimizationLevel
 }
 
 func New(in io.Reader, out io.Writer) *REPL {
@@ -46,9 +52,10 @@ func New(in io.Reader, out io.Writer) *REPL {
 	}
 
 	return &REPL{
-		env:     object.NewEnvironment(),
-		scanner: bufio.NewScanner(in),
-		out:     out,
+		env:      object.NewEnvironment(),
+		macroEnv: object.NewEnvironment(),
+		scanner:  bufio.NewScanner(in),
+		out:      out,
 
 		// Compiler
 		constants:   []object.Object{},
@@ -61,6 +68,12 @@ func New(in io.Reader, out io.Writer) *REPL {
 
 func (r *REPL) SetFlags(flags int) {
 	r.flags = int32(flags)
+	r.env.SetCheckedArithmetic(r.flags&CheckedFlag != 0)
+}
+
+// SetOptimizationLevel sets the optimization level of the compiler.
+func (r *REPL) SetOptimizationLevel(level compiler.OptimizationLevel) {
+	r.optimization = level
 }
 
 func (r *REPL) Start() {
@@ -78,7 +91,9 @@ func (r *REPL) Start() {
 
 func (r *REPL) Execute(line string) {
 	switch {
-	case r.flags&CompileFlag != 0:
+	case r.flags&DumpIRFlag != 0:
+		r.DumpIR(line)
+	case r.flags&(CompileFlag|RegisterFlag) != 0:
 		r.EvaluateLineCompiled(line)
 	case r.flags&LexerFlag != 0:
 		r.PrintTokens(line)
@@ -98,36 +113,42 @@ func (r *REPL) EvaluateLine(line string) {
 		r.printParserErrors(p.Errors())
 		return
 	}
+	r.printWarnings(p.Warnings())
 
-	evaluated := evaluator.Eval(program, r.env)
+	expanded, ok := r.expandMacros(program)
+	if !ok {
+		return
+	}
+
+	evaluated := evaluator.Eval(expanded, r.env)
 	if evaluated != nil {
 		io.WriteString(r.out, applyColor(YELLOW, evaluated.Inspect()))
 		io.WriteString(r.out, "\n")
 	}
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(r.out, err.Trace.String())
+	}
 }
 
 func (r *REPL) EvaluateLineCompiled(line string) {
-	l := lexer.New(line)
-	p := parser.New(l)
-
-	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		r.printParserErrors(p.Errors())
-		return
-	}
-
-	comp := compiler.NewWithState(r.symbolTable, r.constants)
-	if err := comp.Compile(program); err != nil {
-		fmt.Fprintf(r.out, "Woops! Compilation failed:\n %s\n", err)
+	comp, ok := r.compile(line)
+	if !ok {
 		return
 	}
 
 	code := comp.Bytecode()
 	r.constants = code.Constants
 
-	machine := vm.NewWithGlobalStore(code, r.globals)
+	var machine vm.Machine = vm.NewWithGlobalStore(code, r.globals)
+	if r.flags&RegisterFlag != 0 {
+		machine = vm.NewRegisterWithGlobalStore(code, r.globals)
+	}
+	machine.SetCheckedArithmetic(r.flags&CheckedFlag != 0)
 	if err := machine.Run(); err != nil {
 		fmt.Fprintf(r.out, "Woops! Executing bytecode failed:\n %s\n", err)
+		if err, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(r.out, err.Trace.String())
+		}
 		return
 	}
 
@@ -136,6 +157,70 @@ func (r *REPL) EvaluateLineCompiled(line string) {
 	io.WriteString(r.out, "\n")
 }
 
+// DumpIR compiles line and prints the intermediate representation of its
+// functions and main program instead of running it.
+func (r *REPL) DumpIR(line string) {
+	comp, ok := r.compile(line)
+	if !ok {
+		return
+	}
+
+	for i, fn := range comp.IR() {
+		if i > 0 {
+			io.WriteString(r.out, "\n")
+		}
+		io.WriteString(r.out, fn.String())
+	}
+
+	r.constants = comp.Bytecode().Constants
+}
+
+// compile parses line, expands its macros and compiles it with the state of
+// the previous lines, printing the errors and warnings along the way.
+func (r *REPL) compile(line string) (*compiler.Compiler, bool) {
+	l := lexer.New(line)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		r.printParserErrors(p.Errors())
+		return nil, false
+	}
+	r.printWarnings(p.Warnings())
+
+	expanded, ok := r.expandMacros(program)
+	if !ok {
+		return nil, false
+	}
+
+	comp := compiler.NewWithState(
+		r.symbolTable,
+		r.constants,
+		compiler.WithOptimization(r.optimization),
+	)
+	if err := comp.Compile(expanded); err != nil {
+		fmt.Fprintf(r.out, "Woops! Compilation failed:\n %s\n", err)
+		return nil, false
+	}
+	r.printWarnings(comp.Warnings())
+
+	return comp, true
+}
+
+// expandMacros defines the macros of the program and expands their calls.
+// Macros defined on earlier lines stay available.
+func (r *REPL) expandMacros(program *ast.Program) (ast.Node, bool) {
+	evaluator.DefineMacros(program, r.macroEnv)
+
+	expanded, err := evaluator.ExpandMacros(program, r.macroEnv)
+	if err != nil {
+		fmt.Fprintf(r.out, "Woops! Macro expansion failed:\n %s\n", err)
+		return nil, false
+	}
+
+	return expanded, true
+}
+
 func (r *REPL) printParserErrors(errors []string) {
 	io.WriteString(r.out, "Woops!, We ran into some monkey business here!\n")
 	io.WriteString(r.out, " parser errors:\n")
@@ -144,6 +229,12 @@ func (r *REPL) printParserErrors(errors []string) {
 	}
 }
 
+func (r *REPL) printWarnings(warnings []string) {
+	for _, msg := range warnings {
+		io.WriteString(r.out, applyColor(YELLOW, "warning: "+msg)+"\n")
+	}
+}
+
 func (r *REPL) PrintTokens(line string) {
 	l := lexer.New(line)
 
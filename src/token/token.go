@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
@@ -16,6 +18,7 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
 	EQ     = "=="
 	NOT_EQ = "!="
@@ -23,10 +26,16 @@ const (
 	LT = "<"
 	RT = ">"
 
+	COALESCE = "??"
+
 	// Delimiters
 	COMMA     = ","
 	COLON     = ":"
 	SEMICOLON = ";"
+	DOT       = "."
+
+	OPTIONAL_DOT      = "?."
+	OPTIONAL_LBRACKET = "?["
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -43,6 +52,12 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	NULL     = "NULL"
+	MACRO    = "MACRO"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 )
 
 type TokenType string
@@ -50,16 +65,36 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Position is the line and column, both starting at 1, of the first char of
+// a token. The zero Position is used for tokens that don't come from source.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"null":    NULL,
+	"macro":   MACRO,
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
 }
 
 func LookupIdent(ident string) TokenType {
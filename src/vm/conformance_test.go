@@ -0,0 +1,398 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey/src/compiler"
+	"github.com/ZeroBl21/go-monkey/src/evaluator"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// conformanceTests must give the same result in the evaluator and the VM.
+// The expected value is the Inspect output of the result.
+var conformanceTests = []struct {
+	input    string
+	expected string
+}{
+	// Equality
+	{`1 == 1`, "true"},
+	{`1 != 1`, "false"},
+	{`1 == 2`, "false"},
+	{`1 == true`, "false"},
+	{`true == 1`, "false"},
+	{`1 != true`, "true"},
+	{`1 == "1"`, "false"},
+	{`"a" == "a"`, "true"},
+	{`let a = "a"; let b = "a"; a == b`, "true"},
+	{`"a" + "b" == "ab"`, "true"},
+	{`"a" != "b"`, "true"},
+	{`"a" == fn() { "a" }()`, "true"},
+	{`true == true`, "true"},
+	{`true != false`, "true"},
+	{`null == null`, "true"},
+	{`null == false`, "false"},
+	{`[] == []`, "true"},
+	{`[1, [2, "3"]] == [1, [2, "3"]]`, "true"},
+	{`[1, 2] == [1, 2, 3]`, "false"},
+	{`[1, 2] == [2, 1]`, "false"},
+	{`[1] == 1`, "false"},
+	{`[1, 2] != [1, 2]`, "false"},
+	{`{} == {}`, "true"},
+	{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, "true"},
+	{`{"a": 1} == {"a": 2}`, "false"},
+	{`{"a": 1} == {"b": 1}`, "false"},
+	{`{1: 1} == {"1": 1}`, "false"},
+	{`{"a": 1} == {"a": 1, "b": 2}`, "false"},
+	{`{"a": {"b": [1]}} == {"a": {"b": [1]}}`, "true"},
+	{`let f = fn() { 1 }; f == f`, "true"},
+	{`fn() { 1 } == fn() { 1 }`, "false"},
+	{`len == len`, "true"},
+	{`len == 1`, "false"},
+
+	// Arithmetic
+	{`7 % 3`, "1"},
+	{`-7 % 3`, "-1"},
+	{`7 % -3`, "1"},
+
+	// Big integers
+	{`9223372036854775807 + 1`, "9223372036854775808"},
+	{`-9223372036854775807 - 2`, "-9223372036854775809"},
+
+	// Specialized instructions, on operands other than int64s
+	{`let f = fn(x) { x + 1 }; f(9223372036854775807)`, "9223372036854775808"},
+	{`let f = fn(x) { x - 2 }; f(-9223372036854775807)`, "-9223372036854775809"},
+	{`let f = fn(x) { x - 1 }; f(9223372036854775808)`, "9223372036854775807"},
+	{`let f = fn(x, y) { if (x < y) { 1 } else { 2 } }; f(9223372036854775808, 1)`, "2"},
+	{`let f = fn(x, y) { if (x > y) { 1 } else { 2 } }; f(9223372036854775808, 1)`, "1"},
+	{`let f = fn(x, y) { if (x == y) { 1 } else { 2 } }; f("a", "a")`, "1"},
+	{`let f = fn(x, y) { if (x != y) { 1 } else { 2 } }; f([1], [1])`, "2"},
+	{`let f = fn(x, y) { if (x == y) { 1 } else { 2 } }; f(true, 1)`, "2"},
+	{`let f = fn(a, b, c) { [a, b, c] }; let g = fn(x) { f(x, x, 1) }; g(0)`, "[0, 0, 1]"},
+	{`4294967296 * 4294967296`, "18446744073709551616"},
+	{`-(-9223372036854775807 - 1)`, "9223372036854775808"},
+	{`-9223372036854775808`, "-9223372036854775808"},
+	{`18446744073709551616`, "18446744073709551616"},
+	{`99999999999999999999 / 3`, "33333333333333333333"},
+	{`-99999999999999999999 % 7`, "-1"},
+	{`(9223372036854775807 + 1) - 1`, "9223372036854775807"},
+	{`[1, 2][9223372036854775808 - 9223372036854775807]`, "2"},
+	{`[1][99999999999999999999]`, "null"},
+	{`99999999999999999999 > 1`, "true"},
+	{`-99999999999999999999 < 1`, "true"},
+	{`9223372036854775808 == 9223372036854775807 + 1`, "true"},
+	{`9223372036854775808 != 9223372036854775808`, "false"},
+	{`9223372036854775808 - 1 == 9223372036854775807`, "true"},
+	{`{9223372036854775808: "big"}[9223372036854775807 + 1]`, "big"},
+	{`{2: "two"}[(9223372036854775807 + 2) - 9223372036854775807]`, "two"},
+	{
+		`let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)`,
+		"15511210043330985984000000",
+	},
+
+	// Hash literals
+	{`{"b": 1, "a": 2}`, "{b: 1, a: 2}"},
+	{`{"a": 1, "a": 2}`, "{a: 2}"},
+
+	// Exceptions
+	{`try { 1 } catch (e) { 2 }`, "1"},
+	{`try { throw "x" } catch (e) { e }`, "x"},
+	{`try { 1 / 0 } catch (e) { e.message }`, "division by zero"},
+	{`try { 1 / 0 } catch (e) { e.trace }`, "[<main> (1:9)]"},
+	{"try { len(1) } catch (e) { e.message }", "argument to `len` not supported, got=INTEGER"},
+	{`try { "a".upper(1) } catch (e) { e.message }`, "wrong number of arguments. got=2, want=1"},
+	{`try { "a" - 1 } catch (e) { e.message }`, "type mismatch: STRING - INTEGER"},
+	{`try { "a" < 1 } catch (e) { e.message }`, "type mismatch: STRING < INTEGER"},
+	{`try { "a" - "b" } catch (e) { e.message }`, "unknown operator: STRING - STRING"},
+	{`try { "a" > "b" } catch (e) { e.message }`, "unknown operator: STRING > STRING"},
+	{`try { true + false } catch (e) { e.message }`, "unknown operator: BOOLEAN + BOOLEAN"},
+	{`try { -true } catch (e) { e.message }`, "unknown operator: -BOOLEAN"},
+	{`try { 1(2) } catch (e) { e.message }`, "not a function: INTEGER"},
+	{`try { {}[fn() {}] } catch (e) { e.message }`, "unusable as hash key: FUNCTION"},
+	{`try { {fn() {}: 1} } catch (e) { e.message }`, "unusable as hash key: FUNCTION"},
+	{`try { fn() {} + 1 } catch (e) { e.message }`, "type mismatch: FUNCTION + INTEGER"},
+	{`try { len(fn() {}) } catch (e) { e.message }`, "argument to `len` not supported, got=FUNCTION"},
+	{`try { fn(a) { a }() } catch (e) { e.message }`, "wrong number of arguments: want=1, got=0"},
+	{`try { } catch (e) { 1 }`, "null"},
+	{`try { 1 } finally { 2 }`, "1"},
+	{`try { try { throw 1 } finally { 5 } } catch (e) { e + 1 }`, "2"},
+	{`try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e * 10 }`, "20"},
+	{`try { try { throw 1 } catch (e) { 2 } finally { 3 } } catch (e) { 4 }`, "2"},
+	{
+		`let f = fn() { throw {"code": 1} };
+		let g = fn() { f() + 1 };
+		try { g() } catch (e) { e.code }`,
+		"1",
+	},
+	{
+		`let f = fn() { 1 / 0 };
+		let g = fn() { f() + 1 };
+		try { g() } catch (e) { e.trace }`,
+		"[f (1:18, 0 args), g (2:19, 0 args), <main> (3:10)]",
+	},
+	{
+		// g calls f in tail position, so f replaces it.
+		`let f = fn() { 1 / 0 };
+		let g = fn() { f() };
+		try { g() } catch (e) { e.trace }`,
+		"[f (1:18, 0 args), <main> (3:10)]",
+	},
+	{
+		`let iter = fn(i, sum) {
+			if (i == 1000000) { return sum; }
+			iter(i + 1, sum + i)
+		};
+		iter(0, 0)`,
+		"499999500000",
+	},
+	{
+		`let map = fn(arr, f) {
+			let iter = fn(arr, accumulated) {
+				if (len(arr) == 0) {
+					accumulated
+				} else {
+					iter(rest(arr), push(accumulated, f(first(arr))))
+				}
+			};
+			iter(arr, [])
+		};
+		map([1, 2, 3], fn(x) { x * 2 })`,
+		"[2, 4, 6]",
+	},
+	{
+		`let bounce = fn(f, n) { if (n == 0) { "done" } else { f(f, n - 1) } };
+		bounce(bounce, 5000)`,
+		"done",
+	},
+	{`let f = fn(a) { len(a) }; f("tail")`, "4"},
+	{
+		`let g = fn(a) { let b = 2; [a, b, try { a + throw b } catch (e) { e * 100 }] };
+		g(1)`,
+		"[1, 2, 200]",
+	},
+	{`1 + try { 2 + throw 3 } catch (e) { e }`, "4"},
+	{
+		`let f = fn(x) { let a = 5; if (x) { return 0 }; [a, try { throw 1 } catch (err) { err + 1 }] };
+		f(false)`,
+		"[5, 2]",
+	},
+	{`let fan = fn(x) { if (x) { return 0 }; try { 2 } catch (err) { 3 } }; fan(false)`, "2"},
+	{`let f = fn(x) { if (x) { 1 } else { return 0 }; try { throw 2 } catch (err) { err } }; f(true)`, "2"},
+	{`let f = fn() { let a = 5; if (true) { return a }; try { 1 } catch (err) { 2 } }; f()`, "5"},
+	{`try { (throw "left") < (throw "right") } catch (e) { e }`, "left"},
+	{`let f = fn(x) { x ?? throw "missing" }; try { f(null) } catch (e) { e }`, "missing"},
+	{`let f = fn() { try { return 1 } finally { 2 } }; f()`, "1"},
+	{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+	{`let f = fn() { try { throw 1 } catch (e) { return e + 1 } }; f()`, "2"},
+	{
+		`let f = fn() { try { try { return 1 } finally { throw 2 } } catch (e) { e } };
+		f()`,
+		"2",
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range conformanceTests {
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("evaluator: %s: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Errorf("compiler: %s: %s", tt.input, err)
+				continue
+			}
+
+			for _, engine := range engines {
+				vm := engine.new(comp.Bytecode())
+				if err := vm.Run(); err != nil {
+					t.Errorf("%s: %s: %s", engine.name, tt.input, err)
+					continue
+				}
+
+				if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+					t.Errorf("%s: level %d: %s: want=%s, got=%s",
+						engine.name, level, tt.input, tt.expected, got)
+				}
+			}
+		}
+	}
+}
+
+func TestStackTraceConformance(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let check = fn(a, b) {\n" +
+				"  a / b\n" +
+				"};\n" +
+				"let run = fn(x) { check(x, 0) + 1 };\n" +
+				"let apply = fn(f) { f(1) + 1 };\n" +
+				"apply(fn(x) { run(x) + 1 })",
+			"  at check (2:5, 2 args)\n" +
+				"  at run (4:24, 1 arg)\n" +
+				"  at <anonymous> (6:18, 1 arg)\n" +
+				"  at apply (5:22, 1 arg)\n" +
+				"  at <main> (6:6)\n",
+		},
+		{
+			// The calls in tail position replace the frames of their
+			// callers.
+			"let check = fn(a, b) {\n" +
+				"  a / b\n" +
+				"};\n" +
+				"let run = fn(x) { check(x, 0) };\n" +
+				"let apply = fn(f) { f(1) };\n" +
+				"apply(fn(x) { run(x) })",
+			"  at check (2:5, 2 args)\n" +
+				"  at <main> (6:6)\n",
+		},
+		{
+			"let f = fn(a, b) { a };\nlet g = fn() { f(1) };\ng()",
+			"  at g (2:17, 0 args)\n" +
+				"  at <main> (3:2)\n",
+		},
+		{
+			"1 +\n  1 % 0",
+			"  at <main> (2:5)\n",
+		},
+		{
+			"let f = fn(a) { a - 1 };\nlet g = fn(x) { f(x) * 2 };\ng(\"x\")",
+			"  at f (1:19, 1 arg)\n" +
+				"  at g (2:18, 1 arg)\n" +
+				"  at <main> (3:2)\n",
+		},
+		{
+			"let f = fn(a) { len(a) };\nlet g = fn() { f(1) + 1 };\ng()",
+			"  at f (1:20, 1 arg)\n" +
+				"  at g (2:17, 0 args)\n" +
+				"  at <main> (3:2)\n",
+		},
+		{
+			"let f = fn(x) { x.size };\n1 + f(1)",
+			"  at f (1:18, 1 arg)\n" +
+				"  at <main> (2:6)\n",
+		},
+		{
+			"let f = fn(x) { x[0] };\n1 + f(true)",
+			"  at f (1:18, 1 arg)\n" +
+				"  at <main> (2:6)\n",
+		},
+		{
+			// Small functions, whose calls are inlined.
+			"let f = fn() { throw \"x\" };\nlet g = fn() { f() + 1 };\ng() + 1",
+			"  at f (1:16, 0 args)\n" +
+				"  at g (2:17, 0 args)\n" +
+				"  at <main> (3:2)\n",
+		},
+		{
+			"let h = fn(a) { a / 0 };\nlet f = fn(a) { h(a) + 1 };\nf(1)",
+			"  at h (1:19, 1 arg)\n" +
+				"  at f (2:18, 1 arg)\n" +
+				"  at <main> (3:2)\n",
+		},
+		{
+			// f calls k in tail position, so k replaces the frame of f.
+			"let k = fn(x) { let d = x / 0; d };\n" +
+				"let f = fn(a) { k(a) };\n" +
+				"let g = fn() { f(1) + 1 };\n" +
+				"g()",
+			"  at k (1:27, 1 arg)\n" +
+				"  at g (3:17, 0 args)\n" +
+				"  at <main> (4:2)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("evaluator: %s: not an error. got=%T (%+v)",
+				tt.input, evaluated, evaluated)
+		} else if got := errObj.Trace.String(); got != tt.expected {
+			t.Errorf("evaluator: %s: wrong trace.\nwant=%q\ngot= %q",
+				tt.input, tt.expected, got)
+		}
+
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Errorf("compiler: %s: %s", tt.input, err)
+				continue
+			}
+
+			for _, engine := range engines {
+				err := engine.new(comp.Bytecode()).Run()
+				runtimeErr, ok := err.(*RuntimeError)
+				if !ok {
+					t.Errorf("%s: %s: not a *RuntimeError. got=%T (%v)",
+						engine.name, tt.input, err, err)
+					continue
+				}
+				if got := runtimeErr.Trace.String(); got != tt.expected {
+					t.Errorf("%s: level %d: %s: wrong trace.\nwant=%q\ngot= %q",
+						engine.name, level, tt.input, tt.expected, got)
+				}
+			}
+		}
+	}
+}
+
+// TestStackOverflowConformance checks that both VMs raise a stack overflow
+// at the call that doesn't fit, whatever the frames of the function hold.
+// The evaluator has no such limit.
+func TestStackOverflowConformance(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		frame    string
+	}{
+		{
+			"let f = fn(n) { f(n + 1) + 1 }; f(0)",
+			"1:18: stack overflow",
+			"f (1:18, 1 arg)",
+		},
+		{
+			"let f = fn(n) { let a = n; let b = a; f(n + 1) + a + b }; f(0)",
+			"1:40: stack overflow",
+			"f (1:40, 1 arg)",
+		},
+		{
+			"let f = fn(n) {\n  [n, n, n, try { f(n + 1) } finally { n }]\n};\nf(0)",
+			"2:20: stack overflow",
+			"f (2:20, 1 arg)",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Errorf("compiler: %s: %s", tt.input, err)
+				continue
+			}
+
+			for _, engine := range engines {
+				err := engine.new(comp.Bytecode()).Run()
+				runtimeErr, ok := err.(*RuntimeError)
+				if !ok {
+					t.Errorf("%s: %s: not a *RuntimeError. got=%T (%v)",
+						engine.name, tt.input, err, err)
+					continue
+				}
+
+				if runtimeErr.Error() != tt.expected {
+					t.Errorf("%s: level %d: %s: wrong error. want=%q, got=%q",
+						engine.name, level, tt.input, tt.expected, runtimeErr)
+				}
+				if got := runtimeErr.Trace[0].String(); got != tt.frame {
+					t.Errorf("%s: level %d: %s: wrong frame. want=%q, got=%q",
+						engine.name, level, tt.input, tt.frame, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/object"
+	"github.com/ZeroBl21/go-monkey/src/token"
+)
+
+// RuntimeError is an error raised while running the bytecode. Pos is the
+// position of the code that raised it, when known, and Trace the call stack
+// at that point. Value is the thrown value, for errors raised by throw.
+type RuntimeError struct {
+	Message string
+	Pos     token.Position
+	Trace   object.StackTrace
+	Value   object.Object
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+	}
+
+	return e.Message
+}
+
+// runtimeError returns a RuntimeError positioned at the instruction being
+// executed.
+func (vm *VM) runtimeError(format string, a ...any) error {
+	frame := vm.currentFrame()
+
+	return &RuntimeError{
+		Message: fmt.Sprintf(format, a...),
+		Pos:     frame.cl.Fn.SourceMap.Lookup(frame.ip),
+	}
+}
+
+// caught returns the value a catch clause binds for e.
+func (e *RuntimeError) caught() object.Object {
+	if e.Value != nil {
+		return e.Value
+	}
+
+	return object.ErrorValue(e.Message, e.Trace)
+}
+
+// pendingError is pushed by a finally handler, for the OpThrow that ends the
+// finally block to rethrow the error unchanged.
+type pendingError struct {
+	err *RuntimeError
+}
+
+func (e *pendingError) Type() object.ObjectType { return object.ERROR_OBJ }
+func (e *pendingError) Inspect() string         { return e.err.Error() }
+
+// throw raises value as an error, or rethrows a pending error.
+func (vm *VM) throw(value object.Object) error {
+	if pending, ok := value.(*pendingError); ok {
+		return pending.err
+	}
+
+	err := vm.runtimeError("uncaught exception: %s", value.Inspect())
+	err.(*RuntimeError).Value = value
+
+	return err
+}
+
+// handle unwinds the frames and the stack to the innermost handler of err
+// and makes it the next instruction to run. It reports whether there was
+// one.
+func (vm *VM) handle(err *RuntimeError) bool {
+	for {
+		frame := vm.currentFrame()
+		fn := frame.cl.Fn
+
+		if h, ok := fn.Handlers.Lookup(frame.ip); ok {
+			vm.sp = frame.basePointer + fn.NumLocals + h.Depth
+
+			var value object.Object = &pendingError{err: err}
+			if !h.Finally {
+				value = err.caught()
+			}
+			vm.stack[vm.sp] = value
+			vm.sp++
+
+			frame.ip = h.Target - 1
+			return true
+		}
+
+		if vm.framesIndex == 1 {
+			return false
+		}
+
+		frame = vm.popFrame()
+		vm.sp = frame.basePointer - 1
+	}
+}
+
+// wrapError turns err into a RuntimeError, if it isn't one already, and
+// attaches the current call stack to it unless it has one.
+func (vm *VM) wrapError(err error) *RuntimeError {
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		runtimeErr = vm.runtimeError("%s", err).(*RuntimeError)
+	}
+
+	if runtimeErr.Trace == nil {
+		runtimeErr.Trace = vm.stackTrace()
+	}
+
+	return runtimeErr
+}
+
+// stackTrace returns the active calls, innermost first.
+func (vm *VM) stackTrace() object.StackTrace {
+	trace := make(object.StackTrace, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		entry := frame.cl.Fn.SourceMap.Entry(frame.ip)
+		trace = appendFrames(trace, frame.cl.Fn, i == 0, i < vm.framesIndex-1, entry)
+	}
+
+	return trace
+}
+
+// appendFrames appends to trace the frames of a call of fn, the main program
+// when main, running the code entry maps: one for each call inlined around
+// the code, innermost first, then the one for fn. calling reports whether the
+// code is a call whose callee is running.
+func appendFrames(
+	trace object.StackTrace,
+	fn *object.CompiledFunction,
+	main, calling bool,
+	entry code.SourceMapEntry,
+) object.StackTrace {
+	pos, inlined := entry.Pos, entry.Inlined
+	if calling && entry.TailCall && inlined != nil {
+		// The callee replaced the frame of the innermost inlined body.
+		pos, inlined = inlined.Pos, inlined.Outer
+	}
+
+	for ; inlined != nil; inlined = inlined.Outer {
+		trace = append(trace, object.StackFrame{
+			Function: inlined.Function,
+			Pos:      pos,
+			NumArgs:  inlined.NumArgs,
+		})
+		pos = inlined.Pos
+	}
+
+	return append(trace, stackFrame(fn, main, pos))
+}
+
+// stackFrame returns the entry of a stack trace for a call of fn, the main
+// program when main, running the code at pos.
+func stackFrame(fn *object.CompiledFunction, main bool, pos token.Position) object.StackFrame {
+	name := fn.Name
+	switch {
+	case main:
+		name = object.MainFunction
+	case name == "":
+		name = object.AnonymousFunction
+	}
+
+	return object.StackFrame{
+		Function: name,
+		Pos:      pos,
+		NumArgs:  fn.NumParameters,
+	}
+}
@@ -0,0 +1,283 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// The operations on values shared by the stack VM and the register VM. They
+// return plain errors, which the VMs turn into runtime errors positioned at
+// the instruction being executed.
+
+// builtinResult returns the value returned by a builtin, turning errors into
+// runtime errors at the call site.
+func builtinResult(result object.Object) (object.Object, error) {
+	switch result := result.(type) {
+	case nil:
+		return Null, nil
+	case *object.Error:
+		return nil, fmt.Errorf("%s", result.Message)
+	default:
+		return result, nil
+	}
+}
+
+func buildArray(elements []object.Object) object.Object {
+	array := make([]object.Object, len(elements))
+	copy(array, elements)
+
+	return &object.Array{Elements: array}
+}
+
+// buildHash builds the hash of the keys and values alternating in elements.
+func buildHash(elements []object.Object) (object.Object, error) {
+	hash := object.NewHash(len(elements) / 2)
+
+	for i := 0; i < len(elements); i += 2 {
+		key := elements[i]
+		value := elements[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash, nil
+}
+
+func indexValue(left, index object.Object) (object.Object, error) {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return arrayIndex(left, index), nil
+
+	case left.Type() == object.HASH_OBJ:
+		return hashIndex(left, index)
+
+	default:
+		return nil, fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func arrayIndex(array, index object.Object) object.Object {
+	arrayObject := array.(*object.Array)
+	max := int64(len(arrayObject.Elements) - 1)
+
+	// A BigInt index is always out of range.
+	integer, ok := index.(*object.Integer)
+	if !ok || integer.Value < 0 || integer.Value > max {
+		return Null
+	}
+
+	return arrayObject.Elements[integer.Value]
+}
+
+func hashIndex(hash, index object.Object) (object.Object, error) {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	value, ok := hashObject.Get(key)
+	if !ok {
+		return Null, nil
+	}
+
+	return value, nil
+}
+
+func getField(obj object.Object, name string, optional bool) (object.Object, error) {
+	member, ok := object.GetMember(obj, name)
+	if !ok {
+		if optional {
+			return Null, nil
+		}
+		return nil, fmt.Errorf("no field or method %q on %s", name, obj.Type())
+	}
+
+	return member, nil
+}
+
+// binaryOperation returns the result of the arithmetic opcode op on left and
+// right. checked makes integer results that don't fit in an int64 an error.
+func binaryOperation(
+	op code.Opcode,
+	left, right object.Object,
+	checked bool,
+) (object.Object, error) {
+	rightType := right.Type()
+	leftType := left.Type()
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return binaryIntegerOperation(op, left, right, checked)
+
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return binaryStringOperation(op, left, right)
+
+	default:
+		return nil, operatorError(op, left, right)
+	}
+}
+
+// operators spells the arithmetic opcodes in error messages.
+var operators = map[code.Opcode]string{
+	code.OpAdd: "+",
+	code.OpSub: "-",
+	code.OpMul: "*",
+	code.OpDiv: "/",
+	code.OpMod: "%",
+}
+
+// comparisonOperators spells the comparison opcodes in error messages.
+var comparisonOperators = map[code.Opcode]string{
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// operatorError returns the error for the opcode op on operands it doesn't
+// support, worded as the evaluator words it.
+func operatorError(op code.Opcode, left, right object.Object) error {
+	operator, ok := operators[op]
+	if !ok {
+		operator = comparisonOperators[op]
+	}
+
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	return fmt.Errorf("unknown operator: %s %s %s",
+		left.Type(), operator, right.Type())
+}
+
+func binaryIntegerOperation(
+	op code.Opcode,
+	left, right object.Object,
+	checked bool,
+) (object.Object, error) {
+	operator, ok := operators[op]
+	if !ok {
+		return nil, operatorError(op, left, right)
+	}
+
+	if (op == code.OpDiv || op == code.OpMod) && object.IsZero(right) {
+		return nil, fmt.Errorf("division by zero")
+	}
+
+	result, fits := object.IntegerOperation(operator, left, right)
+	if !fits && checked {
+		return nil, fmt.Errorf("integer overflow: %s %s %s",
+			left.Inspect(), operator, right.Inspect())
+	}
+
+	return result, nil
+}
+
+func binaryStringOperation(
+	op code.Opcode,
+	left, right object.Object,
+) (object.Object, error) {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpAdd:
+		return &object.String{Value: leftValue + rightValue}, nil
+	default:
+		return nil, operatorError(op, left, right)
+	}
+}
+
+// comparison returns the result of the comparison opcode op on left and
+// right.
+func comparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return integerComparison(op, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(object.Equal(left, right)), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(!object.Equal(left, right)), nil
+	default:
+		return nil, operatorError(op, left, right)
+	}
+}
+
+func integerComparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	comparison := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(comparison == 0), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(comparison != 0), nil
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(comparison > 0), nil
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(comparison < 0), nil
+	default:
+		return nil, operatorError(op, left, right)
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+
+	return False
+}
+
+func bang(operand object.Object) object.Object {
+	switch operand {
+	case True:
+		return False
+	case False:
+		return True
+	case Null:
+		return True
+	default:
+		return False
+	}
+}
+
+// negate returns the negation of operand. checked makes a result that
+// doesn't fit in an int64 an error.
+func negate(operand object.Object, checked bool) (object.Object, error) {
+	if operand.Type() != object.INTEGER_OBJ {
+		return nil, fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+
+	result, fits := object.NegateInteger(operand)
+	if !fits && checked {
+		return nil, fmt.Errorf("integer overflow: -(%s)", operand.Inspect())
+	}
+
+	return result, nil
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+
+	case *object.Boolean:
+		return obj.Value
+
+	case *object.Null:
+		return false
+
+	default:
+		return true
+	}
+}
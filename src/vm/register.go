@@ -0,0 +1,521 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/compiler"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// RegisterVM runs the bytecode of the compiler like VM, after translating
+// each function into the instructions of a register machine, see translate.
+// Its instructions read and write the registers of the frame directly,
+// saving most of the pushes and pops of the stack VM. The frame of a call
+// starts right after the register of the callee, where the arguments are,
+// and its result replaces the callee.
+type RegisterVM struct {
+	constants []object.Object
+	registers []object.Object
+	globals   []object.Object
+
+	frames      []registerFrame
+	framesIndex int
+
+	main       *object.Closure
+	lastPopped object.Object
+
+	// The translations of the functions called so far.
+	functions map[*object.CompiledFunction]*registerFunction
+
+	checkedArithmetic bool
+}
+
+type registerFrame struct {
+	cl *object.Closure
+	fn *registerFunction
+	ip int
+	// Index of the first register of the frame.
+	base int
+}
+
+func NewRegister(bytecode *compiler.Bytecode) *RegisterVM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Handlers:     bytecode.Handlers,
+	}
+
+	return &RegisterVM{
+		constants: bytecode.Constants,
+		registers: make([]object.Object, StackSize),
+		globals:   make([]object.Object, GlobalSize),
+
+		frames: make([]registerFrame, MaxFrames),
+
+		main:      &object.Closure{Fn: mainFn},
+		functions: map[*object.CompiledFunction]*registerFunction{},
+	}
+}
+
+func NewRegisterWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *RegisterVM {
+	vm := NewRegister(bytecode)
+	vm.globals = s
+
+	return vm
+}
+
+// SetCheckedArithmetic makes integer results that don't fit in an int64 a
+// runtime error instead of promoting them to BigInts.
+func (vm *RegisterVM) SetCheckedArithmetic(checked bool) {
+	vm.checkedArithmetic = checked
+}
+
+// LastPoppedStackElem returns the value of the last expression statement of
+// the main program, or the value of its last let statement after it.
+func (vm *RegisterVM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// Run executes the bytecode. Errors that no handler catches are returned as
+// a *RuntimeError carrying the call stack at the point of failure.
+func (vm *RegisterVM) Run() error {
+	main, err := translate(vm.main.Fn, true)
+	if err != nil {
+		return &RuntimeError{Message: err.Error()}
+	}
+
+	vm.frames[0] = registerFrame{cl: vm.main, fn: main, ip: -1}
+	vm.framesIndex = 1
+
+	if main.numRegisters > len(vm.registers) {
+		return vm.wrapError(fmt.Errorf("stack overflow"))
+	}
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		runtimeErr := vm.wrapError(err)
+		if !vm.handle(runtimeErr) {
+			return runtimeErr
+		}
+	}
+}
+
+func (vm *RegisterVM) run() error {
+	frame := &vm.frames[vm.framesIndex-1]
+	ins := frame.fn.instructions
+	regs := vm.registers[frame.base:]
+
+	for frame.ip < len(ins)-1 {
+		frame.ip++
+		in := ins[frame.ip]
+
+		switch in.Op {
+		case code.RMove:
+			regs[in.A] = vm.operand(regs, in.B)
+
+		case code.RTrue:
+			regs[in.A] = True
+
+		case code.RFalse:
+			regs[in.A] = False
+
+		case code.RNull:
+			regs[in.A] = Null
+
+		case code.RGetGlobal:
+			regs[in.A] = vm.globals[in.B]
+
+		case code.RSetGlobal:
+			value := vm.operand(regs, in.B)
+			vm.globals[in.A] = value
+			vm.lastPopped = value
+
+		case code.RGetBuiltin:
+			regs[in.A] = object.Builtins[in.B].Builtin
+
+		case code.RGetFree:
+			regs[in.A] = frame.cl.Free[in.B]
+
+		case code.RCurrentClosure:
+			regs[in.A] = frame.cl
+
+		case code.RAdd, code.RSub, code.RMul, code.RDiv, code.RMod:
+			result, err := vm.arithmetic(in.Op, vm.operand(regs, in.B), vm.operand(regs, in.C))
+			if err != nil {
+				return err
+			}
+			regs[in.A] = result
+
+		case code.REqual, code.RNotEqual, code.RGreaterThan, code.RLessThan:
+			result, err := vm.compare(in.Op, vm.operand(regs, in.B), vm.operand(regs, in.C))
+			if err != nil {
+				return err
+			}
+			regs[in.A] = nativeBoolToBooleanObject(result)
+
+		case code.RMinus:
+			result, err := negate(vm.operand(regs, in.B), vm.checkedArithmetic)
+			if err != nil {
+				return err
+			}
+			regs[in.A] = result
+
+		case code.RBang:
+			regs[in.A] = bang(vm.operand(regs, in.B))
+
+		case code.RJump:
+			frame.ip = int(in.A) - 1
+
+		case code.RJumpNotTruthy:
+			if !isTruthy(vm.operand(regs, in.B)) {
+				frame.ip = int(in.A) - 1
+			}
+
+		case code.RJumpNull:
+			if vm.operand(regs, in.B) == Null {
+				frame.ip = int(in.A) - 1
+			}
+
+		case code.RJumpNotNull:
+			if vm.operand(regs, in.B) != Null {
+				frame.ip = int(in.A) - 1
+			}
+
+		case code.RJumpNotLess, code.RJumpNotGreater, code.RJumpNotEqual, code.RJumpEqual:
+			result, err := vm.compare(in.Op, vm.operand(regs, in.B), vm.operand(regs, in.C))
+			if err != nil {
+				return err
+			}
+			if !result {
+				frame.ip = int(in.A) - 1
+			}
+
+		case code.RArray:
+			regs[in.A] = buildArray(regs[in.A : in.A+in.B])
+
+		case code.RHash:
+			hash, err := buildHash(regs[in.A : in.A+in.B])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = hash
+
+		case code.RIndex:
+			value, err := indexValue(vm.operand(regs, in.B), vm.operand(regs, in.C))
+			if err != nil {
+				return err
+			}
+			regs[in.A] = value
+
+		case code.RGetField, code.RGetOptionalField:
+			name := vm.constants[in.C].(*object.String).Value
+			optional := in.Op == code.RGetOptionalField
+
+			member, err := getField(vm.operand(regs, in.B), name, optional)
+			if err != nil {
+				return err
+			}
+			regs[in.A] = member
+
+		case code.RCall, code.RTailCall:
+			if err := vm.call(int(in.A), int(in.B), in.Op == code.RTailCall); err != nil {
+				return err
+			}
+
+			frame = &vm.frames[vm.framesIndex-1]
+			ins = frame.fn.instructions
+			regs = vm.registers[frame.base:]
+
+		case code.RClosure:
+			if err := vm.pushClosure(regs, int(in.A), int(in.B), int(in.C)); err != nil {
+				return err
+			}
+
+		case code.RReturnValue, code.RReturn:
+			var value object.Object = Null
+			if in.Op == code.RReturnValue {
+				value = vm.operand(regs, in.A)
+			}
+
+			if vm.framesIndex == 1 {
+				vm.lastPopped = value
+				return nil
+			}
+
+			vm.framesIndex--
+			vm.registers[frame.base-1] = value
+
+			frame = &vm.frames[vm.framesIndex-1]
+			ins = frame.fn.instructions
+			regs = vm.registers[frame.base:]
+
+		case code.RThrow:
+			return vm.throw(vm.operand(regs, in.A))
+
+		case code.RPop:
+			vm.lastPopped = vm.operand(regs, in.A)
+
+		default:
+			return fmt.Errorf("unexpected register opcode: %d", in.Op)
+		}
+	}
+
+	return nil
+}
+
+// operand returns the value of the operand x of an instruction, a register
+// of the frame regs or a constant.
+func (vm *RegisterVM) operand(regs []object.Object, x int32) object.Object {
+	if x < 0 {
+		return vm.constants[-x-1]
+	}
+
+	return regs[x]
+}
+
+// stackOps are the stack opcodes of the register opcodes, for the operations
+// shared with the stack VM.
+var stackOps = map[code.RegisterOp]code.Opcode{
+	code.RAdd:            code.OpAdd,
+	code.RSub:            code.OpSub,
+	code.RMul:            code.OpMul,
+	code.RDiv:            code.OpDiv,
+	code.RMod:            code.OpMod,
+	code.REqual:          code.OpEqual,
+	code.RNotEqual:       code.OpNotEqual,
+	code.RGreaterThan:    code.OpGreaterThan,
+	code.RLessThan:       code.OpLessThan,
+	code.RJumpNotLess:    code.OpLessThan,
+	code.RJumpNotGreater: code.OpGreaterThan,
+	code.RJumpNotEqual:   code.OpEqual,
+	code.RJumpEqual:      code.OpNotEqual,
+}
+
+// arithmetic returns the result of the arithmetic instruction op on left and
+// right, computed on the int64s directly when they don't overflow.
+func (vm *RegisterVM) arithmetic(op code.RegisterOp, left, right object.Object) (object.Object, error) {
+	x, xOk := left.(*object.Integer)
+	y, yOk := right.(*object.Integer)
+
+	if xOk && yOk {
+		var value int64
+		ok := false
+
+		switch op {
+		case code.RAdd:
+			value, ok = object.AddInt64(x.Value, y.Value)
+		case code.RSub:
+			value, ok = object.SubInt64(x.Value, y.Value)
+		case code.RMul:
+			value, ok = object.MulInt64(x.Value, y.Value)
+		}
+
+		if ok {
+			return &object.Integer{Value: value}, nil
+		}
+	}
+
+	return binaryOperation(stackOps[op], left, right, vm.checkedArithmetic)
+}
+
+// compare returns the result of the comparison of left and right made by the
+// comparison or compare-and-jump instruction op.
+func (vm *RegisterVM) compare(op code.RegisterOp, left, right object.Object) (bool, error) {
+	x, xOk := left.(*object.Integer)
+	y, yOk := right.(*object.Integer)
+
+	if xOk && yOk {
+		switch op {
+		case code.REqual, code.RJumpNotEqual:
+			return x.Value == y.Value, nil
+		case code.RNotEqual, code.RJumpEqual:
+			return x.Value != y.Value, nil
+		case code.RGreaterThan, code.RJumpNotGreater:
+			return x.Value > y.Value, nil
+		default:
+			return x.Value < y.Value, nil
+		}
+	}
+
+	result, err := comparison(stackOps[op], left, right)
+	if err != nil {
+		return false, err
+	}
+
+	return result == True, nil
+}
+
+// call calls the callee in the register callee of the current frame with
+// the numArgs arguments in the registers after it. A closure called in tail
+// position replaces the current frame.
+func (vm *RegisterVM) call(callee, numArgs int, tail bool) error {
+	frame := &vm.frames[vm.framesIndex-1]
+	base := frame.base + callee + 1
+	args := vm.registers[base : base+numArgs]
+
+	switch fn := vm.registers[base-1].(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumParameters {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+				fn.Fn.NumParameters, numArgs)
+		}
+
+		translated, err := vm.function(fn.Fn)
+		if err != nil {
+			return err
+		}
+
+		if tail {
+			copy(vm.registers[frame.base-1:], vm.registers[base-1:base+numArgs])
+			base = frame.base
+		} else if vm.framesIndex >= MaxFrames {
+			return fmt.Errorf("stack overflow")
+		}
+
+		if base+translated.numRegisters > len(vm.registers) {
+			return fmt.Errorf("stack overflow")
+		}
+
+		if !tail {
+			vm.framesIndex++
+		}
+		vm.frames[vm.framesIndex-1] = registerFrame{cl: fn, fn: translated, ip: -1, base: base}
+
+		return nil
+
+	case *object.Builtin:
+		result, err := builtinResult(fn.Fn(args...))
+		if err != nil {
+			return err
+		}
+		vm.registers[base-1] = result
+
+		return nil
+
+	case *object.BoundMethod:
+		result, err := builtinResult(fn.Call(args...))
+		if err != nil {
+			return err
+		}
+		vm.registers[base-1] = result
+
+		return nil
+
+	default:
+		return fmt.Errorf("not a function: %s", fn.Type())
+	}
+}
+
+// function returns the translation of fn, translating it on its first call.
+func (vm *RegisterVM) function(fn *object.CompiledFunction) (*registerFunction, error) {
+	if translated, ok := vm.functions[fn]; ok {
+		return translated, nil
+	}
+
+	translated, err := translate(fn, false)
+	if err != nil {
+		return nil, err
+	}
+	vm.functions[fn] = translated
+
+	return translated, nil
+}
+
+// pushClosure puts the closure of the function constant constIndex in the
+// register first of the frame regs, with the numFree free variables in the
+// registers from first on.
+func (vm *RegisterVM) pushClosure(regs []object.Object, first, constIndex, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, regs[first:first+numFree])
+	regs[first] = &object.Closure{Fn: function, Free: free}
+
+	return nil
+}
+
+// runtimeError returns a RuntimeError positioned at the instruction being
+// executed.
+func (vm *RegisterVM) runtimeError(format string, a ...any) error {
+	frame := &vm.frames[vm.framesIndex-1]
+
+	return &RuntimeError{
+		Message: fmt.Sprintf(format, a...),
+		Pos:     frame.fn.sourceMap.Lookup(frame.ip),
+	}
+}
+
+// throw raises value as an error, or rethrows a pending error.
+func (vm *RegisterVM) throw(value object.Object) error {
+	if pending, ok := value.(*pendingError); ok {
+		return pending.err
+	}
+
+	err := vm.runtimeError("uncaught exception: %s", value.Inspect())
+	err.(*RuntimeError).Value = value
+
+	return err
+}
+
+// handle unwinds the frames to the innermost handler of err, puts the value
+// it catches in the register of its stack depth and makes it the next
+// instruction to run. It reports whether there was one.
+func (vm *RegisterVM) handle(err *RuntimeError) bool {
+	for {
+		frame := &vm.frames[vm.framesIndex-1]
+
+		if h, ok := frame.fn.handlers.Lookup(frame.ip); ok {
+			var value object.Object = &pendingError{err: err}
+			if !h.Finally {
+				value = err.caught()
+			}
+			vm.registers[frame.base+frame.cl.Fn.NumLocals+h.Depth] = value
+
+			frame.ip = h.Target - 1
+			return true
+		}
+
+		if vm.framesIndex == 1 {
+			return false
+		}
+
+		vm.framesIndex--
+	}
+}
+
+// wrapError turns err into a RuntimeError, if it isn't one already, and
+// attaches the current call stack to it unless it has one.
+func (vm *RegisterVM) wrapError(err error) *RuntimeError {
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		runtimeErr = vm.runtimeError("%s", err).(*RuntimeError)
+	}
+
+	if runtimeErr.Trace == nil {
+		runtimeErr.Trace = vm.stackTrace()
+	}
+
+	return runtimeErr
+}
+
+// stackTrace returns the active calls, innermost first.
+func (vm *RegisterVM) stackTrace() object.StackTrace {
+	trace := make(object.StackTrace, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		entry := frame.fn.sourceMap.Entry(frame.ip)
+		trace = appendFrames(trace, frame.cl.Fn, i == 0, i < vm.framesIndex-1, entry)
+	}
+
+	return trace
+}
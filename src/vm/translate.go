@@ -0,0 +1,502 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey/src/code"
+	"github.com/ZeroBl21/go-monkey/src/object"
+)
+
+// registerFunction is a compiled function translated into the instructions
+// of the register VM. Its source map and handlers refer to the indexes of
+// the register instructions.
+type registerFunction struct {
+	instructions code.RegisterInstructions
+	sourceMap    code.SourceMap
+	handlers     code.HandlerTable
+	// The registers of a frame: the locals, then one for each value the
+	// function has on the stack at most when run by the stack VM.
+	numRegisters int
+}
+
+// stackInstruction is a decoded instruction of the stack VM.
+type stackInstruction struct {
+	offset   int
+	op       code.Opcode
+	operands []int
+}
+
+// translator translates the instructions of a function for the stack VM
+// into instructions for the register VM. It runs the stack instructions
+// symbolically: each value on the stack is an operand, which is the register
+// of its slot, temp(depth), once it is computed into it, and the local or
+// constant it was loaded from until an instruction needs it in its slot.
+// Loading a local or a constant thus costs no instruction, the instructions
+// using it name the local or the constant directly.
+//
+// Control reaches a jump target or the bounds of a handler with every value
+// in its slot, for all the ways of reaching it to leave the stack the same.
+type translator struct {
+	fn   *object.CompiledFunction
+	main bool
+
+	out       code.RegisterInstructions
+	sourceMap code.SourceMap
+	offset    int
+
+	stack    []int32
+	maxDepth int
+	// Index of the first instruction of the code reached by fall through
+	// only, the instructions the last can be merged with.
+	block int
+
+	// Indexes of the register instructions by the offset of the stack
+	// instructions translated into them.
+	indexes map[int]int
+	// Depth of the stack at the jump targets, by offset.
+	depths map[int]int
+}
+
+// translate translates fn, the main program when main, into register
+// instructions. The main program records the values it pops for
+// LastPoppedStackElem.
+func translate(fn *object.CompiledFunction, main bool) (*registerFunction, error) {
+	decoded, err := decodeStack(fn.Instructions)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &translator{
+		fn:      fn,
+		main:    main,
+		indexes: map[int]int{},
+		depths:  map[int]int{},
+	}
+
+	// The code starts a new block at the bounds of the handlers and at the
+	// targets of the jumps translated before it, all of them forward.
+	bounds := map[int]bool{}
+	for _, h := range fn.Handlers {
+		bounds[h.Start] = true
+		bounds[h.End] = true
+		bounds[h.Target] = true
+		t.depths[h.Target] = h.Depth + 1
+	}
+
+	reachable := true
+	for _, ins := range decoded {
+		t.offset = ins.offset
+
+		if _, known := t.depths[ins.offset]; known || bounds[ins.offset] {
+			if err := t.enterBlock(ins.offset, reachable); err != nil {
+				return nil, err
+			}
+			reachable = reachable || known
+		}
+
+		t.indexes[ins.offset] = len(t.out)
+		if !reachable {
+			continue
+		}
+
+		reachable, err = t.translate(ins)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.indexes[len(fn.Instructions)] = len(t.out)
+
+	for i, ins := range t.out {
+		if ins.IsJump() {
+			t.out[i].A = int32(t.indexes[int(ins.A)])
+		}
+	}
+
+	var handlers code.HandlerTable
+	for _, h := range fn.Handlers {
+		h.Start, h.End, h.Target = t.indexes[h.Start], t.indexes[h.End], t.indexes[h.Target]
+		handlers = append(handlers, h)
+	}
+
+	return &registerFunction{
+		instructions: t.out,
+		sourceMap:    t.sourceMap,
+		handlers:     handlers,
+		numRegisters: fn.NumLocals + t.maxDepth,
+	}, nil
+}
+
+// decodeStack decodes the stack instructions ins.
+func decodeStack(ins code.Instructions) ([]stackInstruction, error) {
+	var decoded []stackInstruction
+
+	for offset := 0; offset < len(ins); {
+		start := offset
+		if code.Opcode(ins[offset]) == code.OpWide && offset+1 < len(ins) {
+			offset++
+		}
+
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected opcode: %d", ins[offset])
+		}
+		if start != offset {
+			def = def.Wide()
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		decoded = append(decoded, stackInstruction{
+			offset:   start,
+			op:       code.Opcode(ins[offset]),
+			operands: operands,
+		})
+
+		offset += 1 + read
+	}
+
+	return decoded, nil
+}
+
+// jumps are the register instructions of the stack jumps.
+var jumps = map[code.Opcode]code.RegisterOp{
+	code.OpJump:           code.RJump,
+	code.OpJumpNotTruthy:  code.RJumpNotTruthy,
+	code.OpJumpNull:       code.RJumpNull,
+	code.OpJumpNotNull:    code.RJumpNotNull,
+	code.OpJumpNotLess:    code.RJumpNotLess,
+	code.OpJumpNotGreater: code.RJumpNotGreater,
+	code.OpJumpNotEqual:   code.RJumpNotEqual,
+	code.OpJumpEqual:      code.RJumpEqual,
+}
+
+// binaryOps are the register instructions of the stack instructions that
+// replace the two values on top of the stack with a result.
+var binaryOps = map[code.Opcode]code.RegisterOp{
+	code.OpAdd:         code.RAdd,
+	code.OpSub:         code.RSub,
+	code.OpMul:         code.RMul,
+	code.OpDiv:         code.RDiv,
+	code.OpMod:         code.RMod,
+	code.OpEqual:       code.REqual,
+	code.OpNotEqual:    code.RNotEqual,
+	code.OpGreaterThan: code.RGreaterThan,
+	code.OpLessThan:    code.RLessThan,
+	code.OpIndex:       code.RIndex,
+}
+
+// callArguments are the numbers of arguments of the stack calls, -1 for the
+// calls that take it as their operand.
+var callArguments = map[code.Opcode]int{
+	code.OpCall:     -1,
+	code.OpTailCall: -1,
+	code.OpCall0:    0,
+	code.OpCall1:    1,
+	code.OpCall2:    2,
+	code.OpCall3:    3,
+}
+
+// enterBlock prepares the translation of the code at offset, which control
+// reaches other than by falling through, and also by falling through when
+// reachable.
+func (t *translator) enterBlock(offset int, reachable bool) error {
+	depth, known := t.depths[offset]
+
+	switch {
+	case reachable && known && depth != len(t.stack):
+		return fmt.Errorf("inconsistent stack depth at %d: %d and %d",
+			offset, depth, len(t.stack))
+
+	case reachable:
+		t.materialize(0)
+
+	case known:
+		t.stack = t.stack[:0]
+		for len(t.stack) < depth {
+			t.push(t.temp(len(t.stack)))
+		}
+	}
+
+	t.block = len(t.out)
+	return nil
+}
+
+// translate translates ins, and reports whether control can fall through to
+// the instruction after it.
+func (t *translator) translate(ins stackInstruction) (bool, error) {
+	op := ins.op
+
+	if rop, ok := binaryOps[op]; ok {
+		right := t.pop()
+		left := t.pop()
+		t.emitPush(rop, left, right)
+		return true, nil
+	}
+
+	if numArgs, ok := callArguments[op]; ok {
+		if numArgs < 0 {
+			numArgs = ins.operands[0]
+		}
+
+		rop := code.RCall
+		if op == code.OpTailCall {
+			rop = code.RTailCall
+		}
+
+		callee := t.popSlots(numArgs + 1)
+		t.emit(rop, callee, int32(numArgs), 0)
+		t.push(callee)
+		return true, nil
+	}
+
+	if rop, ok := jumps[op]; ok {
+		return t.translateJump(rop, ins.operands[0]), nil
+	}
+
+	switch op {
+	case code.OpConstant:
+		t.push(constant(ins.operands[0]))
+
+	case code.OpTrue:
+		t.emitPush(code.RTrue, 0, 0)
+
+	case code.OpFalse:
+		t.emitPush(code.RFalse, 0, 0)
+
+	case code.OpNull:
+		t.emitPush(code.RNull, 0, 0)
+
+	case code.OpGetLocal:
+		t.push(int32(ins.operands[0]))
+
+	case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+		t.push(int32(op - code.OpGetLocal0))
+
+	case code.OpSetLocal:
+		t.setLocal(int32(ins.operands[0]))
+
+	case code.OpGetGlobal:
+		t.emitPush(code.RGetGlobal, int32(ins.operands[0]), 0)
+
+	case code.OpSetGlobal:
+		t.emit(code.RSetGlobal, int32(ins.operands[0]), t.pop(), 0)
+
+	case code.OpGetBuiltin:
+		t.emitPush(code.RGetBuiltin, int32(ins.operands[0]), 0)
+
+	case code.OpGetFree:
+		t.emitPush(code.RGetFree, int32(ins.operands[0]), 0)
+
+	case code.OpCurrentClosure:
+		t.emitPush(code.RCurrentClosure, 0, 0)
+
+	case code.OpAddConstant:
+		t.emitPush(code.RAdd, t.pop(), constant(ins.operands[0]))
+
+	case code.OpSubConstant:
+		t.emitPush(code.RSub, t.pop(), constant(ins.operands[0]))
+
+	case code.OpMinus:
+		t.emitPush(code.RMinus, t.pop(), 0)
+
+	case code.OpBang:
+		t.emitPush(code.RBang, t.pop(), 0)
+
+	case code.OpGetField:
+		t.emitPush(code.RGetField, t.pop(), int32(ins.operands[0]))
+
+	case code.OpGetOptionalField:
+		t.emitPush(code.RGetOptionalField, t.pop(), int32(ins.operands[0]))
+
+	case code.OpArray, code.OpHash:
+		rop := code.RArray
+		if op == code.OpHash {
+			rop = code.RHash
+		}
+
+		n := ins.operands[0]
+		first := t.popSlots(n)
+		t.emit(rop, first, int32(n), 0)
+		t.push(first)
+
+	case code.OpClosure:
+		numFree := ins.operands[1]
+		first := t.popSlots(numFree)
+		t.emit(code.RClosure, first, int32(ins.operands[0]), int32(numFree))
+		t.push(first)
+
+	case code.OpDup:
+		t.push(t.stack[len(t.stack)-1])
+
+	case code.OpPop:
+		value := t.pop()
+		if t.main {
+			t.emit(code.RPop, value, 0, 0)
+		} else {
+			t.dropUnused(value)
+		}
+
+	case code.OpReturnValue:
+		t.emit(code.RReturnValue, t.pop(), 0, 0)
+		return false, nil
+
+	case code.OpReturn:
+		t.emit(code.RReturn, 0, 0, 0)
+		return false, nil
+
+	case code.OpThrow:
+		t.emit(code.RThrow, t.pop(), 0, 0)
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("unexpected opcode: %d", op)
+	}
+
+	return true, nil
+}
+
+// translateJump translates the jump to target, and reports whether control
+// can fall through to the instruction after it.
+func (t *translator) translateJump(op code.RegisterOp, target int) bool {
+	var b, c int32
+
+	switch op {
+	case code.RJumpNotTruthy:
+		b = t.pop()
+	case code.RJumpNotLess, code.RJumpNotGreater, code.RJumpNotEqual, code.RJumpEqual:
+		c = t.pop()
+		b = t.pop()
+	}
+
+	t.materialize(0)
+
+	if op == code.RJumpNull || op == code.RJumpNotNull {
+		b = t.temp(len(t.stack) - 1)
+	}
+
+	t.emit(op, int32(target), b, c)
+	t.depths[target] = len(t.stack)
+
+	return op != code.RJump
+}
+
+// setLocal translates the assignment of the value on top of the stack to
+// the local register.
+func (t *translator) setLocal(register int32) {
+	value := t.pop()
+
+	// The values still to be loaded from the local are loaded first.
+	for depth, operand := range t.stack {
+		if operand == register {
+			t.emit(code.RMove, t.temp(depth), register, 0)
+			t.stack[depth] = t.temp(depth)
+		}
+	}
+
+	if value == register {
+		return
+	}
+
+	// A value just computed into a slot is computed into the local instead,
+	// and the copies of it still on the stack load it from there.
+	last := len(t.out) - 1
+	if value >= t.temp(0) && last >= t.block &&
+		t.out[last].A == value && retargetable[t.out[last].Op] {
+		t.out[last].A = register
+		for depth, operand := range t.stack {
+			if operand == value {
+				t.stack[depth] = register
+			}
+		}
+		return
+	}
+
+	t.emit(code.RMove, register, value, 0)
+}
+
+// retargetable are the register instructions whose A register is only the
+// one their result goes to.
+var retargetable = map[code.RegisterOp]bool{
+	code.RMove: true, code.RTrue: true, code.RFalse: true, code.RNull: true,
+	code.RGetGlobal: true, code.RGetBuiltin: true, code.RGetFree: true,
+	code.RCurrentClosure: true, code.RAdd: true, code.RSub: true,
+	code.RMul: true, code.RDiv: true, code.RMod: true, code.REqual: true,
+	code.RNotEqual: true, code.RGreaterThan: true, code.RLessThan: true, code.RMinus: true,
+	code.RBang: true, code.RIndex: true, code.RGetField: true,
+	code.RGetOptionalField: true,
+}
+
+// dropUnused removes the instruction that just computed value into its slot,
+// popped without being used, when it can't fail.
+func (t *translator) dropUnused(value int32) {
+	last := len(t.out) - 1
+	if value == t.temp(len(t.stack)) && last >= t.block &&
+		t.out[last].A == value && pure[t.out[last].Op] {
+		t.out = t.out[:last]
+		t.sourceMap = t.sourceMap.Truncate(last)
+	}
+}
+
+// pure are the register instructions that do nothing but write their A
+// register.
+var pure = map[code.RegisterOp]bool{
+	code.RMove: true, code.RTrue: true, code.RFalse: true, code.RNull: true,
+	code.RGetGlobal: true, code.RGetBuiltin: true, code.RGetFree: true,
+	code.RCurrentClosure: true,
+}
+
+// temp returns the register of the stack slot at depth.
+func (t *translator) temp(depth int) int32 {
+	return int32(t.fn.NumLocals + depth)
+}
+
+// constant returns the operand of the constant at index.
+func constant(index int) int32 {
+	return int32(-index - 1)
+}
+
+func (t *translator) push(operand int32) {
+	t.stack = append(t.stack, operand)
+	t.maxDepth = max(t.maxDepth, len(t.stack))
+}
+
+func (t *translator) pop() int32 {
+	operand := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+
+	return operand
+}
+
+// materialize moves the values on the stack from depth up into their slots.
+func (t *translator) materialize(depth int) {
+	for d := depth; d < len(t.stack); d++ {
+		if t.stack[d] != t.temp(d) {
+			t.emit(code.RMove, t.temp(d), t.stack[d], 0)
+			t.stack[d] = t.temp(d)
+		}
+	}
+}
+
+// popSlots moves the top n values on the stack into their slots and pops
+// them, returning the register of the first one, for the instructions that
+// take their operands in consecutive registers.
+func (t *translator) popSlots(n int) int32 {
+	depth := len(t.stack) - n
+	t.materialize(depth)
+	t.stack = t.stack[:depth]
+
+	return t.temp(depth)
+}
+
+// emitPush emits op with the result going to a new slot on top of the stack.
+func (t *translator) emitPush(op code.RegisterOp, b, c int32) {
+	register := t.temp(len(t.stack))
+	t.emit(op, register, b, c)
+	t.push(register)
+}
+
+func (t *translator) emit(op code.RegisterOp, a, b, c int32) {
+	entry := t.fn.SourceMap.Entry(t.offset)
+	entry.Offset = len(t.out)
+	t.sourceMap = t.sourceMap.AddEntry(entry)
+	t.out = append(t.out, code.RegisterInstruction{Op: op, A: a, B: b, C: c})
+}
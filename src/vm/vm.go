@@ -30,13 +30,24 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	checkedArithmetic bool
 }
 
 const MaxFrames = 1024
 
+// Machine runs the bytecode of the compiler. VM and RegisterVM implement it.
+type Machine interface {
+	Run() error
+	LastPoppedStackElem() object.Object
+	SetCheckedArithmetic(checked bool)
+}
+
 func New(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{
 		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Handlers:     bytecode.Handlers,
 	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
@@ -64,6 +75,12 @@ func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetCheckedArithmetic makes integer results that don't fit in an int64 a
+// runtime error instead of promoting them to BigInts.
+func (vm *VM) SetCheckedArithmetic(checked bool) {
+	vm.checkedArithmetic = checked
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -87,7 +104,23 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// Run executes the bytecode. Errors that no handler catches are returned as
+// a *RuntimeError carrying the call stack at the point of failure.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		runtimeErr := vm.wrapError(err)
+		if !vm.handle(runtimeErr) {
+			return runtimeErr
+		}
+	}
+}
+
+func (vm *VM) run() error {
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
@@ -95,22 +128,39 @@ func (vm *VM) Run() error {
 		ins := vm.currentFrame().Instructions()
 		op := code.Opcode(ins[ip])
 
+		wide := op == code.OpWide
+		if wide {
+			vm.currentFrame().ip++
+			ip++
+			op = code.Opcode(ins[ip])
+		}
+
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			constIndex := vm.readOperand(ins, 2, wide)
 			if err := vm.push(vm.constants[constIndex]); err != nil {
 				return err
 			}
 
-		case code.OpAdd, code.OpMul, code.OpSub, code.OpDiv:
+		case code.OpAdd, code.OpMul, code.OpSub, code.OpDiv, code.OpMod:
 			if err := vm.executeBinaryOperation(op); err != nil {
 				return err
 			}
 
+		case code.OpAddConstant, code.OpSubConstant:
+			constIndex := vm.readOperand(ins, 2, wide)
+			if err := vm.executeConstantOperation(op, vm.constants[constIndex]); err != nil {
+				return err
+			}
+
 		case code.OpPop:
 			vm.pop()
 
+		case code.OpDup:
+			if err := vm.push(vm.stack[vm.sp-1]); err != nil {
+				return err
+			}
+
 		// Literals
 
 		case code.OpTrue:
@@ -129,8 +179,7 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := vm.readOperand(ins, 2, wide)
 
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
@@ -140,8 +189,7 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := vm.readOperand(ins, 2, wide)
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -161,20 +209,38 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpGetField, code.OpGetOptionalField:
+			nameIndex := vm.readOperand(ins, 2, wide)
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			optional := op == code.OpGetOptionalField
+			if err := vm.executeGetField(vm.pop(), name, optional); err != nil {
+				return err
+			}
+
 		// Functions
 		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			numArgs := vm.readOperand(ins, 1, wide)
 
 			if err := vm.executeCall(int(numArgs)); err != nil {
 				return err
 			}
 
+		case code.OpCall0, code.OpCall1, code.OpCall2, code.OpCall3:
+			if err := vm.executeCall(int(op - code.OpCall0)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := vm.readOperand(ins, 1, wide)
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
 		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			// Manual increment index
-			vm.currentFrame().ip += 3
+			constIndex := vm.readOperand(ins, 2, wide)
+			numFree := vm.readOperand(ins, 1, wide)
 
 			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
 				return err
@@ -198,9 +264,12 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpThrow:
+			return vm.throw(vm.pop())
+
 		// Relational
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
 			if err := vm.executeComparison(op); err != nil {
 				return err
 			}
@@ -216,43 +285,64 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
+			pos := vm.readOperand(ins, 2, wide)
 			vm.currentFrame().ip = pos - 1
 
+		case code.OpJumpNull:
+			pos := vm.readOperand(ins, 2, wide)
+
+			if vm.StackTop() == Null {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNotNull:
+			pos := vm.readOperand(ins, 2, wide)
+
+			if vm.StackTop() != Null {
+				vm.currentFrame().ip = pos - 1
+			}
+
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			pos := vm.readOperand(ins, 2, wide)
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
 
+		case code.OpJumpNotLess, code.OpJumpNotGreater, code.OpJumpNotEqual,
+			code.OpJumpEqual:
+			pos := vm.readOperand(ins, 2, wide)
+
+			jump, err := vm.executeCompareJump(op)
+			if err != nil {
+				return err
+			}
+			if jump {
+				vm.currentFrame().ip = pos - 1
+			}
+
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			globalIndex := vm.readOperand(ins, 2, wide)
 
 			vm.globals[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			globalIndex := vm.readOperand(ins, 2, wide)
 
 			if err := vm.push(vm.globals[globalIndex]); err != nil {
 				return err
 			}
 
 		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := vm.readOperand(ins, 1, wide)
 
 			frame := vm.currentFrame()
 
 			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 
 		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := vm.readOperand(ins, 1, wide)
 
 			frame := vm.currentFrame()
 
@@ -261,9 +351,16 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+			frame := vm.currentFrame()
+
+			err := vm.push(vm.stack[frame.basePointer+int(op-code.OpGetLocal0)])
+			if err != nil {
+				return err
+			}
+
 		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			builtinIndex := vm.readOperand(ins, 1, wide)
 
 			definition := object.Builtins[builtinIndex]
 
@@ -272,16 +369,20 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			freeIndex := vm.readOperand(ins, 1, wide)
 
 			currentClosure := vm.currentFrame().cl
 			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
 				return err
 			}
 
+		case code.OpCurrentClosure:
+			if err := vm.push(vm.currentFrame().cl); err != nil {
+				return err
+			}
+
 		default:
-			panic(fmt.Sprintf("unexpected code.Opcode: %+v", op))
+			return vm.runtimeError("unexpected opcode: %d", op)
 		}
 
 	}
@@ -289,9 +390,24 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// readOperand reads the next operand of the current instruction, width bytes
+// wide, or twice that after OpWide, and moves the instruction pointer past
+// it.
+func (vm *VM) readOperand(ins code.Instructions, width int, wide bool) int {
+	frame := vm.currentFrame()
+	if wide {
+		width *= 2
+	}
+
+	operand := code.ReadOperand(ins[frame.ip+1:], width)
+	frame.ip += width
+
+	return operand
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return vm.runtimeError("stack overflow")
 	}
 
 	vm.stack[vm.sp] = o
@@ -338,17 +454,46 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.BoundMethod:
+		return vm.callBoundMethod(callee, numArgs)
 	default:
-		return fmt.Errorf("calling non-function and non-built-in")
+		return fmt.Errorf("not a function: %s", callee.Type())
 	}
 }
 
+// executeTailCall calls like executeCall, except that a closure replaces the
+// frame of the calling function, whose result is the result of the call, so
+// that loops written as tail calls run in constant frame depth.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || numArgs != cl.Fn.NumParameters {
+		return vm.executeCall(numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if !fits(frame.basePointer, cl.Fn) {
+		return vm.runtimeError("stack overflow")
+	}
+
+	callee := vm.sp - 1 - numArgs
+	copy(vm.stack[frame.basePointer-1:], vm.stack[callee:vm.sp])
+
+	vm.frames[vm.framesIndex-1] = NewFrame(cl, frame.basePointer)
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
 
+	if vm.framesIndex >= MaxFrames || !fits(vm.sp-numArgs, cl.Fn) {
+		return vm.runtimeError("stack overflow")
+	}
+
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
 
@@ -357,249 +502,175 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	return nil
 }
 
+// fits reports whether the stack has room for a frame of fn starting at
+// basePointer, for a call to overflow the stack at the call instead of in
+// the middle of fn.
+func fits(basePointer int, fn *object.CompiledFunction) bool {
+	return basePointer+fn.NumLocals+fn.MaxStackDepth <= StackSize
+}
+
 func (vm *VM) callBuiltin(fn *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
 	result := fn.Fn(args...)
 	vm.sp = vm.sp - numArgs - 1
 
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
-	}
-
-	return nil
+	return vm.pushBuiltinResult(result)
 }
 
-func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
-	elements := make([]object.Object, endIndex-startIndex)
+func (vm *VM) callBoundMethod(method *object.BoundMethod, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	for i := startIndex; i < endIndex; i++ {
-		elements[i-startIndex] = vm.stack[i]
-	}
+	result := method.Call(args...)
+	vm.sp = vm.sp - numArgs - 1
 
-	return &object.Array{Elements: elements}
+	return vm.pushBuiltinResult(result)
 }
 
-func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
-
-	for i := startIndex; i < endIndex; i += 2 {
-		key := vm.stack[i]
-		value := vm.stack[i+1]
-
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
-		}
-
-		hashedPairs[hashKey.HashKey()] = pair
+// pushBuiltinResult pushes the value returned by a builtin, turning errors
+// into runtime errors at the call site.
+func (vm *VM) pushBuiltinResult(result object.Object) error {
+	value, err := builtinResult(result)
+	if err != nil {
+		return err
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return vm.push(value)
 }
 
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
-
-	case left.Type() == object.HASH_OBJ:
-		return vm.executeHashIndex(left, index)
-
-	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
-	}
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	return buildArray(vm.stack[startIndex:endIndex])
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	return buildHash(vm.stack[startIndex:endIndex])
+}
 
-	if idx < 0 || idx > max {
-		return vm.push(Null)
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	value, err := indexValue(left, index)
+	if err != nil {
+		return err
 	}
 
-	return vm.push(arrayObject.Elements[idx])
+	return vm.push(value)
 }
 
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	hashObject := hash.(*object.Hash)
-
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
-	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
-	if !ok {
-		return vm.push(Null)
+func (vm *VM) executeGetField(
+	obj object.Object,
+	name string,
+	optional bool,
+) error {
+	member, err := getField(obj, name, optional)
+	if err != nil {
+		return err
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(member)
 }
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	rightType := right.Type()
-	leftType := left.Type()
-
-	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
-		return vm.executeBinaryIntegerOperation(op, left, right)
-
-	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
-		return vm.executeBinaryStringOperation(op, left, right)
-
-	default:
-		return fmt.Errorf(
-			"unsupported types for binary operation: %s %s",
-			leftType,
-			rightType,
-		)
+	result, err := binaryOperation(op, left, right, vm.checkedArithmetic)
+	if err != nil {
+		return err
 	}
-}
 
-func (vm *VM) executeBinaryIntegerOperation(
-	op code.Opcode,
-	left, right object.Object,
-) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	var result int64
-
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	case code.OpSub:
-		result = leftValue - rightValue
-	case code.OpMul:
-		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
-	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
-	}
-
-	return vm.push(&object.Integer{
-		Value: result,
-	})
+	return vm.push(result)
 }
 
-func (vm *VM) executeBinaryStringOperation(
-	op code.Opcode,
-	left, right object.Object,
-) error {
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
+// executeConstantOperation runs OpAddConstant and OpSubConstant, whose right
+// operand is the integer constant right. Integers that don't overflow are
+// handled in place on the stack, anything else as by OpAdd and OpSub.
+func (vm *VM) executeConstantOperation(op code.Opcode, right object.Object) error {
+	left, leftOk := vm.stack[vm.sp-1].(*object.Integer)
+	constant, rightOk := right.(*object.Integer)
+
+	if leftOk && rightOk {
+		var value int64
+		var ok bool
+
+		if op == code.OpAddConstant {
+			value, ok = object.AddInt64(left.Value, constant.Value)
+		} else {
+			value, ok = object.SubInt64(left.Value, constant.Value)
+		}
 
-	var result string
+		if ok {
+			vm.stack[vm.sp-1] = &object.Integer{Value: value}
+			return nil
+		}
+	}
 
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	default:
-		return fmt.Errorf("unknown string operator: %d", op)
+	if err := vm.push(right); err != nil {
+		return err
 	}
 
-	return vm.push(&object.String{
-		Value: result,
-	})
+	if op == code.OpAddConstant {
+		return vm.executeBinaryOperation(code.OpAdd)
+	}
+	return vm.executeBinaryOperation(code.OpSub)
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
+	result, err := comparison(op, left, right)
+	if err != nil {
+		return err
 	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
-	default:
-		return fmt.Errorf(
-			"unknown operator: %d (%s %s)",
-			op,
-			left.Type(),
-			right.Type(),
-		)
-	}
+	return vm.push(result)
 }
 
-func (vm *VM) executeIntegerComparison(
-	op code.Opcode,
-	left, right object.Object,
-) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
-	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
-	default:
-		return fmt.Errorf("unknown operator: %d", op)
-	}
+// comparisons are the comparisons of the compare-and-jump instructions.
+var comparisons = map[code.Opcode]code.Opcode{
+	code.OpJumpNotLess:    code.OpLessThan,
+	code.OpJumpNotGreater: code.OpGreaterThan,
+	code.OpJumpNotEqual:   code.OpEqual,
+	code.OpJumpEqual:      code.OpNotEqual,
 }
 
-func nativeBoolToBooleanObject(input bool) *object.Boolean {
-	if input {
-		return True
-	}
+// executeCompareJump pops the two values compared by the compare-and-jump
+// instruction op, and reports whether it jumps, which is when the comparison
+// is false.
+func (vm *VM) executeCompareJump(op code.Opcode) (bool, error) {
+	left, leftOk := vm.stack[vm.sp-2].(*object.Integer)
+	right, rightOk := vm.stack[vm.sp-1].(*object.Integer)
 
-	return False
-}
+	if leftOk && rightOk {
+		vm.sp -= 2
 
-func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
-
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
-		return vm.push(False)
+		switch op {
+		case code.OpJumpNotLess:
+			return left.Value >= right.Value, nil
+		case code.OpJumpNotGreater:
+			return left.Value <= right.Value, nil
+		case code.OpJumpNotEqual:
+			return left.Value != right.Value, nil
+		default:
+			return left.Value == right.Value, nil
+		}
 	}
-}
 
-func (vm *VM) executeMinusOperator() error {
-	operand := vm.pop()
-
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	if err := vm.executeComparison(comparisons[op]); err != nil {
+		return false, err
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return !isTruthy(vm.pop()), nil
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-
-	case *object.Boolean:
-		return obj.Value
-
-	case *object.Null:
-		return false
+func (vm *VM) executeBangOperator() error {
+	return vm.push(bang(vm.pop()))
+}
 
-	default:
-		return true
+func (vm *VM) executeMinusOperator() error {
+	result, err := negate(vm.pop(), vm.checkedArithmetic)
+	if err != nil {
+		return err
 	}
+
+	return vm.push(result)
 }
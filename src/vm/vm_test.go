@@ -2,15 +2,24 @@ package vm
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey/src/ast"
+	"github.com/ZeroBl21/go-monkey/src/code"
 	"github.com/ZeroBl21/go-monkey/src/compiler"
+	"github.com/ZeroBl21/go-monkey/src/evaluator"
 	"github.com/ZeroBl21/go-monkey/src/lexer"
 	"github.com/ZeroBl21/go-monkey/src/object"
 	"github.com/ZeroBl21/go-monkey/src/parser"
 )
 
+// hashPair is an expected pair of a hash with an integer value.
+type hashPair struct {
+	key   object.Hashable
+	value int64
+}
+
 type vmTestCase struct {
 	input    string
 	expected any
@@ -35,11 +44,70 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + - 50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 * 7 % 4", 2},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected string
+	}{
+		{"1 / 0", false, "1:3: division by zero"},
+		{"1 % 0", false, "1:3: division by zero"},
+		{"let f = fn(x) {\n  x / (x - 1)\n}; f(1)", false,
+			"2:5: division by zero"},
+		{"9223372036854775807 + 1", true,
+			"1:21: integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", true,
+			"1:22: integer overflow: -9223372036854775807 - 2"},
+		{"let f = fn(x) { x * x }; f(4294967296)", true,
+			"1:19: integer overflow: 4294967296 * 4294967296"},
+		{"let f = fn(x) {\n  x + 1\n}; f(9223372036854775807)", true,
+			"2:5: integer overflow: 9223372036854775807 + 1"},
+		{"(-9223372036854775807 - 1) / -1", true,
+			"1:28: integer overflow: -9223372036854775808 / -1"},
+		{"-(-9223372036854775807 - 1)", true,
+			"1:1: integer overflow: -(-9223372036854775808)"},
+	}
+
+	for _, level := range optimizationLevels {
+		for _, tt := range tests {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			for _, engine := range engines {
+				vm := engine.new(comp.Bytecode())
+				vm.SetCheckedArithmetic(tt.checked)
+
+				err := vm.Run()
+				if err == nil {
+					t.Errorf("%s: %s: expected VM error but resulted in none.",
+						engine.name, tt.input)
+					continue
+				}
+
+				if err.Error() != tt.expected {
+					t.Errorf("%s: %s: wrong VM error: want=%q, got=%q",
+						engine.name, tt.input, tt.expected, err)
+				}
+
+				if _, ok := err.(*RuntimeError); !ok {
+					t.Errorf("%s: %s: error is not a *RuntimeError. got=%T",
+						engine.name, tt.input, err)
+				}
+			}
+		}
+	}
+}
+
 func TestStringExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`"monkey"`, "monkey"},
@@ -94,19 +162,26 @@ func TestArrayLiterals(t *testing.T) {
 
 func TestHashLiterals(t *testing.T) {
 	tests := []vmTestCase{
-		{"{}", map[object.HashKey]int64{}},
+		{"{}", []hashPair{}},
 		{
 			"{1: 2, 2: 3}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 1}).HashKey(): 2,
-				(&object.Integer{Value: 2}).HashKey(): 3,
+			[]hashPair{
+				{&object.Integer{Value: 1}, 2},
+				{&object.Integer{Value: 2}, 3},
 			},
 		},
 		{
 			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 2}).HashKey(): 4,
-				(&object.Integer{Value: 6}).HashKey(): 16,
+			[]hashPair{
+				{&object.Integer{Value: 2}, 4},
+				{&object.Integer{Value: 6}, 16},
+			},
+		},
+		{
+			`{"b": 1, "a": 2, "b": 3}`,
+			[]hashPair{
+				{&object.String{Value: "b"}, 3},
+				{&object.String{Value: "a"}, 2},
 			},
 		},
 	}
@@ -131,6 +206,116 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestMemberExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"title": 5}.title`, 5},
+		{`let book = {"pages": {"count": 5}}; book.pages.count`, 5},
+		{`let obj = {"double": fn(x) { x * 2 }}; obj.double(3)`, 6},
+		{`{"len": 5}.len`, 5},
+		{`[1, 2, 3].len()`, 3},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`[].push(1).last()`, 1},
+		{`"hello".len()`, 5},
+		{`"Hello".upper()`, "HELLO"},
+		{`"Hello".lower()`, "hello"},
+		{`let up = "abc".upper; up()`, "ABC"},
+		{`fn(s) { s.upper() }("abc")`, "ABC"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestNullSafeExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`null`, Null},
+		{`null == null`, true},
+		{`null ?? 5`, 5},
+		{`3 ?? 5`, 3},
+		{`false ?? 5`, false},
+		{`null ?? null ?? 7`, 7},
+		{`1 ?? -true`, 1},
+		{`let a = null; a?.b`, Null},
+		{`let a = null; a?.b.c.d`, Null},
+		{`let a = null; a?.b.c()`, Null},
+		{`let a = null; a?["b"]["c"]`, Null},
+		{`let a = {"b": {"c": 1}}; a?.b?.c`, 1},
+		{`let a = {"b": {"c": 1}}; a?.b.c`, 1},
+		{`let a = {"b": null}; a.b?.c.d`, Null},
+		{`let a = {"b": {}}; a.b?.c`, Null},
+		{`let a = {"b": [1, 2]}; a?.b?[1]`, 2},
+		{`let a = null; a?.b ?? 9`, 9},
+		{`let a = {"f": fn() { null }}; a.f()?.g ?? 4`, 4},
+		{`let xs = [[1]]; xs[1]?[0]`, Null},
+		{`let get = fn(h) { h?.x?.y ?? 0 }; get(null) + get({"x": {"y": 2}})`, 2},
+		{`[null?.a, 1][1]`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMemberExpressionErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"title": 5}.author`, `1:13: no field or method "author" on HASH`},
+		{`5.len()`, `1:2: no field or method "len" on INTEGER`},
+		{`"abc".push(1)`, `1:6: no field or method "push" on STRING`},
+		{`let a = {"b": {}}; a?.b.c`, `1:24: no field or method "c" on HASH`},
+		{`null ?? -true`, `1:9: unknown operator: -BOOLEAN`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", engine.name)
+			}
+
+			if err.Error() != tt.expected {
+				t.Fatalf("%s: wrong VM error: want=%q, got=%q",
+					engine.name, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestHashLiteralEvaluationOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"b": -true, "a": 1 + true}`, `1:7: unknown operator: -BOOLEAN`},
+		{
+			`{"b": 1 + true, "a": -true}`,
+			`1:9: type mismatch: INTEGER + BOOLEAN`,
+		},
+		{`{-true: 1, "a": 1 + true}`, `1:2: unknown operator: -BOOLEAN`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", engine.name)
+			}
+
+			if err.Error() != tt.expected {
+				t.Fatalf("%s: wrong VM error: want=%q, got=%q",
+					engine.name, tt.expected, err)
+			}
+		}
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -290,15 +475,15 @@ func TestCallingFunctionsWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:12: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:13: wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:20: wrong number of arguments: want=2, got=1`,
 		},
 	}
 
@@ -310,14 +495,16 @@ func TestCallingFunctionsWrongArguments(t *testing.T) {
 			t.Fatalf("compiler error: %s", err)
 		}
 
-		vm := New(comp.Bytecode())
-		err := vm.Run()
-		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
-		}
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", engine.name)
+			}
 
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			if err.Error() != tt.expected {
+				t.Fatalf("%s: wrong VM error: want=%q, got=%q",
+					engine.name, tt.expected, err)
+			}
 		}
 	}
 }
@@ -387,18 +574,6 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got=INTEGER",
-			},
-		},
-		{
-			`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 
@@ -406,37 +581,203 @@ func TestBuiltinFunctions(t *testing.T) {
 
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{
-			`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got=INTEGER",
-			},
-		},
 
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{
-			`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got=INTEGER",
-			},
-		},
 
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 
 		{`push([], 1)`, []int{1}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(1)`, "1:4: argument to `len` not supported, got=INTEGER"},
+		{`len("one", "two")`, "1:4: wrong number of arguments. got=2, want=1"},
+		{`first("x")`, "1:6: argument to `first` must be ARRAY, got=STRING"},
+		{`last(1)`, "1:5: argument to `last` must be ARRAY, got=INTEGER"},
+		{`push(1, 1)`, "1:5: argument to `push` must be ARRAY, got=INTEGER"},
+		{`"a".upper(1)`, "1:10: wrong number of arguments. got=2, want=1"},
+		{"let f = fn(x) {\n  rest(x)\n};\nf(1); 2", "2:7: argument to `rest` must be ARRAY, got=INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+			if err == nil {
+				t.Errorf("%s: %s: expected VM error but resulted in none.",
+					engine.name, tt.input)
+				continue
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: %s: wrong VM error: want=%q, got=%q",
+					engine.name, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	elements := make([]string, 3000)
+	for i := range elements {
+		elements[i] = fmt.Sprint(i)
+	}
+
+	tests := []string{
+		"let f = fn(n) { f(n + 1) + 1 }; f(0)",
+		// Too many values for the stack of the main program.
+		"[" + strings.Join(elements, ", ") + "]",
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Errorf("%s: %.40s: expected a runtime error, got=%v",
+					engine.name, input, err)
+				continue
+			}
+
+			if runtimeErr.Message != "stack overflow" {
+				t.Errorf("%s: %.40s: wrong VM error: want=%q, got=%q",
+					engine.name, input, "stack overflow", runtimeErr.Message)
+			}
+		}
+	}
+}
+
+func TestCatchingRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
 		{
-			`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got=INTEGER",
-			},
+			"try { len(1) } catch (e) { e.message }",
+			"argument to `len` not supported, got=INTEGER",
 		},
+		{"try { -true } catch (e) { e.message }", "unknown operator: -BOOLEAN"},
+		{"try { fn(a) { a }() } catch (e) { e.message }", "wrong number of arguments: want=1, got=0"},
+		{`try { {}.x } catch (e) { e.message }`, `no field or method "x" on HASH`},
+		{"let f = fn(n) { f(n + 1) + 1 }; try { f(0) } catch (e) { e.message }", "stack overflow"},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		value    object.Object
+	}{
+		{"throw 1", "1:1: uncaught exception: 1", &object.Integer{Value: 1}},
+		{"try { 1 / 0 } finally { 2 }", "1:9: division by zero", nil},
+		{
+			`let f = fn() { throw "x" }; try { f() } finally { 1 }`,
+			"1:16: uncaught exception: x",
+			&object.String{Value: "x"},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			err := engine.new(comp.Bytecode()).Run()
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Errorf("%s: %s: not a *RuntimeError. got=%T (%v)",
+					engine.name, tt.input, err, err)
+				continue
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: %s: wrong VM error: want=%q, got=%q",
+					engine.name, tt.input, tt.expected, err)
+			}
+
+			if (runtimeErr.Value == nil) != (tt.value == nil) ||
+				tt.value != nil && !object.Equal(runtimeErr.Value, tt.value) {
+				t.Errorf("%s: %s: wrong thrown value. want=%v, got=%v",
+					engine.name, tt.input, tt.value, runtimeErr.Value)
+			}
+		}
+	}
+}
+
+func TestRegisterTranslation(t *testing.T) {
+	input := `fn(n) {
+		let total = 1;
+		if (n < 2) { return n }
+		let doubled = n * 2 + total;
+		[doubled, n - 1]
+	}`
+
+	expected := `0000 RMove r1 k0
+0001 RJumpNotLess 3 r0 k1
+0002 RReturnValue r0
+0003 RMul r3 r0 k1
+0004 RAdd r2 r3 r1
+0005 RSub r4 r0 k0
+0006 RMove r3 r2
+0007 RArray r3 2
+0008 RReturnValue r3
+`
+
+	comp := compiler.New(compiler.WithOptimization(compiler.OptimizeSpecialize))
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var fn *object.CompiledFunction
+	for _, constant := range comp.Bytecode().Constants {
+		if f, ok := constant.(*object.CompiledFunction); ok {
+			fn = f
+		}
+	}
+
+	translated, err := translate(fn, false)
+	if err != nil {
+		t.Fatalf("translation error: %s", err)
+	}
+
+	if got := translated.instructions.String(); got != expected {
+		t.Errorf("wrong register instructions.\nstack=%s\nwant=%s\ngot=%s",
+			fn.Instructions, expected, got)
+	}
+}
+
+func TestUnknownOpcode(t *testing.T) {
+	for _, engine := range engines {
+		vm := engine.new(&compiler.Bytecode{Instructions: code.Instructions{255}})
+
+		err := vm.Run()
+		if err == nil || err.Error() != "unexpected opcode: 255" {
+			t.Errorf("%s: wrong VM error. got=%v", engine.name, err)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -536,6 +877,120 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let iter = fn(i, sum) {
+				if (i == 1000000) {
+					sum
+				} else {
+					iter(i + 1, sum + i)
+				}
+			};
+
+			iter(0, 0)
+			`,
+			expected: 499999500000,
+		},
+		{
+			// The frame of a closure reused for a closure with more locals.
+			input: `
+			let big = fn(n) { let a = n; let b = a + 1; b };
+			let small = fn(n) { big(n) };
+			[small(1), small(2)]
+			`,
+			expected: []int{2, 3},
+		},
+		{`let f = fn(a) { len(a) }; f([1, 2])`, 2},
+		{`let f = fn(g) { g(1) }; f(fn(x) { x + 1 })`, 2},
+		{
+			// A recursive local closure loops in constant frame depth too.
+			input: `
+			let sum = fn(n) {
+				let iter = fn(i, sum) {
+					if (i == n) {
+						sum
+					} else {
+						iter(i + 1, sum + i)
+					}
+				};
+
+				iter(0, 0)
+			};
+
+			sum(1000000)
+			`,
+			expected: 499999500000,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWideOperands(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{wideProgram(), []int{299, 299, 44850, 70000, 70002, 5}},
+	})
+}
+
+// wideProgram generates a program past every limit of the operands of the
+// instructions: a function with more than 255 locals, a call with more than
+// 255 arguments, a closure with more than 255 free variables, more than
+// 65,535 constants and jumps past 64 KB.
+func wideProgram() string {
+	// Identifiers are made of letters only, and mustn't be keywords.
+	name := func(prefix string, i int) string {
+		return fmt.Sprintf("%s_%c%c", prefix, 'a'+i/26, 'a'+i%26)
+	}
+	names := func(prefix string, n int) []string {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = name(prefix, i)
+		}
+		return names
+	}
+	lets := func(prefix string, n int) string {
+		var out strings.Builder
+		for i, name := range names(prefix, n) {
+			fmt.Fprintf(&out, "let %s = %d; ", name, i)
+		}
+		return out.String()
+	}
+	integers := func(from, to int) string {
+		items := make([]string, to-from)
+		for i := range items {
+			items[i] = fmt.Sprint(from + i)
+		}
+		return strings.Join(items, ", ")
+	}
+
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "let locals = fn() { %s %s + %s };\n",
+		lets("l", 300), name("l", 0), name("l", 299))
+	fmt.Fprintf(&out, "let args = fn(%s) { %s + %s };\n",
+		strings.Join(names("a", 300), ", "), name("a", 0), name("a", 299))
+	fmt.Fprintf(&out, "let free = fn() { %s fn() { %s } };\n",
+		lets("v", 300), strings.Join(names("v", 300), " + "))
+
+	// Each integer is a new constant, and the block of the if is far longer
+	// than 64 KB.
+	chunks := make([]string, 70)
+	for i := range chunks {
+		chunks[i] = fmt.Sprintf("len([%s])", integers(i*1000, (i+1)*1000))
+	}
+	fmt.Fprintf(&out, "let t = len([1]) == 1;\n")
+	fmt.Fprintf(&out, "let big = if (t) { %s } else { 0 };\n", strings.Join(chunks, " + "))
+
+	fmt.Fprintf(&out, "let after = fn(x) { x + 70001 };\n")
+	fmt.Fprintf(&out, "let h = {\"last\": 5};\n")
+	fmt.Fprintf(&out, "[locals(), args(%s), free()(), big, after(1), h.last]",
+		integers(0, 300))
+
+	return out.String()
+}
+
 // Other
 
 func TestConditionals(t *testing.T) {
@@ -565,30 +1020,174 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
-// Helpers
+func TestMacros(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
 
-func runVmTests(t *testing.T, tests []vmTestCase) {
-	t.Helper()
+			unless(10 > 5, 1, 2);
+			`,
+			expected: 2,
+		},
+		{
+			input: `
+			let benchmark = macro(body) {
+				quote(fn() { let start = 1000; unquote(body) }());
+			};
+
+			let start = 1;
+			let f = fn(x) { benchmark(x + start) };
+			f(41);
+			`,
+			expected: 42,
+		},
+		{
+			input:    `let q = quote(1 + 2); q`,
+			expected: "QUOTE((1 + 2))",
+		},
+	}
 
 	for _, tt := range tests {
 		program := parse(tt.input)
 
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			t.Fatalf("macro expansion error: %s", err)
+		}
+
 		comp := compiler.New()
-		if err := comp.Compile(program); err != nil {
+		if err := comp.Compile(expanded); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		for _, engine := range engines {
+			vm := engine.new(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("%s: vm error: %s", engine.name, err)
+			}
+
+			stackElem := vm.LastPoppedStackElem()
+			if quote, ok := stackElem.(*object.Quote); ok {
+				if quote.Inspect() != tt.expected {
+					t.Errorf("%s: wrong quote. want=%q, got=%q",
+						engine.name, tt.expected, quote.Inspect())
+				}
+				continue
+			}
+
+			testExpectedObject(t, tt.expected, stackElem)
+		}
+	}
+}
+
+func TestProgramsLoadedFromJSON(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(10)", 55},
+		{"let add = fn(a) { fn(b) { a + b } }; add(1)(2)", 3},
+		{`let book = {"title": "Go", "pages": 300}; book.title + "!"`, "Go!"},
+		{"let a = [1, 2, 3]; a.push(4)[3] + a?[0]", 5},
+		{"null?.x ?? -1", -1},
+		{"if (false) { 1 }", Null},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		data, err := ast.Marshal(program)
+		if err != nil {
+			t.Fatalf("marshal error: %s", err)
+		}
+		loaded, err := ast.UnmarshalProgram(data)
+		if err != nil {
+			t.Fatalf("unmarshal error: %s", err)
+		}
+
+		original := compiler.New()
+		if err := original.Compile(program); err != nil {
 			t.Fatalf("compiler error: %s", err)
 		}
+		comp := compiler.New()
+		if err := comp.Compile(loaded); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		want, got := original.Bytecode(), comp.Bytecode()
+		if got.Instructions.String() != want.Instructions.String() {
+			t.Errorf("%q: wrong instructions.\nwant=%s\ngot=%s",
+				tt.input, want.Instructions, got.Instructions)
+		}
+		if len(got.Constants) != len(want.Constants) {
+			t.Errorf("%q: wrong number of constants. want=%d, got=%d",
+				tt.input, len(want.Constants), len(got.Constants))
+		}
 
-		vm := New(comp.Bytecode())
-		if err := vm.Run(); err != nil {
-			t.Fatalf("vm error: %s", err)
+		for _, engine := range engines {
+			vm := engine.new(got)
+			if err := vm.Run(); err != nil {
+				t.Fatalf("%s: vm error: %s", engine.name, err)
+			}
+
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
 		}
+	}
+}
 
-		stackElem := vm.LastPoppedStackElem()
+// Helpers
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, level := range optimizationLevels {
+		for _, tt := range tests {
+			program := parse(tt.input)
+
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(program); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			for _, engine := range engines {
+				vm := engine.new(comp.Bytecode())
+				if err := vm.Run(); err != nil {
+					t.Fatalf("%s: vm error: %s", engine.name, err)
+				}
 
-		testExpectedObject(t, tt.expected, stackElem)
+				stackElem := vm.LastPoppedStackElem()
+
+				testExpectedObject(t, tt.expected, stackElem)
+			}
+		}
 	}
 }
 
+// optimizationLevels are the levels runVmTests compiles every test at.
+var optimizationLevels = []compiler.OptimizationLevel{
+	compiler.OptimizeNone,
+	compiler.OptimizeConstants,
+	compiler.OptimizePeephole,
+	compiler.OptimizeDeadCode,
+	compiler.OptimizeInline,
+	compiler.OptimizeSpecialize,
+}
+
+// engines are the VMs the tests run the bytecode on.
+var engines = []struct {
+	name string
+	new  func(*compiler.Bytecode) Machine
+}{
+	{"stack", func(bytecode *compiler.Bytecode) Machine { return New(bytecode) }},
+	{"register", func(bytecode *compiler.Bytecode) Machine { return NewRegister(bytecode) }},
+}
+
 func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 	t.Helper()
 
@@ -628,27 +1227,27 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 
 		}
 
-	case map[object.HashKey]int64:
+	case []hashPair:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
-			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+		for i, pair := range hash.Pairs() {
+			if pair.Key.(object.Hashable).HashKey() != expected[i].key.HashKey() {
+				t.Errorf("wrong key for pair %d. want=%s, got=%s",
+					i, expected[i].key.Inspect(), pair.Key.Inspect())
 				return
 			}
 
-			if err := testIntegerObject(expectedValue, pair.Value); err != nil {
+			if err := testIntegerObject(expected[i].value, pair.Value); err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 				return
 			}